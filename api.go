@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mt2d2/forum/model"
+)
+
+// maxAPILimit caps the number of posts or topics returned by one api request.
+const maxAPILimit = 100
+
+// apiLimit reads the limit query parameter, defaulting to a page of the html
+// view.
+func apiLimit(req *http.Request, pageSize int) int {
+	limit, err := strconv.Atoi(req.URL.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		return pageSize
+	}
+	if limit > maxAPILimit {
+		return maxAPILimit
+	}
+	return limit
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Println("json:", err)
+	}
+}
+
+// handleAPIPosts lists the posts of a topic in pages of limit, following the
+// after cursor or preceding the before cursor. Each page links to its
+// neighbours with the next and prev cursors, left out at either end. With
+// rows=1 the posts are also rendered as they appear on the topic page, which
+// is how the topic page scrolls on.
+func (app *app) handleAPIPosts(w http.ResponseWriter, req *http.Request) {
+	topic, err := model.FindOneTopic(app.db, mux.Vars(req)["id"])
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+	id := strconv.Itoa(topic.Id)
+
+	cursors := make(map[string]*model.PostCursor)
+	for _, name := range []string{"after", "before"} {
+		if value := req.URL.Query().Get(name); value != "" {
+			cursor, err := model.ParsePostCursor(value)
+			if err != nil {
+				app.handleError(w, req, model.NewValidationError(err))
+				return
+			}
+			cursors[name] = &cursor
+		}
+	}
+
+	// one more post than asked for tells whether there is another page
+	postsPerPage, _ := app.pageSizes(req)
+	limit := apiLimit(req, postsPerPage)
+	var posts []model.Post
+	next, prev := "", ""
+	if before, ok := cursors["before"]; ok && cursors["after"] == nil {
+		posts, err = model.FindPostsBefore(app.db, id, before, limit+1)
+		if len(posts) > limit {
+			posts = posts[1:]
+			prev = posts[0].Cursor().String()
+		}
+		if len(posts) > 0 {
+			next = posts[len(posts)-1].Cursor().String()
+		}
+	} else {
+		posts, err = model.FindPostsAfter(app.db, id, cursors["after"], limit+1)
+		if len(posts) > limit {
+			posts = posts[:limit]
+			next = posts[limit-1].Cursor().String()
+		}
+		if len(posts) > 0 && cursors["after"] != nil {
+			prev = posts[0].Cursor().String()
+		}
+	}
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	rendered, err := app.renderPosts(posts)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	data := make([]map[string]interface{}, 0, len(posts))
+	for i := range posts {
+		post := app.postWebhookData(&posts[i])
+		post["username"] = posts[i].User.Username
+		post["published"] = posts[i].Published
+		post["html"] = rendered[posts[i].Id]
+		if posts[i].ReplyTo != -1 {
+			post["reply_to"] = posts[i].ReplyTo
+		}
+		data = append(data, post)
+	}
+
+	results := map[string]interface{}{"posts": data}
+	if next != "" {
+		results["next"] = next
+	}
+	if prev != "" {
+		results["prev"] = prev
+	}
+
+	if req.URL.Query().Get("rows") == "1" {
+		rows, err := app.renderPostRows(posts, app.topicPage(req, topic))
+		if err != nil {
+			app.handleError(w, req, err)
+			return
+		}
+		results["rows"] = rows
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+// handleAPITopics lists the topics of a forum like handleAPIPosts, with topic
+// ids for cursors.
+func (app *app) handleAPITopics(w http.ResponseWriter, req *http.Request) {
+	forum, err := model.FindOneForum(app.db, mux.Vars(req)["id"])
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+	id := strconv.Itoa(forum.Id)
+
+	cursors := make(map[string]int)
+	for _, name := range []string{"after", "before"} {
+		if value := req.URL.Query().Get(name); value != "" {
+			cursor, err := strconv.Atoi(value)
+			if err != nil || cursor < 1 {
+				app.handleError(w, req, model.NewValidationError(errors.New("invalid cursor "+value)))
+				return
+			}
+			cursors[name] = cursor
+		}
+	}
+
+	_, topicsPerPage := app.pageSizes(req)
+	limit := apiLimit(req, topicsPerPage)
+	var topics []model.Topic
+	next, prev := 0, 0
+	if before, ok := cursors["before"]; ok && cursors["after"] == 0 {
+		topics, err = model.FindTopicsBefore(app.db, id, before, limit+1)
+		if len(topics) > limit {
+			topics = topics[1:]
+			prev = topics[0].Id
+		}
+		if len(topics) > 0 {
+			next = topics[len(topics)-1].Id
+		}
+	} else {
+		topics, err = model.FindTopicsAfter(app.db, id, cursors["after"], limit+1)
+		if len(topics) > limit {
+			topics = topics[:limit]
+			next = topics[limit-1].Id
+		}
+		if len(topics) > 0 && cursors["after"] != 0 {
+			prev = topics[0].Id
+		}
+	}
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	data := make([]map[string]interface{}, 0, len(topics))
+	for i := range topics {
+		topic := app.topicWebhookData(&topics[i])
+		topic["post_count"] = topics[i].PostCount
+		if topics[i].LastPostId != -1 {
+			topic["last_post_id"] = topics[i].LastPostId
+			topic["last_post_at"] = topics[i].LastPostAt
+		}
+		data = append(data, topic)
+	}
+
+	results := map[string]interface{}{"topics": data}
+	if next != 0 {
+		results["next"] = strconv.Itoa(next)
+	}
+	if prev != 0 {
+		results["prev"] = strconv.Itoa(prev)
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/mt2d2/forum/model"
+)
+
+type apiTestPage struct {
+	Posts []struct {
+		Id   int    `json:"id"`
+		HTML string `json:"html"`
+	} `json:"posts"`
+	Topics []struct {
+		Id int `json:"id"`
+	} `json:"topics"`
+	Next string `json:"next"`
+	Prev string `json:"prev"`
+}
+
+func getAPIPage(t *testing.T, router *mux.Router, url string) apiTestPage {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s returned %d: %s", url, w.Code, w.Body.String())
+	}
+
+	var page apiTestPage
+	err := json.Unmarshal(w.Body.Bytes(), &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestHandleAPIPosts(t *testing.T) {
+	db, err := model.GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown, err := newMarkdownRenderer("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &app{db: db, sessions: sessions.NewCookieStore([]byte("test")), markdown: markdown, previews: newLinkPreviewer("", 0)}
+	router := mux.NewRouter()
+	router.HandleFunc("/api/topic/{id:[0-9]+}/posts", app.handleAPIPosts)
+	router.HandleFunc("/api/forum/{id:[0-9]+}/topics", app.handleAPITopics)
+
+	// topic 1 has 11 posts
+	ids := make([]int, 0)
+	page := getAPIPage(t, router, "/api/topic/1/posts?limit=4")
+	for {
+		for _, post := range page.Posts {
+			if post.HTML == "" {
+				t.Errorf("post %d should be rendered", post.Id)
+			}
+			ids = append(ids, post.Id)
+		}
+		if page.Next == "" {
+			break
+		}
+		page = getAPIPage(t, router, "/api/topic/1/posts?limit=4&after="+page.Next)
+	}
+	if len(ids) != 11 {
+		t.Errorf("should walk all 11 posts, got %v", ids)
+	}
+
+	if page.Prev == "" {
+		t.Fatal("the last page should link to the one before")
+	}
+	previous := getAPIPage(t, router, "/api/topic/1/posts?limit=4&before="+page.Prev)
+	if len(previous.Posts) != 4 || previous.Posts[0].Id != ids[4] || previous.Next == "" {
+		t.Errorf("wrong previous page %v", previous)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/topic/1/posts?after=nope", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid cursors should be rejected, got %d", w.Code)
+	}
+
+	topics := getAPIPage(t, router, "/api/forum/1/topics?limit=2")
+	if len(topics.Topics) != 2 || topics.Next != "4" || topics.Prev != "" {
+		t.Errorf("wrong first page of topics %v", topics)
+	}
+	topics = getAPIPage(t, router, "/api/forum/1/topics?limit=2&after="+topics.Next)
+	if len(topics.Topics) != 1 || topics.Topics[0].Id != 5 || topics.Next != "" || topics.Prev != "5" {
+		t.Errorf("wrong second page of topics %v", topics)
+	}
+}
@@ -1,36 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
-	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"reflect"
-	"regexp"
 
 	"github.com/GeertJohan/go.rice"
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
-	"github.com/microcosm-cc/bluemonday"
 	"github.com/mt2d2/forum/model"
-	"github.com/russross/blackfriday"
 )
 
-func convertToMarkdown(markdown string) template.HTML {
-	unsafe := blackfriday.MarkdownCommon([]byte(markdown))
-
-	policy := bluemonday.UGCPolicy()
-	policy.AllowElements("video", "audio", "source")
-	policy.AllowAttrs("controls").OnElements("video", "audio")
-	policy.AllowAttrs("src").Matching(regexp.MustCompile(`[\p{L}\p{N}\s\-_',:\[\]!\./\\\(\)&]*`)).Globally()
-
-	html := policy.SanitizeBytes(unsafe)
-	return template.HTML(html)
-}
-
 func isLastElement(x int, list interface{}) bool {
 	val := reflect.ValueOf(list)
 	if val.Kind() == reflect.Ptr && !val.IsNil() {
@@ -40,21 +25,61 @@ func isLastElement(x int, list interface{}) bool {
 	return x == val.Len()-1
 }
 
-type breadCrumb struct{ URL, Title string }
-
 type app struct {
-	templates   *template.Template
-	db          *sql.DB
-	sessions    *sessions.CookieStore
-	breadCrumbs []breadCrumb
+	templates *template.Template
+	reloader  *templateReloader
+	themes    *themes
+	site      *siteSettings
+	locales   *locales
+	db        *sql.DB
+	sessions  *sessions.CookieStore
+	mailer    mailer
+	hub       *hub
+	presence  *presence
+	storage   storage
+	markdown  *markdownRenderer
+	previews  *linkPreviewer
 }
 
-func embedTemplate(box *rice.Box, tplName string) string {
-	tpl, err := box.String(tplName)
-	if err != nil {
-		return ""
+// templateNames lists the templates in the templates box, partials included.
+var templateNames = []string{
+	"header.html", "footer.html", "index.html", "forum.html", "topic.html",
+	"post.html", "pager.html", "addPost.html", "editor.html", "addTopic.html",
+	"register.html", "login.html", "profile.html", "notification.html",
+	"notifications.html", "subscriptions.html", "preferences.html",
+	"messages.html", "conversation.html", "addConversation.html",
+	"webhooks.html", "webhook.html", "error.html", "settings.html",
+}
+
+// parseTemplates parses every template, reading its source with read, the
+// templates box in production. T, N and date of the parsed templates are
+// English, locales.localize copies them for other languages.
+func parseTemplates(markdown *markdownRenderer, read func(name string) (string, error)) (*template.Template, error) {
+	funcMap := template.FuncMap{
+		"markDown": func(text string) template.HTML {
+			return markdown.render(-1, text)
+		},
+		"last":     isLastElement,
+		"postRow":  postRow,
+		"fileSize": model.FormatSize,
+		"T":        english.T,
+		"N":        english.N,
+		"date":     english.date}
+
+	templates := template.New("").Funcs(funcMap)
+	for _, name := range templateNames {
+		source, err := read(name)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = templates.New(name).Parse(source)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return fmt.Sprintf("{{define \"%s\"}}%s{{end}}", tplName, tpl)
+
+	return templates, nil
 }
 
 func newApp() *app {
@@ -63,43 +88,48 @@ func newApp() *app {
 		log.Panicln(err)
 	}
 
-	funcMap := template.FuncMap{
-		"markDown": convertToMarkdown,
-		"last":     isLastElement}
-
+	markdown := newMarkdown()
+	markdown.users = func(username string) bool {
+		_, err := model.FindOneUserByUsername(db, username)
+		return err == nil
+	}
 	templateBox := rice.MustFindBox("templates")
-	templates := template.New("").Funcs(funcMap)
-	templates.Parse(embedTemplate(templateBox, "header.html"))
-	templates.Parse(embedTemplate(templateBox, "footer.html"))
-	templates.Parse(embedTemplate(templateBox, "index.html"))
-	templates.Parse(embedTemplate(templateBox, "forum.html"))
-	templates.Parse(embedTemplate(templateBox, "topic.html"))
-	templates.Parse(embedTemplate(templateBox, "addPost.html"))
-	templates.Parse(embedTemplate(templateBox, "addTopic.html"))
-	templates.Parse(embedTemplate(templateBox, "register.html"))
-	templates.Parse(embedTemplate(templateBox, "login.html"))
+	templates, err := parseTemplates(markdown, templateBox.String)
+	if err != nil {
+		log.Panicln(err)
+	}
+	themes := newThemes(*themesDir, markdown, templateBox.String)
+
+	locales, err := loadLocales(rice.MustFindBox("locales").String)
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	var reloader *templateReloader
+	if *dev {
+		reloader = newTemplateReloader("templates", themes, markdown)
+		log.Println("development mode, reloading templates from templates/")
+	}
+
+	settings, err := model.FindSettings(db)
+	if err != nil {
+		log.Panicln(err)
+	}
+	if !themes.exists(settings.Theme) {
+		log.Printf("theme %s is not installed, using the default look\n", settings.Theme)
+		settings.Theme = ""
+	}
 
 	sessionStore := sessions.NewCookieStore(securecookie.GenerateRandomKey(64), securecookie.GenerateRandomKey(32))
 
-	breadCrumbs := make([]breadCrumb, 0, 1)
-	breadCrumbs = append(breadCrumbs, breadCrumb{"/", "Index"})
-	return &app{templates, db, sessionStore, breadCrumbs}
+	return &app{templates, reloader, themes, &siteSettings{settings: *settings}, locales, db, sessionStore, newMailer(), newHub(), newPresence(), newStorage(), markdown,
+		newLinkPreviewer(*linkPreviewHosts, linkPreviewTimeout)}
 }
 
 func (app *app) destroy() {
 	app.db.Close()
 }
 
-func (app *app) addBreadCrumb(url, title string) {
-	app.breadCrumbs = append(app.breadCrumbs, breadCrumb{url, title})
-}
-
-func (app *app) useBreadCrumbs() *[]breadCrumb {
-	ret := app.breadCrumbs
-	app.breadCrumbs = app.breadCrumbs[:1]
-	return &ret
-}
-
 func (app *app) addErrorFlashes(w http.ResponseWriter, r *http.Request, errs []error) {
 	for _, err := range errs {
 		app.addErrorFlash(w, r, err)
@@ -107,11 +137,11 @@ func (app *app) addErrorFlashes(w http.ResponseWriter, r *http.Request, errs []e
 }
 
 func (app *app) addErrorFlash(w http.ResponseWriter, r *http.Request, error error) {
-	app.addFlash(w, r, error.Error(), "error")
+	app.addFlash(w, r, app.locale(r).error(error), "error")
 }
 
 func (app *app) addSuccessFlash(w http.ResponseWriter, r *http.Request, str string) {
-	app.addFlash(w, r, str, "success")
+	app.addFlash(w, r, app.T(r, str), "success")
 }
 
 func (app *app) addFlash(w http.ResponseWriter, r *http.Request, content interface{}, key string) {
@@ -120,26 +150,64 @@ func (app *app) addFlash(w http.ResponseWriter, r *http.Request, content interfa
 	session.Save(r, w)
 }
 
-func (app *app) renderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, data map[string]interface{}) {
+func (app *app) renderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, data view) {
+	app.renderTemplateStatus(w, r, tmpl, data, http.StatusOK)
+}
+
+func (app *app) renderTemplateStatus(w http.ResponseWriter, r *http.Request, tmpl string, data view, status int) {
 	session, _ := app.sessions.Get(r, "forumSession")
 
-	data["breadCrumbs"] = app.useBreadCrumbs()
-	data["errorFlashes"] = session.Flashes("error")
-	data["successFlashes"] = session.Flashes("success")
+	locale := app.locale(r)
+
+	base := data.base()
+	base.Site = app.site.get()
+	base.Stylesheet = app.themes.stylesheet(base.Site.Theme)
+	base.Language = locale.Tag
+	base.BreadCrumbs = append([]breadCrumb{{"/", locale.T("Index")}}, pageOf(r).breadCrumbs...)
+	base.ErrorFlashes = session.Flashes("error")
+	base.SuccessFlashes = session.Flashes("success")
 
 	if userID, ok := session.Values["user_id"].(int); ok {
 		user, err := model.FindOneUserById(app.db, userID)
 		if err == nil {
-			data["user"] = user
+			base.User = &user
+			base.Admin = isAdmin(user.Username)
+		}
+
+		notifications, err := model.FindUnreadNotifications(app.db, userID, limitNotifications)
+		if err == nil {
+			base.Notifications = notifications
+		}
+
+		unreadCount, err := model.CountUnreadNotifications(app.db, userID)
+		if err == nil {
+			base.UnreadNotifications = unreadCount
+		}
+
+		unreadMessages, err := model.CountUnreadMessages(app.db, userID)
+		if err == nil {
+			base.UnreadMessages = unreadMessages
+		}
+
+		prefs, err := model.FindPreferences(app.db, userID)
+		if err == nil {
+			base.Variant = prefs.Variant
 		}
 	}
 
 	session.Save(r, w)
 
-	w.Header().Set("Content-Type", "text/html")
-	err := app.templates.ExecuteTemplate(w, tmpl+".html", data)
+	var page bytes.Buffer
+	templates, err := app.loadTemplates(locale)
+	if err == nil {
+		err = templates.ExecuteTemplate(&page, tmpl+".html", data)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		app.templateError(w, r, tmpl, err)
 		return
 	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(status)
+	page.WriteTo(w)
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mt2d2/forum/model"
+)
+
+const (
+	// maxAttachmentsPerPost is the number of files that can be uploaded with
+	// a single post.
+	maxAttachmentsPerPost = 5
+	// maxUploadMemory is how much of a multipart form is kept in memory, the
+	// rest is buffered in temporary files.
+	maxUploadMemory = 8 << 20
+)
+
+func attachmentLimits() model.AttachmentLimits {
+	types := make([]string, 0)
+	for _, contentType := range strings.Split(*attachmentTypes, ",") {
+		if contentType = strings.TrimSpace(contentType); contentType != "" {
+			types = append(types, contentType)
+		}
+	}
+
+	return model.AttachmentLimits{MaxSize: *attachmentMaxSize, UserQuota: *attachmentQuota, Types: types}
+}
+
+// maxPostSize bounds the request body of a new post with attachments.
+func maxPostSize() int64 {
+	return maxAttachmentsPerPost*(*attachmentMaxSize) + 1<<20
+}
+
+type upload struct {
+	attachment *model.Attachment
+	file       *multipart.FileHeader
+}
+
+// sniffContentType detects the type of an upload from its content, ignoring
+// what the browser claims.
+func sniffContentType(file *multipart.FileHeader) (string, error) {
+	f, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if err != nil {
+		return "", err
+	}
+	return mediaType, nil
+}
+
+// uploads returns the files sent in the Attachments field of a multipart form.
+func uploads(req *http.Request, userId int) ([]upload, error) {
+	if req.MultipartForm == nil {
+		return nil, nil
+	}
+
+	files := req.MultipartForm.File["Attachments"]
+	result := make([]upload, 0, len(files))
+	for _, file := range files {
+		// browsers send an empty part when no file was chosen
+		if file.Filename == "" && file.Size == 0 {
+			continue
+		}
+
+		contentType, err := sniffContentType(file)
+		if err != nil {
+			return nil, err
+		}
+
+		attachment := model.NewAttachment()
+		attachment.UserId = userId
+		attachment.Filename = path.Base(strings.Replace(file.Filename, "\\", "/", -1))
+		attachment.ContentType = contentType
+		attachment.Size = file.Size
+
+		result = append(result, upload{attachment, file})
+	}
+
+	return result, nil
+}
+
+func (app *app) validateUploads(userId int, files []upload) (ok bool, errs []error) {
+	if len(files) == 0 {
+		return true, nil
+	}
+	if len(files) > maxAttachmentsPerPost {
+		return false, []error{model.Errorf("Posts can have at most %d attachments.", maxAttachmentsPerPost)}
+	}
+
+	attachments := make([]*model.Attachment, len(files))
+	for i, file := range files {
+		attachments[i] = file.attachment
+	}
+
+	return model.ValidateAttachments(app.db, userId, attachments, attachmentLimits())
+}
+
+// storeUploads writes uploaded files to storage before the post they belong
+// to is saved, returning their attachments. Files already stored are deleted
+// again when one fails.
+func (app *app) storeUploads(files []upload) ([]*model.Attachment, error) {
+	attachments := make([]*model.Attachment, 0, len(files))
+	for _, file := range files {
+		key, err := newStorageKey()
+		if err != nil {
+			app.deleteUploads(attachments)
+			return nil, err
+		}
+
+		f, err := file.file.Open()
+		if err != nil {
+			app.deleteUploads(attachments)
+			return nil, err
+		}
+		err = app.storage.Save(key, f)
+		f.Close()
+		if err != nil {
+			app.deleteUploads(attachments)
+			return nil, err
+		}
+
+		file.attachment.StorageKey = key
+		attachments = append(attachments, file.attachment)
+	}
+
+	return attachments, nil
+}
+
+// deleteUploads removes the files of attachments whose post could not be
+// saved.
+func (app *app) deleteUploads(attachments []*model.Attachment) {
+	for _, attachment := range attachments {
+		app.deleteStored(attachment.StorageKey)
+	}
+}
+
+// deleteStoredAttachments removes the files of attachments whose rows are
+// already gone.
+func (app *app) deleteStoredAttachments(attachments []model.Attachment) {
+	for _, attachment := range attachments {
+		app.deleteStored(attachment.StorageKey)
+	}
+}
+
+// deleteStored removes a file from storage. Failures are only logged, the
+// file is merely left behind.
+func (app *app) deleteStored(key string) {
+	if err := app.storage.Delete(key); err != nil {
+		log.Println("attachment:", err)
+	}
+}
+
+func postIds(posts []model.Post) []int {
+	ids := make([]int, len(posts))
+	for i, post := range posts {
+		ids[i] = post.Id
+	}
+	return ids
+}
+
+// handleAttachment serves an attachment, showing images inline and offering
+// everything else as a download.
+func (app *app) handleAttachment(w http.ResponseWriter, req *http.Request) {
+	attachment, err := model.FindOneAttachment(app.db, mux.Vars(req)["id"])
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	f, err := app.storage.Open(attachment.StorageKey)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+	defer f.Close()
+
+	disposition := "attachment"
+	if attachment.IsImage() {
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	io.Copy(w, f)
+}
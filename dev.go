@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// templateSourceLines is how many lines around a failing template line the
+// debug page shows.
+const templateSourceLines = 5
+
+// templateReloader parses the templates in a directory again whenever one of
+// them, or of the active theme's, changes, so that they can be edited without
+// restarting the forum.
+type templateReloader struct {
+	dir      string
+	themes   *themes
+	markdown *markdownRenderer
+
+	mu        sync.Mutex
+	parsed    bool
+	theme     string
+	modified  time.Time
+	templates *template.Template
+	err       error
+}
+
+func newTemplateReloader(dir string, themes *themes, markdown *markdownRenderer) *templateReloader {
+	return &templateReloader{dir: dir, themes: themes, markdown: markdown}
+}
+
+func (reloader *templateReloader) read(name string) (string, error) {
+	source, err := ioutil.ReadFile(filepath.Join(reloader.dir, name))
+	return string(source), err
+}
+
+// path returns the file a template of a theme is read from.
+func (reloader *templateReloader) path(theme, name string) string {
+	if theme != "" {
+		path := reloader.themes.templatePath(theme, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(reloader.dir, name)
+}
+
+// load returns the templates of a theme, parsing them first if the theme
+// changed or any of them was modified since they were last parsed. A failed
+// parse is remembered until the broken template is saved again.
+func (reloader *templateReloader) load(theme string) (*template.Template, error) {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+
+	read := reloader.read
+	if theme != "" {
+		read = reloader.themes.reader(theme, reloader.read)
+	}
+
+	var modified time.Time
+	for _, name := range templateNames {
+		info, err := os.Stat(filepath.Join(reloader.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+
+		if theme != "" {
+			if info, err := os.Stat(reloader.themes.templatePath(theme, name)); err == nil && info.ModTime().After(modified) {
+				modified = info.ModTime()
+			}
+		}
+	}
+
+	if reloader.parsed && theme == reloader.theme && modified.Equal(reloader.modified) {
+		return reloader.templates, reloader.err
+	}
+
+	reloader.templates, reloader.err = parseTemplates(reloader.markdown, read)
+	reloader.parsed = true
+	reloader.theme = theme
+	reloader.modified = modified
+	if reloader.err == nil {
+		log.Println("templates reloaded")
+	}
+	return reloader.templates, reloader.err
+}
+
+// loadTemplates returns the templates to render with in a locale, reloaded
+// from disk in development mode.
+func (app *app) loadTemplates(locale *locale) (*template.Template, error) {
+	templates, err := app.themeTemplates()
+	if err != nil {
+		return nil, err
+	}
+	return app.locales.localize(templates, locale)
+}
+
+func (app *app) themeTemplates() (*template.Template, error) {
+	theme := app.site.get().Theme
+	if app.reloader != nil {
+		return app.reloader.load(theme)
+	}
+	if theme == "" {
+		return app.templates, nil
+	}
+	return app.themes.load(theme)
+}
+
+// templateError answers a request whose page could not be rendered. In
+// development mode the error is shown with the template source around it.
+func (app *app) templateError(w http.ResponseWriter, req *http.Request, tmpl string, err error) {
+	log.Printf("request %s: rendering %s: %v\n", requestID(req), tmpl, err)
+
+	if app.reloader == nil {
+		http.Error(w, app.T(req, "Something went wrong on our side. If this keeps happening, mention request %s when reporting it.", requestID(req)),
+			http.StatusInternalServerError)
+		return
+	}
+
+	page := templateErrorPage{Template: tmpl, Error: err.Error(), RequestId: requestID(req)}
+	theme := app.site.get().Theme
+	page.File, page.Line, page.Source = templateSource(func(name string) string {
+		return app.reloader.path(theme, name)
+	}, err)
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusInternalServerError)
+	err = templateErrorTemplate.Execute(w, page)
+	if err != nil {
+		log.Printf("request %s: rendering the template error page: %v\n", requestID(req), err)
+	}
+}
+
+// templateErrorLocation finds the template file and line an error of
+// text/template points at, as in "template: forum.html:4:29: executing ...".
+var templateErrorLocation = regexp.MustCompile(`template: ([^:\s]+):(\d+)`)
+
+type sourceLine struct {
+	Number int
+	Text   string
+	Failed bool
+}
+
+// templateSource reads the lines of the template an error points at around
+// the failing line, from the file path returns for it.
+func templateSource(path func(name string) string, err error) (string, int, []sourceLine) {
+	match := templateErrorLocation.FindStringSubmatch(err.Error())
+	if match == nil {
+		return "", 0, nil
+	}
+	file := match[1]
+	line, _ := strconv.Atoi(match[2])
+
+	source, err := os.Open(path(filepath.Base(file)))
+	if err != nil {
+		return file, line, nil
+	}
+	defer source.Close()
+
+	lines := make([]sourceLine, 0, 2*templateSourceLines+1)
+	scanner := bufio.NewScanner(source)
+	for number := 1; scanner.Scan(); number++ {
+		if number < line-templateSourceLines {
+			continue
+		}
+		if number > line+templateSourceLines {
+			break
+		}
+		lines = append(lines, sourceLine{number, scanner.Text(), number == line})
+	}
+
+	return file, line, lines
+}
+
+type templateErrorPage struct {
+	Template  string
+	Error     string
+	RequestId string
+	File      string
+	Line      int
+	Source    []sourceLine
+}
+
+// templateErrorTemplate stands on its own, the forum's templates may be the
+// ones that are broken.
+var templateErrorTemplate = template.Must(template.New("templateError").Parse(`<!DOCTYPE html>
+<html lang="en">
+	<head>
+		<meta charset="utf-8">
+		<title>Template error</title>
+		<style>
+			body { font-family: sans-serif; margin: 2em; color: #333; }
+			h1 { color: #a94442; }
+			.error { background: #f2dede; border: 1px solid #ebccd1; padding: 1em; white-space: pre-wrap; }
+			table { border-collapse: collapse; font-family: monospace; margin-top: 1em; }
+			td { padding: 0 .5em; white-space: pre; }
+			td.number { color: #999; text-align: right; }
+			tr.failed { background: #fcf8e3; font-weight: bold; }
+		</style>
+	</head>
+	<body>
+		<h1>Could not render {{.Template}}</h1>
+		<div class="error">{{.Error}}</div>
+		{{if .File}}
+		<h2>{{.File}}, line {{.Line}}</h2>
+		<table>
+			{{range .Source}}
+			<tr{{if .Failed}} class="failed"{{end}}><td class="number">{{.Number}}</td><td>{{.Text}}</td></tr>
+			{{end}}
+		</table>
+		{{end}}
+		<p><small>Request {{.RequestId}}. Save the template to try again.</small></p>
+	</body>
+</html>
+`))
+
+// withoutCaching keeps browsers from caching responses, so that edited static
+// files are picked up on the next reload.
+func withoutCaching(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.Header.Del("If-Modified-Since")
+		req.Header.Del("If-None-Match")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		w.Header().Set("Expires", "0")
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/mt2d2/forum/model"
+)
+
+// copyTemplates copies the templates into a temporary directory that can be
+// edited by a test.
+func copyTemplates(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range templateNames {
+		source, err := ioutil.ReadFile(filepath.Join("templates", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, name), source, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+// editTemplate replaces a template, moving its modification time forward so
+// the change is seen even within the file system's time resolution.
+func editTemplate(t *testing.T, dir, name, source string, at time.Time) {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte(source), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(path, at, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTemplateReloader(t *testing.T) {
+	dir := copyTemplates(t)
+	defer os.RemoveAll(dir)
+
+	markdown, err := newMarkdownRenderer("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader := newTemplateReloader(dir, nil, markdown)
+
+	render := func() (string, error) {
+		templates, err := reloader.load("")
+		if err != nil {
+			return "", err
+		}
+		var page bytes.Buffer
+		err = templates.ExecuteTemplate(&page, "pager.html", newPager("/forum/1", 1, 2))
+		return page.String(), err
+	}
+
+	first, err := render()
+	if err != nil || !strings.Contains(first, "pagination") {
+		t.Fatalf("first render returned %q, %v", first, err)
+	}
+	unchanged, _ := reloader.load("")
+	if again, _ := reloader.load(""); again != unchanged {
+		t.Error("unchanged templates were parsed again")
+	}
+
+	now := time.Now()
+	editTemplate(t, dir, "pager.html", "edited pager", now.Add(time.Minute))
+	if page, err := render(); err != nil || page != "edited pager" {
+		t.Errorf("edited render returned %q, %v", page, err)
+	}
+
+	editTemplate(t, dir, "pager.html", "{{if}}", now.Add(2*time.Minute))
+	if _, err := render(); err == nil || !strings.Contains(err.Error(), "pager.html:1") {
+		t.Errorf("broken template returned %v", err)
+	}
+
+	editTemplate(t, dir, "pager.html", "fixed pager", now.Add(3*time.Minute))
+	if page, err := render(); err != nil || page != "fixed pager" {
+		t.Errorf("fixed render returned %q, %v", page, err)
+	}
+}
+
+func TestTemplateErrorPage(t *testing.T) {
+	dir := copyTemplates(t)
+	defer os.RemoveAll(dir)
+
+	source, err := ioutil.ReadFile(filepath.Join(dir, "forum.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := strings.Replace(string(source), "{{.Forum.Title}}", "{{.Forum.Titel}}", 1)
+	editTemplate(t, dir, "forum.html", broken, time.Now())
+
+	markdown, err := newMarkdownRenderer("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forum := &model.Forum{Id: 1, Title: "Forum"}
+
+	for _, dev := range []bool{false, true} {
+		app := &app{sessions: sessions.NewCookieStore([]byte("test")), markdown: markdown}
+		if dev {
+			app.reloader = newTemplateReloader(dir, nil, markdown)
+		} else {
+			app.templates, err = parseTemplates(markdown, newTemplateReloader(dir, nil, markdown).read)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/forum/1", nil)
+		app.renderTemplate(w, req, "forum", &forumView{Forum: forum})
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("dev %v returned %d", dev, w.Code)
+		}
+		body := w.Body.String()
+		if strings.Contains(body, "bootstrap.min.css") {
+			t.Errorf("dev %v sent part of the broken page", dev)
+		}
+		if dev != strings.Contains(body, `<tr class="failed"><td class="number">4</td><td>`) {
+			t.Errorf("dev %v shows the failing line %v:\n%s", dev, !dev, body)
+		}
+		if dev != strings.Contains(body, "can&#39;t evaluate field Titel") {
+			t.Errorf("dev %v shows the error %v:\n%s", dev, !dev, body)
+		}
+	}
+}
+
+func TestWithoutCaching(t *testing.T) {
+	handler := withoutCaching(http.FileServer(http.Dir("static")))
+
+	req := httptest.NewRequest("GET", "/main.js", nil)
+	req.Header.Set("If-Modified-Since", time.Now().UTC().Format(http.TimeFormat))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("conditional request returned %d", w.Code)
+	}
+	if !strings.Contains(w.Header().Get("Cache-Control"), "no-store") {
+		t.Errorf("Cache-Control is %q", w.Header().Get("Cache-Control"))
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/mt2d2/forum/model"
+)
+
+// runDigests periodically mails subscribers the posts they have not seen yet.
+// Immediate subscriptions are sent on every tick, daily and weekly ones once
+// their interval has passed.
+func (app *app) runDigests(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if err := app.sendDigests(now.UTC()); err != nil {
+			log.Println("digest:", err)
+		}
+	}
+}
+
+func (app *app) sendDigests(now time.Time) error {
+	subscriptions, err := model.FindDueSubscriptions(app.db, now)
+	if err != nil {
+		return err
+	}
+
+	// one mail per user and frequency
+	type digestKey struct {
+		userId    int
+		frequency string
+	}
+	digests := make(map[digestKey][]model.Subscription)
+	keys := make([]digestKey, 0)
+	for _, subscription := range subscriptions {
+		key := digestKey{subscription.UserId, subscription.Frequency}
+		if _, ok := digests[key]; !ok {
+			keys = append(keys, key)
+		}
+		digests[key] = append(digests[key], subscription)
+	}
+
+	for _, key := range keys {
+		err := app.sendDigest(key.userId, key.frequency, digests[key], now)
+		if err != nil {
+			log.Println("digest:", err)
+		}
+	}
+
+	return nil
+}
+
+func (app *app) sendDigest(userId int, frequency string, subscriptions []model.Subscription, now time.Time) error {
+	user, err := model.FindOneUserById(app.db, userId)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	lastPostIds := make([]int, len(subscriptions))
+	postCount := 0
+	topicTitles := make(map[int]string)
+	topicIds := make(map[int]bool)
+
+	for i, subscription := range subscriptions {
+		lastPostIds[i] = subscription.LastPostId
+
+		posts, err := model.FindSubscriptionPosts(app.db, subscription)
+		if err != nil {
+			return err
+		}
+		if len(posts) == 0 {
+			continue
+		}
+
+		if subscription.Topic != nil {
+			fmt.Fprintf(&body, "New posts in %s:\n\n", subscription.Topic.Title)
+		} else {
+			fmt.Fprintf(&body, "New posts in the %s forum:\n\n", subscription.Forum.Title)
+		}
+
+		for _, post := range posts {
+			if _, ok := topicTitles[post.TopicId]; !ok && subscription.Topic == nil {
+				if topic, err := model.FindOneTopic(app.db, strconv.Itoa(post.TopicId)); err == nil {
+					topicTitles[post.TopicId] = topic.Title
+				}
+			}
+
+			if subscription.Topic == nil {
+				fmt.Fprintf(&body, "%s wrote in %s:\n", post.User.Username, topicTitles[post.TopicId])
+			} else {
+				fmt.Fprintf(&body, "%s wrote:\n", post.User.Username)
+			}
+			fmt.Fprintf(&body, "%s\n%s/post/%d\n\n", post.Text, app.baseURL(), post.Id)
+
+			lastPostIds[i] = post.Id
+			topicIds[post.TopicId] = true
+			postCount++
+		}
+	}
+
+	// daily and weekly digests restart their interval even when empty
+	if postCount == 0 && frequency == model.FrequencyImmediate {
+		return nil
+	}
+
+	if postCount > 0 && user.Email != "" {
+		subject := fmt.Sprintf("%d new posts", postCount)
+		if frequency != model.FrequencyImmediate {
+			subject = fmt.Sprintf("Your %s digest: %s", frequency, subject)
+		}
+
+		// replies can only be posted when the mail is about a single topic
+		replyTo := ""
+		for topicId := range topicIds {
+			if len(topicIds) == 1 {
+				replyTo = replyAddress(user.Id, topicId, time.Now().Add(replyLifetime))
+			}
+		}
+		text := body.String()
+		if replyTo != "" {
+			text = replyMarker + "\n\n" + text
+		}
+
+		err = app.mailer.SendMail(user.Email, replyTo, subject, text)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, subscription := range subscriptions {
+		err := model.MarkSubscriptionSent(app.db, subscription.Id, now, lastPostIds[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
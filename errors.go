@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/mt2d2/forum/model"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	pageKey
+)
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// withRequestID tags every request with an id, sent back in the X-Request-ID
+// header and shown on error pages, that ties logged errors to what the user
+// saw.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := newRequestID()
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), requestIDKey, id)))
+	})
+}
+
+func requestID(req *http.Request) string {
+	if id, ok := req.Context().Value(requestIDKey).(string); ok {
+		return id
+	}
+	return "-"
+}
+
+// handleError answers a request that failed with err: 404 for records that do
+// not exist, 400 for invalid input, 403 for what the user may not do and 500
+// for anything else. Internal errors are logged with the request id rather
+// than shown, as their messages are not meant for users. The api answers in
+// json, everything else with the error page.
+func (app *app) handleError(w http.ResponseWriter, req *http.Request, err error) {
+	var (
+		notFound  *model.NotFoundError
+		invalid   *model.ValidationError
+		forbidden *model.ForbiddenError
+	)
+
+	locale := app.locale(req)
+	status := http.StatusInternalServerError
+	message := locale.T("Something went wrong on our side. Please try again later.")
+	switch {
+	case errors.As(err, &notFound):
+		status = http.StatusNotFound
+		message = locale.T("The page you were looking for does not exist.")
+	case errors.As(err, &invalid):
+		status = http.StatusBadRequest
+		messages := make([]string, 0, len(invalid.Errs))
+		for _, e := range invalid.Errs {
+			messages = append(messages, locale.error(e))
+		}
+		message = strings.Join(messages, " ")
+	case errors.As(err, &forbidden):
+		status = http.StatusForbidden
+		message = locale.error(forbidden.Err)
+	default:
+		log.Printf("request %s: %s %s: %v\n", requestID(req), req.Method, req.URL.Path, err)
+	}
+
+	if strings.HasPrefix(req.URL.Path, "/api/") {
+		writeJSON(w, status, map[string]interface{}{"error": message, "request_id": requestID(req)})
+		return
+	}
+
+	app.renderTemplateStatus(w, req, "error", &errorView{
+		Status:    status,
+		Title:     locale.T(http.StatusText(status)),
+		Message:   message,
+		RequestId: requestID(req),
+	}, status)
+}
+
+// handleNotFound answers requests no route matches.
+func (app *app) handleNotFound(w http.ResponseWriter, req *http.Request) {
+	app.handleError(w, req, &model.NotFoundError{What: req.URL.Path})
+}
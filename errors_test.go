@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/mt2d2/forum/model"
+)
+
+func TestHandleError(t *testing.T) {
+	db, err := model.GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown, err := newMarkdownRenderer("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &app{templates: testTemplates(t, markdown), db: db, sessions: sessions.NewCookieStore([]byte("test")), presence: newPresence(), markdown: markdown, previews: newLinkPreviewer("", 0)}
+	router := mux.NewRouter()
+	router.HandleFunc("/forum/{id:[0-9]+}", app.handleForum)
+	router.HandleFunc("/forum/{id:[0-9]+}/page/{page:[0-9]+}", app.handleForum)
+	router.HandleFunc("/api/topic/{id:[0-9]+}/posts", app.handleAPIPosts)
+	router.HandleFunc("/broken", func(w http.ResponseWriter, req *http.Request) {
+		app.handleError(w, req, errors.New("database is locked"))
+	})
+	router.HandleFunc("/invalid", func(w http.ResponseWriter, req *http.Request) {
+		app.handleError(w, req, model.NewValidationError(errors.New("Title is required.")))
+	})
+	router.HandleFunc("/forbidden", func(w http.ResponseWriter, req *http.Request) {
+		app.handleError(w, req, &model.ForbiddenError{Err: errors.New("Must be an administrator!")})
+	})
+	router.NotFoundHandler = http.HandlerFunc(app.handleNotFound)
+	handler := withRequestID(router)
+
+	tests := []struct {
+		url      string
+		status   int
+		contains string
+		hidden   string
+	}{
+		{"/forum/1", http.StatusOK, "", ""},
+		{"/forum/999", http.StatusNotFound, "does not exist", "could not find"},
+		{"/forum/1/page/99", http.StatusNotFound, "does not exist", ""},
+		{"/nowhere", http.StatusNotFound, "does not exist", ""},
+		{"/invalid", http.StatusBadRequest, "Title is required.", ""},
+		{"/forbidden", http.StatusForbidden, "Must be an administrator!", ""},
+		{"/broken", http.StatusInternalServerError, "mention this request", "database is locked"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", test.url, nil))
+		if w.Code != test.status {
+			t.Errorf("%s returned %d, want %d", test.url, w.Code, test.status)
+		}
+		id := w.Header().Get("X-Request-ID")
+		if len(id) != 16 {
+			t.Errorf("%s has request id %q", test.url, id)
+		}
+
+		body := w.Body.String()
+		if !strings.Contains(body, test.contains) {
+			t.Errorf("%s does not contain %q:\n%s", test.url, test.contains, body)
+		}
+		if test.hidden != "" && strings.Contains(body, test.hidden) {
+			t.Errorf("%s leaks %q", test.url, test.hidden)
+		}
+		if test.status == http.StatusInternalServerError && !strings.Contains(body, id) {
+			t.Errorf("%s does not show request id %s", test.url, id)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/topic/999/posts", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("api returned %d, want %d", w.Code, http.StatusNotFound)
+	}
+	var body struct {
+		Error     string `json:"error"`
+		RequestId string `json:"request_id"`
+	}
+	err = json.Unmarshal(w.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body.Error == "" || body.RequestId != w.Header().Get("X-Request-ID") {
+		t.Errorf("api error body is %+v", body)
+	}
+}
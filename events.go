@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mt2d2/forum/model"
+)
+
+const (
+	eventsHeartbeat = 30 * time.Second
+	eventsRetry     = 3 * time.Second
+)
+
+func topicChannel(topicId int) string {
+	return "topic/" + strconv.Itoa(topicId)
+}
+
+func writeEvent(w io.Writer, id uint64, kind string, data string) error {
+	var event bytes.Buffer
+	if id != 0 {
+		fmt.Fprintf(&event, "id: %d\n", id)
+	}
+	fmt.Fprintf(&event, "event: %s\n", kind)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&event, "data: %s\n", line)
+	}
+	event.WriteString("\n")
+
+	_, err := w.Write(event.Bytes())
+	return err
+}
+
+// writeTopicEvent renders a hub event for one reader, as that reader would see
+// the post on the topic page.
+func (app *app) writeTopicEvent(w io.Writer, event hubEvent, page *topicView) error {
+	switch event.Kind {
+	case "post":
+		post, err := model.FindOnePost(app.db, strconv.Itoa(event.PostId))
+		if err != nil {
+			// deleted before it could be sent
+			return nil
+		}
+
+		rows, err := app.renderPostRows([]model.Post{post}, page)
+		if err != nil {
+			return err
+		}
+
+		return writeEvent(w, event.Id, event.Kind, rows[0])
+	case "delete":
+		return writeEvent(w, event.Id, event.Kind, strconv.Itoa(event.PostId))
+	}
+
+	return nil
+}
+
+// topicPage is the page data post.html needs to render posts of a topic for
+// the reader making a request.
+func (app *app) topicPage(req *http.Request, topic *model.Topic) *topicView {
+	page := &topicView{Topic: topic}
+	page.Language = app.locale(req).Tag
+
+	session, _ := app.sessions.Get(req, "forumSession")
+	if userID, ok := session.Values["user_id"].(int); ok {
+		if user, err := model.FindOneUserById(app.db, userID); err == nil {
+			page.User = &user
+		}
+	}
+
+	return page
+}
+
+// renderPostRows renders posts with the post.html partial, as the reader the
+// page data belongs to sees them on the topic page.
+func (app *app) renderPostRows(posts []model.Post, page *topicView) ([]string, error) {
+	attachments, err := model.FindAttachments(app.db, postIds(posts))
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := app.renderPosts(posts)
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := app.loadTemplates(app.locales.find(page.Language))
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]string, 0, len(posts))
+	for _, post := range posts {
+		row := postRow(threadedPost{post, 0}, page)
+		row.Attachments = attachments
+		row.HTML = rendered
+
+		var html bytes.Buffer
+		err = templates.ExecuteTemplate(&html, "post.html", row)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, html.String())
+	}
+
+	return rows, nil
+}
+
+// handleTopicEvents streams new and deleted posts of a topic as server-sent
+// events. Reconnecting clients resume from their Last-Event-ID, or are told to
+// reload when the events they missed are no longer known.
+func (app *app) handleTopicEvents(w http.ResponseWriter, req *http.Request) {
+	topic, err := model.FindOneTopic(app.db, mux.Vars(req)["id"])
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	page := app.topicPage(req, topic)
+
+	var lastId uint64
+	if value := req.Header.Get("Last-Event-ID"); value != "" {
+		lastId, _ = strconv.ParseUint(value, 10, 64)
+	}
+
+	channel := topicChannel(topic.Id)
+	client, missed, complete := app.hub.subscribe(channel, lastId)
+	defer app.hub.unsubscribe(channel, client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetry/time.Millisecond)
+
+	if !complete {
+		writeEvent(w, 0, "reload", "")
+		flusher.Flush()
+		return
+	}
+
+	for _, event := range missed {
+		if err := app.writeTopicEvent(w, event, page); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, open := <-client.events:
+			if !open {
+				// too slow to keep up, the browser reconnects and catches up
+				return
+			}
+			if err := app.writeTopicEvent(w, event, page); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mt2d2/forum/model"
+)
+
+type feedEntry struct {
+	Title     string
+	URL       string
+	Author    string
+	Published time.Time
+	Content   string
+}
+
+// feed is the format independent content of an Atom or RSS feed. Entries are
+// newest first.
+type feed struct {
+	Title   string
+	URL     string
+	SelfURL string
+	Entries []feedEntry
+}
+
+// updated returns the time of the newest entry, or the Unix epoch for an empty
+// feed so that it is still stable between requests.
+func (f *feed) updated() time.Time {
+	updated := time.Unix(0, 0).UTC()
+	for _, entry := range f.Entries {
+		if entry.Published.After(updated) {
+			updated = entry.Published
+		}
+	}
+	return updated
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title     string   `xml:"title"`
+	Id        string   `xml:"id"`
+	Link      atomLink `xml:"link"`
+	Published string   `xml:"published"`
+	Updated   string   `xml:"updated"`
+	Author    string   `xml:"author>name"`
+	Content   atomText `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Base    string      `xml:"xml:base,attr"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Links   []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Guid        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Description string `xml:"description"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+func (f *feed) atom(base string) ([]byte, error) {
+	out := atomFeed{
+		Base:    base + "/",
+		Title:   f.Title,
+		Id:      base + f.URL,
+		Links:   []atomLink{{"self", base + f.SelfURL}, {"alternate", base + f.URL}},
+		Updated: f.updated().Format(time.RFC3339),
+		Entries: make([]atomEntry, 0, len(f.Entries)),
+	}
+
+	for _, entry := range f.Entries {
+		published := entry.Published.Format(time.RFC3339)
+		out.Entries = append(out.Entries, atomEntry{entry.Title, base + entry.URL, atomLink{"alternate", base + entry.URL},
+			published, published, entry.Author, atomText{"html", entry.Content}})
+	}
+
+	return marshalFeed(out)
+}
+
+// rootRelativeURL matches the href and src attributes of html that start with a
+// single /, like the links to profiles and attachments in posts.
+var rootRelativeURL = regexp.MustCompile(`(\s(?:href|src)=")(/(?:[^/"][^"]*)?")`)
+
+// absoluteURLs resolves the root relative links of html against base. RSS has
+// no xml:base, readers would resolve them against their own host.
+func absoluteURLs(html, base string) string {
+	return rootRelativeURL.ReplaceAllString(html, "${1}"+base+"${2}")
+}
+
+func (f *feed) rss(base string) ([]byte, error) {
+	out := rssFeed{Version: "2.0", Channel: rssChannel{
+		Title:         f.Title,
+		Link:          base + f.URL,
+		Description:   f.Title,
+		LastBuildDate: f.updated().Format(time.RFC1123Z),
+		Items:         make([]rssItem, 0, len(f.Entries)),
+	}}
+
+	for _, entry := range f.Entries {
+		out.Channel.Items = append(out.Channel.Items, rssItem{entry.Title, base + entry.URL, base + entry.URL,
+			entry.Published.Format(time.RFC1123Z), absoluteURLs(entry.Content, base)})
+	}
+
+	return marshalFeed(out)
+}
+
+func marshalFeed(v interface{}) ([]byte, error) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+func postEntry(post model.Post, topicTitle string, html template.HTML) feedEntry {
+	return feedEntry{
+		"Re: " + topicTitle,
+		"/post/" + strconv.Itoa(post.Id),
+		post.User.Username,
+		post.Published,
+		string(html),
+	}
+}
+
+// serveFeed writes a feed in the format named in the route. Conditional
+// requests are answered by http.ServeContent using an ETag over the body and
+// the time of the newest entry.
+func (app *app) serveFeed(w http.ResponseWriter, req *http.Request, f *feed) {
+	var (
+		body []byte
+		err  error
+	)
+
+	format := mux.Vars(req)["format"]
+	f.SelfURL = req.URL.Path
+	if format == "rss" {
+		body, err = f.rss(app.baseURL())
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	} else {
+		body, err = f.atom(app.baseURL())
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	}
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	sum := sha1.Sum(body)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	http.ServeContent(w, req, "", f.updated(), bytes.NewReader(body))
+}
+
+func (app *app) handleLatestFeed(w http.ResponseWriter, req *http.Request) {
+	posts, err := model.FindLatestPosts(app.db, limitFeedEntries)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	html, err := app.renderPosts(posts)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	f := &feed{"Latest posts", "/", "", make([]feedEntry, 0, len(posts))}
+	topics := make(map[int]*model.Topic)
+	for _, post := range posts {
+		topic, ok := topics[post.TopicId]
+		if !ok {
+			topic, err = model.FindOneTopic(app.db, strconv.Itoa(post.TopicId))
+			if err != nil {
+				app.handleError(w, req, err)
+				return
+			}
+			topics[post.TopicId] = topic
+		}
+
+		f.Entries = append(f.Entries, postEntry(post, topic.Title, html[post.Id]))
+	}
+
+	app.serveFeed(w, req, f)
+}
+
+// handleForumFeed lists the newest topics of a forum, each with its first post.
+func (app *app) handleForumFeed(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	forum, err := model.FindOneForum(app.db, id)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	offset := forum.TopicCount - limitFeedEntries
+	if offset < 0 {
+		offset = 0
+	}
+	topics, err := model.FindTopics(app.db, id, limitFeedEntries, offset)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	f := &feed{forum.Title, "/forum/" + strconv.Itoa(forum.Id), "", make([]feedEntry, 0, len(topics))}
+	for i := len(topics) - 1; i >= 0; i-- {
+		topic := topics[i]
+		posts, err := model.FindPosts(app.db, strconv.Itoa(topic.Id), 1, 0)
+		if err != nil {
+			app.handleError(w, req, err)
+			return
+		}
+		if len(posts) == 0 {
+			continue
+		}
+
+		html, err := app.renderPosts(posts)
+		if err != nil {
+			app.handleError(w, req, err)
+			return
+		}
+
+		f.Entries = append(f.Entries, feedEntry{
+			topic.Title,
+			"/topic/" + strconv.Itoa(topic.Id),
+			posts[0].User.Username,
+			posts[0].Published,
+			string(html[posts[0].Id]),
+		})
+	}
+
+	app.serveFeed(w, req, f)
+}
+
+// handleTopicFeed lists the newest posts of a topic.
+func (app *app) handleTopicFeed(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	topic, err := model.FindOneTopic(app.db, id)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	offset := topic.PostCount - limitFeedEntries
+	if offset < 0 {
+		offset = 0
+	}
+	posts, err := model.FindPosts(app.db, id, limitFeedEntries, offset)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	html, err := app.renderPosts(posts)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	f := &feed{topic.Title, "/topic/" + strconv.Itoa(topic.Id), "", make([]feedEntry, 0, len(posts))}
+	for i := len(posts) - 1; i >= 0; i-- {
+		f.Entries = append(f.Entries, postEntry(posts[i], topic.Title, html[posts[i].Id]))
+	}
+
+	app.serveFeed(w, req, f)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"log"
 	"math"
 	"net/http"
 	"strconv"
@@ -9,21 +10,22 @@ import (
 	"github.com/mt2d2/forum/model"
 )
 
-func numberOfForumPages(forum *model.Forum) int {
-	return int(math.Ceil(float64(forum.TopicCount) / float64(limitTopics)))
+func numberOfForumPages(forum *model.Forum, topicsPerPage int) int {
+	return int(math.Ceil(float64(forum.TopicCount) / float64(topicsPerPage)))
 }
 
 func (app *app) handleIndex(w http.ResponseWriter, req *http.Request) {
 	forums, err := model.FindForums(app.db)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		app.handleError(w, req, err)
 		return
 	}
 
-	results := make(map[string]interface{})
-	results["forums"] = forums
-
-	app.renderTemplate(w, req, "index", results)
+	app.renderTemplate(w, req, "index", &indexView{
+		layout:  layout{Feed: "/feed"},
+		Forums:  forums,
+		Viewers: app.presence.forumMembers(),
+	})
 }
 
 func (app *app) handleForum(w http.ResponseWriter, req *http.Request) {
@@ -38,33 +40,52 @@ func (app *app) handleForum(w http.ResponseWriter, req *http.Request) {
 
 	forum, err := model.FindOneForum(app.db, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		app.handleError(w, req, err)
 		return
 	}
 
-	numberOfPages := numberOfForumPages(forum)
-	pageIndicies := make([]int, numberOfPages)
-	for i := 0; i < numberOfPages; i++ {
-		pageIndicies[i] = i + 1
-	}
+	_, topicsPerPage := app.pageSizes(req)
+	numberOfPages := numberOfForumPages(forum, topicsPerPage)
 	currentPage := int(pageOffset + 1)
+	if currentPage > 1 && currentPage > numberOfPages {
+		app.handleError(w, req, &model.NotFoundError{What: "page " + strconv.Itoa(currentPage) + " of forum " + id})
+		return
+	}
 
-	topics, err := model.FindTopics(app.db, id, limitTopics, pageOffset*limitTopics)
+	topics, err := model.FindTopics(app.db, id, topicsPerPage, pageOffset*topicsPerPage)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		app.handleError(w, req, err)
 		return
 	}
 
-	app.addBreadCrumb("/forum/"+strconv.Itoa(forum.Id), forum.Title)
+	app.addBreadCrumb(req, "/forum/"+strconv.Itoa(forum.Id), forum.Title)
 	if currentPage > 1 {
-		app.addBreadCrumb("forum/"+strconv.Itoa(forum.Id)+"/page/"+strconv.Itoa(currentPage), "page "+strconv.Itoa(currentPage))
+		app.addBreadCrumb(req, "/forum/"+strconv.Itoa(forum.Id)+"/page/"+strconv.Itoa(currentPage), app.T(req, "page %d", currentPage))
+	}
+
+	results := &forumView{
+		layout:  layout{Feed: "/forum/" + strconv.Itoa(forum.Id) + "/feed"},
+		Forum:   forum,
+		Topics:  topics,
+		Pager:   newPager("/forum/"+strconv.Itoa(forum.Id), currentPage, numberOfPages),
+		Viewers: app.presence.roomMembers(forumRoom(forum.Id)),
 	}
 
-	results := make(map[string]interface{})
-	results["forum"] = forum
-	results["topics"] = topics
-	results["pageIndicies"] = pageIndicies
-	results["currentPage"] = currentPage
+	session, _ := app.sessions.Get(req, "forumSession")
+	if userID, ok := session.Values["user_id"].(int); ok {
+		results.Subscribed = model.IsSubscribedToForum(app.db, userID, forum.Id)
+	}
 
 	app.renderTemplate(w, req, "forum", results)
 }
+
+// recount repairs the stored topic and post counts of every forum and topic.
+func (app *app) recount() error {
+	topics, forums, err := model.Recount(app.db)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("recounted posts, fixed %d topics and %d forums\n", topics, forums)
+	return nil
+}
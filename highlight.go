@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"unicode/utf8"
+)
+
+// highlightLanguage describes just enough of a language's lexical syntax to
+// colour keywords, strings, numbers and comments.
+type highlightLanguage struct {
+	keywords     map[string]bool
+	ignoreCase   bool
+	lineComments []string
+	blockComment [2]string
+	quotes       string
+}
+
+func keywords(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, keyword := range strings.Fields(list) {
+		set[keyword] = true
+	}
+	return set
+}
+
+var (
+	highlightGo = &highlightLanguage{
+		keywords: keywords(`break case chan const continue default defer else fallthrough for func go goto if
+			import interface map package range return select struct switch type var
+			true false nil iota append cap close copy delete len make new panic print println recover
+			bool byte complex64 complex128 error float32 float64 int int8 int16 int32 int64 rune string uint uint8 uint16 uint32 uint64 uintptr`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+	}
+	highlightJavaScript = &highlightLanguage{
+		keywords: keywords(`async await break case catch class const continue debugger default delete do else export extends
+			finally for function if import in instanceof let new of return super switch this throw try typeof var void while with yield
+			true false null undefined NaN Infinity`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+	}
+	highlightPython = &highlightLanguage{
+		keywords: keywords(`and as assert async await break class continue def del elif else except finally for from global
+			if import in is lambda nonlocal not or pass raise return try while with yield True False None self`),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	}
+	highlightShell = &highlightLanguage{
+		keywords:     keywords(`if then else elif fi case esac for while until do done in function return local export echo exit`),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	}
+	highlightSQL = &highlightLanguage{
+		keywords: keywords(`select from where and or not insert into values update set delete create table drop alter index
+			join left right inner outer on group by order having limit offset as null is in like between distinct
+			primary key foreign references default begin commit transaction union all exists case when then else end`),
+		ignoreCase:   true,
+		lineComments: []string{"--"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "'\"",
+	}
+	highlightC = &highlightLanguage{
+		keywords: keywords(`auto break case char const continue default do double else enum extern float for goto if inline int
+			long register return short signed sizeof static struct switch typedef union unsigned void volatile while
+			class public private protected new delete this true false null NULL`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'",
+	}
+	highlightJSON = &highlightLanguage{
+		keywords: keywords(`true false null`),
+		quotes:   "\"",
+	}
+)
+
+// highlightLanguages maps fenced code block languages to their syntax.
+var highlightLanguages = map[string]*highlightLanguage{
+	"go":         highlightGo,
+	"golang":     highlightGo,
+	"js":         highlightJavaScript,
+	"javascript": highlightJavaScript,
+	"py":         highlightPython,
+	"python":     highlightPython,
+	"sh":         highlightShell,
+	"bash":       highlightShell,
+	"shell":      highlightShell,
+	"sql":        highlightSQL,
+	"c":          highlightC,
+	"cpp":        highlightC,
+	"java":       highlightC,
+	"json":       highlightJSON,
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
+
+// highlight writes code as a pre block with spans classed hl-k (keyword),
+// hl-s (string), hl-n (number) and hl-c (comment).
+func highlight(out *bytes.Buffer, code []byte, lang string) {
+	language := highlightLanguages[lang]
+	src := string(code)
+
+	span := func(class, text string) {
+		out.WriteString(`<span class="hl-` + class + `">`)
+		out.WriteString(template.HTMLEscapeString(text))
+		out.WriteString("</span>")
+	}
+
+	out.WriteString(`<pre><code class="language-` + lang + `">`)
+	for i := 0; i < len(src); {
+		rest := src[i:]
+
+		if start := language.blockComment[0]; start != "" && strings.HasPrefix(rest, start) {
+			end := strings.Index(rest[len(start):], language.blockComment[1])
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += len(start) + len(language.blockComment[1])
+			}
+			span("c", rest[:end])
+			i += end
+			continue
+		}
+
+		comment := false
+		for _, prefix := range language.lineComments {
+			if strings.HasPrefix(rest, prefix) {
+				comment = true
+			}
+		}
+		if comment {
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			span("c", rest[:end])
+			i += end
+			continue
+		}
+
+		c := src[i]
+		switch {
+		case strings.IndexByte(language.quotes, c) >= 0:
+			end := 1
+			for end < len(rest) && rest[end] != c {
+				// only backquoted strings span lines
+				if rest[end] == '\n' && c != '`' {
+					break
+				}
+				if rest[end] == '\\' && c != '`' {
+					end++
+				}
+				end++
+			}
+			if end < len(rest) && rest[end] == c {
+				end++
+			}
+			if end > len(rest) {
+				end = len(rest)
+			}
+			span("s", rest[:end])
+			i += end
+		case c >= '0' && c <= '9':
+			end := 1
+			for end < len(rest) && (isIdentPart(rest[end]) || rest[end] == '.') {
+				end++
+			}
+			span("n", rest[:end])
+			i += end
+		case isIdentStart(c):
+			end := 1
+			for end < len(rest) && isIdentPart(rest[end]) {
+				end++
+			}
+			word := rest[:end]
+			if language.keywords[word] || language.ignoreCase && language.keywords[strings.ToLower(word)] {
+				span("k", word)
+			} else {
+				out.WriteString(word)
+			}
+			i += end
+		default:
+			_, size := utf8.DecodeRuneInString(rest)
+			out.WriteString(template.HTMLEscapeString(rest[:size]))
+			i += size
+		}
+	}
+	out.WriteString("</code></pre>\n")
+}
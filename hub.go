@@ -0,0 +1,124 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// hubHistorySize is the number of events kept per channel for clients
+	// reconnecting with a Last-Event-ID.
+	hubHistorySize = 64
+	// hubClientBuffer is the number of events queued for a client before it
+	// is considered too slow and disconnected.
+	hubClientBuffer = 16
+)
+
+type hubEvent struct {
+	Id     uint64
+	Kind   string
+	PostId int
+}
+
+type hubClient struct {
+	events chan hubEvent
+}
+
+type hubChannel struct {
+	history []hubEvent
+	// dropped is the id of the newest event trimmed from the history
+	dropped uint64
+	clients map[*hubClient]bool
+}
+
+// hub is an in-process publish/subscribe broker for live updates, with one
+// channel per topic.
+type hub struct {
+	mu       sync.Mutex
+	startId  uint64
+	lastId   uint64
+	channels map[string]*hubChannel
+}
+
+// newHub starts event ids at the current time so that ids handed out before
+// a restart can be told apart.
+func newHub() *hub {
+	start := uint64(time.Now().UnixNano())
+	return &hub{startId: start, lastId: start, channels: make(map[string]*hubChannel)}
+}
+
+func (h *hub) channel(name string) *hubChannel {
+	c, ok := h.channels[name]
+	if !ok {
+		c = &hubChannel{make([]hubEvent, 0, hubHistorySize), 0, make(map[*hubClient]bool)}
+		h.channels[name] = c
+	}
+	return c
+}
+
+// publish sends an event to every client of a channel. Clients whose buffer
+// is full are dropped; they can reconnect and catch up from the history.
+func (h *hub) publish(name string, kind string, postId int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastId++
+	event := hubEvent{h.lastId, kind, postId}
+
+	c := h.channel(name)
+	if len(c.history) == hubHistorySize {
+		c.dropped = c.history[0].Id
+		c.history = append(c.history[:0], c.history[1:]...)
+	}
+	c.history = append(c.history, event)
+
+	for client := range c.clients {
+		select {
+		case client.events <- event:
+		default:
+			delete(c.clients, client)
+			close(client.events)
+		}
+	}
+}
+
+// subscribe registers a client on a channel and returns the events it missed
+// since lastId. ok is false when the missed events are no longer all known,
+// in which case the client should reload.
+func (h *hub) subscribe(name string, lastId uint64) (client *hubClient, missed []hubEvent, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c := h.channel(name)
+	client = &hubClient{make(chan hubEvent, hubClientBuffer)}
+	c.clients[client] = true
+
+	if lastId == 0 {
+		return client, nil, true
+	}
+	if lastId < h.startId || lastId > h.lastId || lastId < c.dropped {
+		return client, nil, false
+	}
+
+	missed = make([]hubEvent, 0)
+	for _, event := range c.history {
+		if event.Id > lastId {
+			missed = append(missed, event)
+		}
+	}
+
+	return client, missed, true
+}
+
+func (h *hub) unsubscribe(name string, client *hubClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c, ok := h.channels[name]
+	if !ok || !c.clients[client] {
+		return
+	}
+
+	delete(c.clients, client)
+	close(client.events)
+}
@@ -0,0 +1,79 @@
+package main
+
+import "testing"
+
+func TestHubPublish(t *testing.T) {
+	h := newHub()
+
+	client, missed, ok := h.subscribe("topic/1", 0)
+	if !ok || len(missed) != 0 {
+		t.Fatal("new client should not miss anything")
+	}
+
+	h.publish("topic/1", "post", 5)
+	h.publish("topic/2", "post", 6)
+
+	event := <-client.events
+	if event.Kind != "post" || event.PostId != 5 {
+		t.Error("wrong event")
+	}
+
+	select {
+	case event := <-client.events:
+		t.Errorf("should not receive events of other channels: %v", event)
+	default:
+	}
+
+	h.unsubscribe("topic/1", client)
+	if _, open := <-client.events; open {
+		t.Error("unsubscribing should close the client")
+	}
+}
+
+func TestHubReconnect(t *testing.T) {
+	h := newHub()
+
+	h.publish("topic/1", "post", 1)
+	h.publish("topic/1", "post", 2)
+	h.publish("topic/1", "delete", 1)
+
+	_, missed, ok := h.subscribe("topic/1", h.startId+1)
+	if !ok || len(missed) != 2 || missed[0].PostId != 2 || missed[1].Kind != "delete" {
+		t.Error("should replay events after the last event id")
+	}
+
+	_, _, ok = h.subscribe("topic/1", h.startId-1)
+	if ok {
+		t.Error("event ids from before a restart should reload")
+	}
+
+	for i := 0; i < hubHistorySize; i++ {
+		h.publish("topic/1", "post", i)
+	}
+
+	_, _, ok = h.subscribe("topic/1", h.startId+1)
+	if ok {
+		t.Error("events trimmed from the history should reload")
+	}
+}
+
+func TestHubSlowClient(t *testing.T) {
+	h := newHub()
+
+	client, _, _ := h.subscribe("topic/1", 0)
+	for i := 0; i <= hubClientBuffer; i++ {
+		h.publish("topic/1", "post", i)
+	}
+
+	received := 0
+	for range client.events {
+		received++
+	}
+
+	if received != hubClientBuffer {
+		t.Error("slow client should be dropped once its buffer is full")
+	}
+
+	// unsubscribing a dropped client is harmless
+	h.unsubscribe("topic/1", client)
+}
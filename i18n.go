@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mt2d2/forum/model"
+)
+
+// A locale is a language the forum is shown in. The forum is written in
+// English, whose messages are the keys of the other locales' catalogs; a
+// message a catalog lacks is shown in English.
+type locale struct {
+	Tag        string            `json:"-"`
+	Name       string            `json:"name"`
+	DateFormat string            `json:"dateFormat"`
+	Messages   map[string]string `json:"messages"`
+}
+
+var english = &locale{Tag: "en", Name: "English", DateFormat: "1/2/06 03:04 pm"}
+
+// localeNames lists the catalogs in the locales box, named by their tags.
+var localeNames = []string{"de.json"}
+
+// T translates a message, filling args into it as fmt.Sprintf does.
+func (locale *locale) T(format string, args ...interface{}) string {
+	if translated, ok := locale.Messages[format]; ok && translated != "" {
+		format = translated
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// N translates the singular message for a count of one and the plural one
+// otherwise, filling in the count. Every language the forum is translated to
+// tells one from many alone.
+func (locale *locale) N(singular, plural string, n int) string {
+	if n == 1 {
+		return locale.T(singular, n)
+	}
+	return locale.T(plural, n)
+}
+
+// error translates the message of an error meant for the user.
+func (locale *locale) error(err error) string {
+	var formatted *model.FormattedError
+	if errors.As(err, &formatted) {
+		return locale.T(formatted.Format, formatted.Args...)
+	}
+	return locale.T(err.Error())
+}
+
+func (locale *locale) date(t time.Time) string {
+	return t.Format(locale.DateFormat)
+}
+
+func (locale *locale) funcs() template.FuncMap {
+	return template.FuncMap{"T": locale.T, "N": locale.N, "date": locale.date}
+}
+
+// locales are the languages the forum is translated to, English first. The
+// templates are copied once per locale, so that T, N and date of every copy
+// translate to its language.
+type locales struct {
+	all   []*locale
+	byTag map[string]*locale
+
+	mu     sync.Mutex
+	copies map[string]localizedTemplates
+}
+
+type localizedTemplates struct {
+	from, templates *template.Template
+}
+
+// loadLocales reads the catalogs with read, the locales box in production.
+func loadLocales(read func(name string) (string, error)) (*locales, error) {
+	locales := &locales{
+		all:    []*locale{english},
+		byTag:  map[string]*locale{english.Tag: english},
+		copies: make(map[string]localizedTemplates),
+	}
+
+	for _, name := range localeNames {
+		source, err := read(name)
+		if err != nil {
+			return nil, err
+		}
+
+		locale := &locale{Tag: strings.TrimSuffix(name, ".json")}
+		err = json.Unmarshal([]byte(source), locale)
+		if err != nil {
+			return nil, errors.New("could not read locale " + name + ": " + err.Error())
+		}
+		if locale.DateFormat == "" {
+			locale.DateFormat = english.DateFormat
+		}
+
+		locales.all = append(locales.all, locale)
+		locales.byTag[locale.Tag] = locale
+	}
+
+	return locales, nil
+}
+
+// list returns the locales a reader can choose from.
+func (locales *locales) list() []*locale {
+	if locales == nil {
+		return []*locale{english}
+	}
+	return locales.all
+}
+
+// exists tells if the forum is translated to a language, the empty tag
+// standing for the one the browser asks for.
+func (locales *locales) exists(tag string) bool {
+	if tag == "" {
+		return true
+	}
+	if locales == nil {
+		return tag == english.Tag
+	}
+	_, ok := locales.byTag[tag]
+	return ok
+}
+
+// find returns the locale of a tag, English if the forum is not translated to
+// the language.
+func (locales *locales) find(tag string) *locale {
+	return locales.negotiate(tag, "")
+}
+
+// negotiate picks the locale of a reader: the language they chose in their
+// preferences, otherwise the first of their browser's languages the forum is
+// translated to, otherwise English.
+func (locales *locales) negotiate(preferred, acceptLanguage string) *locale {
+	if locales == nil {
+		return english
+	}
+
+	if locale, ok := locales.byTag[preferred]; ok {
+		return locale
+	}
+
+	for _, tag := range acceptedLanguages(acceptLanguage) {
+		if locale, ok := locales.byTag[tag]; ok {
+			return locale
+		}
+		// de-AT is answered in German
+		if i := strings.Index(tag, "-"); i > 0 {
+			if locale, ok := locales.byTag[tag[:i]]; ok {
+				return locale
+			}
+		}
+	}
+
+	return english
+}
+
+// acceptedLanguages returns the lower-cased tags of an Accept-Language header,
+// the most wanted first, leaving out those the browser refuses with q=0.
+func acceptedLanguages(header string) []string {
+	type accepted struct {
+		tag     string
+		quality float64
+	}
+
+	languages := make([]accepted, 0)
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(params[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			languages = append(languages, accepted{tag, quality})
+		}
+	}
+
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].quality > languages[j].quality
+	})
+
+	tags := make([]string, len(languages))
+	for i, language := range languages {
+		tags[i] = language.tag
+	}
+	return tags
+}
+
+// localize returns the copy of templates for a locale, copying them again
+// when they were replaced since, as by a reload or a change of theme.
+// templates themselves are never executed, html/template can not copy
+// templates that were.
+func (locales *locales) localize(templates *template.Template, locale *locale) (*template.Template, error) {
+	if locales == nil {
+		return templates, nil
+	}
+
+	locales.mu.Lock()
+	defer locales.mu.Unlock()
+
+	if cached, ok := locales.copies[locale.Tag]; ok && cached.from == templates {
+		return cached.templates, nil
+	}
+
+	localized, err := templates.Clone()
+	if err != nil {
+		return nil, err
+	}
+	localized.Funcs(locale.funcs())
+
+	locales.copies[locale.Tag] = localizedTemplates{templates, localized}
+	return localized, nil
+}
+
+// locale returns the locale of the reader making a request, looked up once
+// per request.
+func (app *app) locale(req *http.Request) *locale {
+	p := pageOf(req)
+	if p.locale != nil {
+		return p.locale
+	}
+
+	var preferred string
+	session, _ := app.sessions.Get(req, "forumSession")
+	if userID, ok := session.Values["user_id"].(int); ok {
+		if prefs, err := model.FindPreferences(app.db, userID); err == nil {
+			preferred = prefs.Language
+		}
+	}
+
+	p.locale = app.locales.negotiate(preferred, req.Header.Get("Accept-Language"))
+	return p.locale
+}
+
+// T translates a message to the language of the reader making a request.
+func (app *app) T(req *http.Request, format string, args ...interface{}) string {
+	return app.locale(req).T(format, args...)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mt2d2/forum/model"
+)
+
+// testLocales loads the catalogs in the locales directory.
+func testLocales(t *testing.T) *locales {
+	locales, err := loadLocales(func(name string) (string, error) {
+		source, err := ioutil.ReadFile(filepath.Join("locales", name))
+		return string(source), err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return locales
+}
+
+func TestAcceptedLanguages(t *testing.T) {
+	tests := map[string][]string{
+		"":                            {},
+		"de":                          {"de"},
+		"en-US,en;q=0.9,de;q=0.8":     {"en-us", "en", "de"},
+		"fr;q=0.5, DE-at , *;q=0.1":   {"de-at", "fr"},
+		"de;q=0, en;q=bogus":          {"en"},
+		"es;q=0.3,it;q=0.3,pt;q=0.31": {"pt", "es", "it"},
+	}
+	for header, want := range tests {
+		if got := acceptedLanguages(header); !reflect.DeepEqual(got, want) {
+			t.Errorf("acceptedLanguages(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	installed := testLocales(t)
+
+	tests := []struct {
+		preferred, acceptLanguage, want string
+	}{
+		{"", "", "en"},
+		{"", "de-DE,de;q=0.9", "de"},
+		{"", "de-AT", "de"},
+		{"", "fr,de;q=0.5", "de"},
+		{"", "fr,en;q=0.8,de;q=0.5", "en"},
+		{"en", "de", "en"},
+		{"de", "en", "de"},
+		{"xx", "de", "de"},
+	}
+	for _, test := range tests {
+		if got := installed.negotiate(test.preferred, test.acceptLanguage); got.Tag != test.want {
+			t.Errorf("negotiate(%q, %q) = %s, want %s", test.preferred, test.acceptLanguage, got.Tag, test.want)
+		}
+	}
+
+	var none *locales
+	if got := none.negotiate("de", "de"); got != english {
+		t.Errorf("without catalogs the forum should be English, got %s", got.Tag)
+	}
+}
+
+var (
+	templateMessage = regexp.MustCompile(`\{\{T "([^"]*)"`)
+	templatePlural  = regexp.MustCompile(`\{\{N "([^"]*)" "([^"]*)"`)
+	// errors meant for users are sentences, lower case ones are for the log
+	goError    = regexp.MustCompile(`(?:errors\.New|Errorf)\("([A-Z][^"]*)"[,)]`)
+	goMessage  = regexp.MustCompile(`(?:app\.T\(req|addSuccessFlash\(w, req), "([^"]*)"[,)]`)
+	formatVerb = regexp.MustCompile(`%[a-z]`)
+)
+
+// catalogMessages returns the messages of the files matched by patterns,
+// keyed by message with the file they were found in.
+func catalogMessages(t *testing.T, patterns []string, messageExps ...*regexp.Regexp) map[string]string {
+	messages := make(map[string]string)
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range paths {
+			if strings.HasSuffix(path, "_test.go") {
+				continue
+			}
+			source, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, exp := range messageExps {
+				for _, match := range exp.FindAllStringSubmatch(string(source), -1) {
+					for _, message := range match[1:] {
+						messages[message] = path
+					}
+				}
+			}
+		}
+	}
+	if len(messages) == 0 {
+		t.Fatalf("found no messages in %v", patterns)
+	}
+	return messages
+}
+
+// TestCatalogs checks that every locale translates every message of the
+// templates and the messages for users in the code, and fills in the same
+// values as the English message.
+func TestCatalogs(t *testing.T) {
+	locales := testLocales(t)
+
+	templates := make([]string, 0, len(templateNames)+1)
+	for _, name := range templateNames {
+		templates = append(templates, filepath.Join("templates", name))
+	}
+	templates = append(templates, filepath.Join("themes", "*", "templates", "*.html"))
+
+	messages := catalogMessages(t, templates, templateMessage, templatePlural)
+	for message, path := range catalogMessages(t, []string{"*.go", filepath.Join("model", "*.go")}, goError, goMessage) {
+		messages[message] = path
+	}
+
+	for _, locale := range locales.list()[1:] {
+		for message, path := range messages {
+			if _, ok := locale.Messages[message]; !ok {
+				t.Errorf("%s lacks %q of %s", locale.Tag, message, path)
+			}
+		}
+
+		for message, translated := range locale.Messages {
+			if !reflect.DeepEqual(formatVerb.FindAllString(message, -1), formatVerb.FindAllString(translated, -1)) {
+				t.Errorf("%s translates %q to %q, which fills in other values", locale.Tag, message, translated)
+			}
+		}
+	}
+}
+
+func TestLocaleMessages(t *testing.T) {
+	german := testLocales(t).find("de")
+
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errors.New("Topic must have a title."), "Ein Thema braucht einen Titel."},
+		{model.Errorf("Posts per page must be between %d and %d.", 5, 100), "Beiträge pro Seite müssen zwischen 5 und 100 liegen."},
+		{errors.New("Not in the catalog."), "Not in the catalog."},
+		{model.Errorf("Not in the catalog, %d%%.", 100), "Not in the catalog, 100%."},
+	}
+	for _, test := range tests {
+		if got := german.error(test.err); got != test.want {
+			t.Errorf("%v was translated to %q, want %q", test.err, got, test.want)
+		}
+	}
+
+	published := time.Date(2016, 1, 2, 15, 4, 0, 0, time.UTC)
+	if date := german.date(published); date != "02.01.16 15:04" {
+		t.Errorf("German date is %q", date)
+	}
+	if date := english.date(published); date != "1/2/16 03:04 pm" {
+		t.Errorf("English date is %q", date)
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	locales := testLocales(t)
+	markdown, err := newMarkdownRenderer("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates := testTemplates(t, markdown)
+
+	render := func(templates *template.Template, locale *locale) string {
+		localized, err := locales.localize(templates, locale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var page bytes.Buffer
+		err = localized.ExecuteTemplate(&page, "index.html", sampleViews(nil)["index.html"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return page.String()
+	}
+
+	german := render(templates, locales.find("de"))
+	for _, want := range []string{"1 Thema, 2 Beiträge", "letzter Beitrag 02.01.16 15:04", "Registrieren"} {
+		if !strings.Contains(german, want) {
+			t.Errorf("German index does not contain %q:\n%s", want, german)
+		}
+	}
+	if page := render(templates, english); !strings.Contains(page, "last post 1/2/16 03:04 pm") {
+		t.Errorf("English index is not English:\n%s", page)
+	}
+
+	first, _ := locales.localize(templates, english)
+	if again, _ := locales.localize(templates, english); again != first {
+		t.Error("the templates were copied again for the same locale")
+	}
+	if reloaded, _ := locales.localize(testTemplates(t, markdown), english); reloaded == first {
+		t.Error("replaced templates should be copied again")
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mt2d2/forum/model"
+	"golang.org/x/net/html"
+)
+
+const (
+	// linkPreviewTimeout bounds fetching a page and its oEmbed data.
+	linkPreviewTimeout = 5 * time.Second
+	// linkPreviewMaxBody is how much of a page is read looking for metadata.
+	linkPreviewMaxBody = 1 << 20
+	// linkPreviewMaxAge is how long previews, and failures, are kept before
+	// a link is fetched again.
+	linkPreviewMaxAge = 7 * 24 * time.Hour
+	// linkPreviewQueue is the number of links waiting to be fetched, more
+	// are dropped and queued again the next time their post is rendered.
+	linkPreviewQueue = 100
+	// linkPreviewMaxDescription is the length descriptions are cut to.
+	linkPreviewMaxDescription = 300
+)
+
+var (
+	paragraphBreak = regexp.MustCompile(`\n[ \t]*\n`)
+	standaloneLink = regexp.MustCompile(`^ {0,3}(https?://\S+)$`)
+)
+
+// standaloneLinks returns the urls that make up a whole paragraph of markdown,
+// the links that are expanded into preview cards.
+func standaloneLinks(markdown string) []string {
+	links := make([]string, 0)
+	for _, paragraph := range paragraphBreak.Split(strings.Replace(markdown, "\r\n", "\n", -1), -1) {
+		paragraph = strings.TrimRight(strings.Trim(paragraph, "\n"), " \t")
+		if match := standaloneLink.FindStringSubmatch(paragraph); match != nil {
+			links = append(links, match[1])
+		}
+	}
+	return links
+}
+
+// linkPreviewer fetches previews of links to allowed hosts in the background,
+// see runLinkPreviews.
+type linkPreviewer struct {
+	client *http.Client
+	hosts  []string
+	jobs   chan string
+
+	mu sync.Mutex
+	// pending holds the queued urls
+	pending map[string]bool
+}
+
+func newLinkPreviewer(hosts string, timeout time.Duration) *linkPreviewer {
+	previewer := &linkPreviewer{
+		hosts:   make([]string, 0),
+		jobs:    make(chan string, linkPreviewQueue),
+		pending: make(map[string]bool),
+	}
+	for _, host := range strings.Split(hosts, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			previewer.hosts = append(previewer.hosts, host)
+		}
+	}
+
+	previewer.client = &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if !previewer.allowed(req.URL.String()) {
+				return errors.New("redirect to " + req.URL.Host + " is not allowed")
+			}
+			return nil
+		},
+	}
+
+	return previewer
+}
+
+// allowed reports whether a link points at an allowed host or one of its
+// subdomains.
+func (p *linkPreviewer) allowed(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range p.hosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// queue asks for a link to be fetched. A link already queued is only fetched
+// once.
+func (p *linkPreviewer) queue(link string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending[link] {
+		return
+	}
+
+	select {
+	case p.jobs <- link:
+		p.pending[link] = true
+	default:
+	}
+}
+
+// done lets a link be queued again.
+func (p *linkPreviewer) done(link string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.pending, link)
+}
+
+func (p *linkPreviewer) get(link string, accept string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "forum link preview")
+	req.Header.Set("Accept", accept)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New(link + " returned " + resp.Status)
+	}
+	return resp, nil
+}
+
+// pageMeta is the metadata found in the head of a page.
+type pageMeta struct {
+	title  string
+	meta   map[string]string
+	oEmbed string
+}
+
+func parsePageMeta(r io.Reader) pageMeta {
+	page := pageMeta{meta: make(map[string]string)}
+	tokenizer := html.NewTokenizer(r)
+	inTitle := false
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return page
+		case html.TextToken:
+			if inTitle && page.title == "" {
+				page.title = strings.TrimSpace(string(tokenizer.Text()))
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if string(name) == "head" {
+				return page
+			}
+			inTitle = false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			attrs := make(map[string]string)
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = tokenizer.TagAttr()
+				attrs[string(key)] = string(val)
+			}
+
+			switch string(name) {
+			case "title":
+				inTitle = true
+			case "meta":
+				key := attrs["property"]
+				if key == "" {
+					key = attrs["name"]
+				}
+				if key != "" {
+					page.meta[strings.ToLower(key)] = strings.TrimSpace(attrs["content"])
+				}
+			case "link":
+				if strings.ToLower(attrs["rel"]) == "alternate" && attrs["type"] == "application/json+oembed" {
+					page.oEmbed = attrs["href"]
+				}
+			case "body":
+				return page
+			}
+		}
+	}
+}
+
+type oEmbed struct {
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	ProviderName string `json:"provider_name"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+func (p *linkPreviewer) fetchOEmbed(link string) (oEmbed, error) {
+	var data oEmbed
+	if !p.allowed(link) {
+		return data, errors.New("oembed at " + link + " is not allowed")
+	}
+
+	resp, err := p.get(link, "application/json")
+	if err != nil {
+		return data, err
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, linkPreviewMaxBody)).Decode(&data)
+	return data, err
+}
+
+// firstOf returns the first value that is not blank.
+func firstOf(values ...string) string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// truncate cuts text to at most max runes.
+func truncate(text string, max int) string {
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	return string([]rune(text)[:max-1]) + "…"
+}
+
+// fetch builds a preview of a link from its oEmbed data, OpenGraph tags or
+// plain html head, in that order. Previews without a title count as failed.
+func (p *linkPreviewer) fetch(link string) *model.LinkPreview {
+	preview := model.NewLinkPreview(link)
+	if !p.allowed(link) {
+		return preview
+	}
+
+	resp, err := p.get(link, "text/html")
+	if err != nil {
+		log.Println("link preview:", err)
+		return preview
+	}
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return preview
+	}
+
+	page := parsePageMeta(io.LimitReader(resp.Body, linkPreviewMaxBody))
+	base := resp.Request.URL
+
+	var embed oEmbed
+	if page.oEmbed != "" {
+		if ref, err := base.Parse(page.oEmbed); err == nil {
+			embed, err = p.fetchOEmbed(ref.String())
+			if err != nil {
+				log.Println("link preview:", err)
+			}
+		}
+	}
+
+	preview.Title = truncate(firstOf(embed.Title, page.meta["og:title"], page.meta["twitter:title"], page.title), linkPreviewMaxDescription)
+	preview.Description = truncate(firstOf(page.meta["og:description"], page.meta["description"], embed.AuthorName), linkPreviewMaxDescription)
+	if image := firstOf(embed.ThumbnailURL, page.meta["og:image"], page.meta["twitter:image"]); image != "" {
+		if ref, err := base.Parse(image); err == nil && (ref.Scheme == "http" || ref.Scheme == "https") {
+			preview.Image = ref.String()
+		}
+	}
+
+	if preview.Title != "" {
+		preview.Status = model.LinkPreviewOK
+	}
+	return preview
+}
+
+// runLinkPreviews fetches queued links one at a time. The html of posts is
+// cached under the time their previews were fetched, see renderVersion, so
+// posts show a saved preview the next time they are rendered.
+func (app *app) runLinkPreviews() {
+	for link := range app.previews.jobs {
+		err := model.SaveLinkPreview(app.db, app.previews.fetch(link))
+		if err != nil {
+			log.Println("link preview:", err)
+		}
+		app.previews.done(link)
+	}
+}
+
+// postLinkPreviews finds the cached previews of the standalone links in posts,
+// keyed by post id and then url. Links without a fresh preview are queued.
+func (app *app) postLinkPreviews(posts []model.Post) (map[int]map[string]model.LinkPreview, error) {
+	links := make(map[int][]string)
+	urls := make([]string, 0)
+	for _, post := range posts {
+		for _, link := range standaloneLinks(post.Text) {
+			if app.previews.allowed(link) {
+				links[post.Id] = append(links[post.Id], link)
+				urls = append(urls, link)
+			}
+		}
+	}
+
+	cached, err := model.FindLinkPreviews(app.db, urls)
+	if err != nil {
+		return nil, err
+	}
+
+	previews := make(map[int]map[string]model.LinkPreview)
+	for postId, postLinks := range links {
+		previews[postId] = make(map[string]model.LinkPreview)
+		for _, link := range postLinks {
+			preview, ok := cached[link]
+			if !ok || time.Since(preview.Fetched) > linkPreviewMaxAge {
+				app.previews.queue(link)
+			}
+			if ok && preview.Status == model.LinkPreviewOK {
+				previews[postId][link] = preview
+			}
+		}
+	}
+
+	return previews, nil
+}
+
+// writeLinkPreview writes the card that replaces a standalone link.
+func writeLinkPreview(out *bytes.Buffer, preview model.LinkPreview) {
+	link := template.HTMLEscapeString(preview.URL)
+
+	out.WriteString(`<div class="linkPreview">`)
+	if preview.Image != "" {
+		out.WriteString(`<a href="` + link + `" class="linkPreviewImage"><img src="` + template.HTMLEscapeString(preview.Image) + `" alt="" /></a>`)
+	}
+	out.WriteString(`<a href="` + link + `" class="linkPreviewTitle">` + template.HTMLEscapeString(preview.Title) + `</a>`)
+	if preview.Description != "" {
+		out.WriteString(`<p class="linkPreviewDescription">` + template.HTMLEscapeString(preview.Description) + `</p>`)
+	}
+	if u, err := url.Parse(preview.URL); err == nil {
+		out.WriteString(`<p class="linkPreviewHost">` + template.HTMLEscapeString(u.Hostname()) + `</p>`)
+	}
+	out.WriteString("</div>\n")
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mt2d2/forum/model"
+)
+
+// linkPreviewFixtures serves pages described by OpenGraph tags, oEmbed and a
+// plain html head, plus pages that should not produce a preview.
+func linkPreviewFixtures() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/og", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `<!DOCTYPE html><html><head><title>Ignored</title>
+			<meta property="og:title" content="Open &amp; Graph">
+			<meta property="og:description" content="A page with OpenGraph tags">
+			<meta property="og:image" content="/cat.png">
+			</head><body><meta property="og:title" content="in body"></body></html>`)
+	})
+	mux.HandleFunc("/video", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Plain title</title>
+			<link rel="alternate" type="application/json+oembed" href="/oembed?url=video">
+			<meta name="description" content="A video">
+			</head></html>`)
+	})
+	mux.HandleFunc("/oembed", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"type": "video", "title": "A video title", "author_name": "someone", "thumbnail_url": "http://img.example/thumb.jpg"}`)
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `<html><head><title> Just a title </title><meta name="description" content="Described"></head></html>`)
+	})
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG"))
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprint(w, `<html><head><title>Too slow</title></head></html>`)
+	})
+	mux.HandleFunc("/away", func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, "http://evil.example/", http.StatusFound)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestStandaloneLinks(t *testing.T) {
+	markdown := "http://a.example/one\n\nsome text http://b.example/two\n\n  https://c.example/three  \r\n\r\n    http://d.example/code\n\nhttp://e.example/ and more"
+	links := standaloneLinks(markdown)
+	if !reflect.DeepEqual(links, []string{"http://a.example/one", "https://c.example/three"}) {
+		t.Errorf("wrong standalone links %v", links)
+	}
+}
+
+func TestLinkPreviewAllowed(t *testing.T) {
+	previewer := newLinkPreviewer("example.com, Example.org", time.Second)
+
+	allowed := []string{"http://example.com/a", "https://www.example.com/", "http://EXAMPLE.org:8080/"}
+	for _, link := range allowed {
+		if !previewer.allowed(link) {
+			t.Errorf("%s should be allowed", link)
+		}
+	}
+
+	denied := []string{"http://notexample.com/", "http://example.com.evil.example/", "ftp://example.com/", "javascript:alert(1)"}
+	for _, link := range denied {
+		if previewer.allowed(link) {
+			t.Errorf("%s should not be allowed", link)
+		}
+	}
+
+	if newLinkPreviewer("", time.Second).allowed("http://example.com/") {
+		t.Error("previews should be off without hosts")
+	}
+}
+
+func TestFetchLinkPreview(t *testing.T) {
+	server := linkPreviewFixtures()
+	defer server.Close()
+	previewer := newLinkPreviewer("127.0.0.1", 100*time.Millisecond)
+
+	preview := previewer.fetch(server.URL + "/og")
+	if preview.Status != model.LinkPreviewOK || preview.Title != "Open & Graph" ||
+		preview.Description != "A page with OpenGraph tags" || preview.Image != server.URL+"/cat.png" {
+		t.Errorf("wrong OpenGraph preview %+v", preview)
+	}
+
+	preview = previewer.fetch(server.URL + "/video")
+	if preview.Status != model.LinkPreviewOK || preview.Title != "A video title" ||
+		preview.Description != "A video" || preview.Image != "http://img.example/thumb.jpg" {
+		t.Errorf("wrong oEmbed preview %+v", preview)
+	}
+
+	preview = previewer.fetch(server.URL + "/plain")
+	if preview.Status != model.LinkPreviewOK || preview.Title != "Just a title" || preview.Description != "Described" {
+		t.Errorf("wrong plain preview %+v", preview)
+	}
+
+	for _, path := range []string{"/image.png", "/missing", "/slow", "/away"} {
+		if preview := previewer.fetch(server.URL + path); preview.Status != model.LinkPreviewFailed {
+			t.Errorf("%s should fail, got %+v", path, preview)
+		}
+	}
+
+	if preview := newLinkPreviewer("example.com", time.Second).fetch(server.URL + "/og"); preview.Status != model.LinkPreviewFailed {
+		t.Error("hosts that are not allowed should not be fetched")
+	}
+}
+
+func TestLinkPreviewCards(t *testing.T) {
+	renderer, err := newMarkdownRenderer(*markdownOptions, "2=text")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previews := map[string]model.LinkPreview{
+		"http://example.com/a?b=1&c=2": {
+			URL:         "http://example.com/a?b=1&c=2",
+			Title:       "A <title>",
+			Description: "Described",
+			Image:       "http://example.com/a.png",
+			Status:      model.LinkPreviewOK,
+		},
+	}
+	markdown := "Look:\n\nhttp://example.com/a?b=1&c=2\n\nor http://example.com/a?b=1&c=2 inline"
+
+	html := string(renderer.renderWithPreviews(1, markdown, previews))
+	if strings.Count(html, `class="linkPreview"`) != 1 || !strings.Contains(html, "A &lt;title&gt;") ||
+		!strings.Contains(html, `<img src="http://example.com/a.png"`) || !strings.Contains(html, "example.com</p>") {
+		t.Errorf("standalone link should become a card, got %s", html)
+	}
+	if !strings.Contains(html, "or <a href=") {
+		t.Errorf("inline links should stay links, got %s", html)
+	}
+
+	if html := string(renderer.renderWithPreviews(2, markdown, previews)); strings.Contains(html, "<img") || !strings.Contains(html, "linkPreviewTitle") {
+		t.Errorf("cards should follow the forum's policy, got %s", html)
+	}
+
+	if html := string(renderer.render(1, markdown)); strings.Contains(html, "linkPreview") {
+		t.Errorf("links without a preview should stay links, got %s", html)
+	}
+}
+
+func TestRunLinkPreviews(t *testing.T) {
+	server := linkPreviewFixtures()
+	defer server.Close()
+
+	db, err := model.GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown, err := newMarkdownRenderer(*markdownOptions, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &app{db: db, hub: newHub(), markdown: markdown, previews: newLinkPreviewer("127.0.0.1", time.Second)}
+
+	post := model.NewPost()
+	post.TopicId = 1
+	post.UserId = 1
+	post.Text = server.URL + "/og"
+	err = model.SavePost(db, post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts := []model.Post{*post}
+
+	html, err := app.renderPosts(posts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(html[post.Id]), "linkPreview") {
+		t.Fatal("the preview has not been fetched yet")
+	}
+
+	go app.runLinkPreviews()
+	defer close(app.previews.jobs)
+
+	for i := 0; i < 50; i++ {
+		html, err = app.renderPosts(posts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(html[post.Id]), "Open &amp; Graph") {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("post should show the card once fetched, got %s", html[post.Id])
+}
+
+func TestRefreshedLinkPreview(t *testing.T) {
+	db, err := model.GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown, err := newMarkdownRenderer(*markdownOptions, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &app{db: db, markdown: markdown, previews: newLinkPreviewer("example.com", time.Second)}
+
+	post := model.NewPost()
+	post.TopicId = 1
+	post.UserId = 1
+	post.Text = "https://example.com/page"
+	err = model.SavePost(db, post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts := []model.Post{*post}
+
+	preview := model.NewLinkPreview(post.Text)
+	preview.Title = "First title"
+	preview.Status = model.LinkPreviewOK
+	err = model.SaveLinkPreview(db, preview)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html, err := app.renderPosts(posts)
+	if err != nil || !strings.Contains(string(html[post.Id]), "First title") {
+		t.Fatalf("post should show the preview, got %s, %v", html[post.Id], err)
+	}
+	stale := html[post.Id]
+	previews, err := app.postLinkPreviews(posts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staleVersion := app.renderVersion(previews[post.Id])
+
+	preview.Title = "Second title"
+	preview.Fetched = preview.Fetched.Add(time.Minute)
+	err = model.SaveLinkPreview(db, preview)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a render that started before the refresh caches its html late
+	err = model.SaveRenderedPost(db, post.Id, staleVersion, string(stale))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	html, err = app.renderPosts(posts)
+	if err != nil || !strings.Contains(string(html[post.Id]), "Second title") {
+		t.Errorf("a cached post should show the refreshed preview, got %s, %v", html[post.Id], err)
+	}
+}
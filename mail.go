@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// mailer sends plain text mail. The smtp mailer is used when -smtp is set,
+// otherwise mail is only logged. replyTo may be empty.
+type mailer interface {
+	SendMail(to, replyTo, subject, body string) error
+}
+
+// baseURL is the absolute root of the forum, used for links in mail.
+func (app *app) baseURL() string {
+	if *baseURL != "" {
+		return strings.TrimRight(*baseURL, "/")
+	}
+	return "http://" + *listen
+}
+
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func newMailer() mailer {
+	if *smtpAddr == "" {
+		return logMailer{}
+	}
+
+	var auth smtp.Auth
+	if *smtpUser != "" {
+		host, _, _ := net.SplitHostPort(*smtpAddr)
+		auth = smtp.PlainAuth("", *smtpUser, *smtpPassword, host)
+	}
+
+	return &smtpMailer{*smtpAddr, *mailFrom, auth}
+}
+
+func (m *smtpMailer) SendMail(to, replyTo, subject, body string) error {
+	msg := "From: " + m.from + "\r\n" +
+		"To: " + to + "\r\n"
+	if replyTo != "" {
+		msg += "Reply-To: " + replyTo + "\r\n"
+	}
+	msg += "Subject: " + subject + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		strings.Replace(body, "\n", "\r\n", -1)
+
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg))
+}
+
+type logMailer struct{}
+
+func (logMailer) SendMail(to, replyTo, subject, body string) error {
+	if replyTo != "" {
+		log.Printf("mail to %s, reply to %s: %s\n%s", to, replyTo, subject, body)
+		return nil
+	}
+	log.Printf("mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
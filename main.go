@@ -10,6 +10,8 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/GeertJohan/go.rice"
 	"github.com/daaku/go.httpgzip"
@@ -17,12 +19,45 @@ import (
 )
 
 const (
-	limitPosts  = 10
-	limitTopics = 10
+	limitNotifications     = 10
+	limitNotificationsPage = 50
+	limitFeedEntries       = 20
+	limitWebhookDeliveries = 50
+
+	maxPreviewSize = 1 << 20
+
+	digestInterval  = time.Minute
+	webhookInterval = 10 * time.Second
 )
 
+// commands can be given after the flags to run a maintenance task instead of
+// serving the forum.
+var commands = map[string]func(app *app) error{
+	"rerender": (*app).rerenderPosts,
+	"recount":  (*app).recount,
+}
+
 var listen = flag.String("listen", "localhost:8080", "host and port to listen on")
 var db = flag.String("db", "forum.db", "sqlite3 database file")
+var baseURL = flag.String("base-url", "", "absolute url of the forum used in mail, defaults to http://<listen>")
+var smtpAddr = flag.String("smtp", "", "host and port of the smtp server for outgoing mail, mail is only logged if empty")
+var smtpUser = flag.String("smtp-user", "", "smtp username")
+var smtpPassword = flag.String("smtp-password", "", "smtp password")
+var mailFrom = flag.String("mail-from", "forum@localhost", "sender address for outgoing mail")
+var replySecret = flag.String("reply-secret", "", "secret for signing reply-by-email addresses, replying by email is disabled if empty")
+var attachmentDir = flag.String("attachments", "attachments", "directory for uploaded attachments")
+var attachmentMaxSize = flag.Int64("attachment-max-size", 5<<20, "maximum size of an attachment in bytes")
+var attachmentQuota = flag.Int64("attachment-quota", 50<<20, "maximum total size of attachments per user in bytes")
+var attachmentTypes = flag.String("attachment-types", "image/png,image/jpeg,image/gif,image/webp,application/pdf,text/plain,application/zip", "comma separated content types allowed as attachments")
+var markdownOptions = flag.String("markdown", "tables,fenced,autolink,strikethrough,definitions,footnotes,tasklists,highlight", "comma separated markdown extensions: tables, fenced, autolink, strikethrough, definitions, footnotes, tasklists and highlight")
+var forumPolicies = flag.String("forum-policies", "", "comma separated forum id=policy pairs choosing how posts in a forum are sanitized, ugc (the default) or text")
+var linkPreviewHosts = flag.String("link-previews", "", "comma separated hosts, subdomains included, whose links are expanded into preview cards, previews are off if empty")
+var postsPerPage = flag.Int("posts-per-page", 10, "posts shown per page of a topic unless a user chooses otherwise")
+var topicsPerPage = flag.Int("topics-per-page", 10, "topics shown per page of a forum unless a user chooses otherwise")
+var threadedPosts = flag.Int("threaded-posts", 200, "posts shown per page of the threaded view of a topic")
+var dev = flag.Bool("dev", false, "development mode: templates are reloaded from ./templates when they change, static files are served from ./static uncached and template errors are shown in the browser")
+var themesDir = flag.String("themes", "themes", "directory of the themes an admin can choose from")
+var admins = flag.String("admins", "", "comma separated usernames allowed to administer the forum")
 
 func backup() error {
 	src, err := os.Open(*db)
@@ -53,8 +88,23 @@ func backup() error {
 	return gzipWriter.Close()
 }
 
+// withoutGzipForStreams serves event streams unbuffered and lets WebSocket
+// upgrades take over the connection, skipping compression for both.
+func withoutGzipForStreams(stream http.Handler, compressed http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Accept") == "text/event-stream" || strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+			stream.ServeHTTP(w, req)
+			return
+		}
+		compressed.ServeHTTP(w, req)
+	})
+}
+
 func main() {
 	flag.Parse()
+	if *postsPerPage < 1 || *topicsPerPage < 1 {
+		log.Panicln("page sizes must be at least 1")
+	}
 
 	err := backup()
 	if err != nil {
@@ -66,24 +116,68 @@ func main() {
 	defer app.destroy()
 	log.Println("database opened")
 
+	if flag.NArg() > 0 {
+		command, ok := commands[flag.Arg(0)]
+		if !ok {
+			log.Panicln("unknown command " + flag.Arg(0))
+		}
+
+		err = command(app)
+		if err != nil {
+			log.Panicln(err)
+		}
+		return
+	}
+
+	go app.runDigests(digestInterval)
+	go app.runWebhooks(webhookInterval)
+	go app.runLinkPreviews()
+
 	r := mux.NewRouter()
 	staticBox := rice.MustFindBox("static").HTTPBox()
-	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(staticBox.HTTPBox())))
+	static := http.StripPrefix("/static/", http.FileServer(staticBox.HTTPBox()))
+	if *dev {
+		static = withoutCaching(http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	}
+	r.PathPrefix("/static/").Handler(static)
+	themeAssets := http.StripPrefix("/themes/", app.themes.assets())
+	if *dev {
+		themeAssets = withoutCaching(themeAssets)
+	}
+	r.PathPrefix("/themes/").Handler(themeAssets)
 
 	r.HandleFunc("/", app.handleIndex)
+	r.HandleFunc("/feed.{format:atom|rss}", app.handleLatestFeed).Methods("GET")
 
 	f := r.PathPrefix("/forum").Subrouter()
 	f.HandleFunc("/{id:[0-9]+}", app.handleForum).Methods("GET")
 	f.HandleFunc("/{id:[0-9]+}/page/{page:[0-9]+}", app.handleForum).Methods("GET")
+	f.HandleFunc("/{id:[0-9]+}/feed.{format:atom|rss}", app.handleForumFeed).Methods("GET")
 	f.HandleFunc("/{id:[0-9]+}/add", app.handleLoginRequired(app.handleAddTopic, "/forum")).Methods("GET")
 	f.HandleFunc("/{id:[0-9]+}/add", app.handleLoginRequired(app.handleSaveTopic, "/forum")).Methods("POST")
+	f.HandleFunc("/{id:[0-9]+}/subscribe", app.handleLoginRequired(app.handleSubscribeForum, "/forum")).Methods("POST")
+	f.HandleFunc("/{id:[0-9]+}/unsubscribe", app.handleLoginRequired(app.handleUnsubscribeForum, "/forum")).Methods("POST")
 
 	t := r.PathPrefix("/topic").Subrouter()
 	t.HandleFunc("/{id:[0-9]+}", app.handleTopic).Methods("GET")
 	t.HandleFunc("/{id:[0-9]+}/page/{page:[0-9]+}", app.handleTopic).Methods("GET")
+	t.HandleFunc("/{id:[0-9]+}/threaded", app.handleThreadedTopic).Methods("GET")
+	t.HandleFunc("/{id:[0-9]+}/threaded/page/{page:[0-9]+}", app.handleThreadedTopic).Methods("GET")
+	t.HandleFunc("/{id:[0-9]+}/events", app.handleTopicEvents).Methods("GET")
+	t.HandleFunc("/{id:[0-9]+}/feed.{format:atom|rss}", app.handleTopicFeed).Methods("GET")
 	t.HandleFunc("/{id:[0-9]+}/add", app.handleLoginRequired(app.handleAddPost, "/topic")).Methods("GET")
 	t.HandleFunc("/{id:[0-9]+}/add", app.handleLoginRequired(app.handleSavePost, "/topic")).Methods("POST")
 	t.HandleFunc("/{id:[0-9]+}/delete", app.handleLoginRequired(app.handleDeletePost, "/topic")).Methods("POST")
+	t.HandleFunc("/{id:[0-9]+}/subscribe", app.handleLoginRequired(app.handleSubscribeTopic, "/topic")).Methods("POST")
+	t.HandleFunc("/{id:[0-9]+}/unsubscribe", app.handleLoginRequired(app.handleUnsubscribeTopic, "/topic")).Methods("POST")
+
+	r.HandleFunc("/post/{id:[0-9]+}", app.handlePost).Methods("GET")
+	r.HandleFunc("/preview", app.handlePreview).Methods("POST")
+	r.HandleFunc("/attachment/{id:[0-9]+}/{filename}", app.handleAttachment).Methods("GET")
+
+	p := r.PathPrefix("/presence").Subrouter()
+	p.HandleFunc("/index", app.handlePresence).Methods("GET")
+	p.HandleFunc("/{kind:topic|forum}/{id:[0-9]+}", app.handlePresence).Methods("GET")
 
 	u := r.PathPrefix("/user").Subrouter()
 	u.HandleFunc("/add", app.handleRegister).Methods("GET")
@@ -91,8 +185,46 @@ func main() {
 	u.HandleFunc("/login", app.handleLogin).Methods("GET")
 	u.HandleFunc("/login", app.saveLogin).Methods("POST")
 	u.HandleFunc("/logout", app.handleLogout)
-
-	http.Handle("/", httpgzip.NewHandler(r))
+	u.HandleFunc("/preferences", app.handleLoginRequired(app.handlePreferences, "")).Methods("GET")
+	u.HandleFunc("/preferences", app.handleLoginRequired(app.handleSavePreferences, "")).Methods("POST")
+	u.HandleFunc("/profile/{username}", app.handleProfile).Methods("GET")
+	u.HandleFunc("/profile/{username}/block", app.handleLoginRequired(app.handleBlock, "")).Methods("POST")
+	u.HandleFunc("/profile/{username}/unblock", app.handleLoginRequired(app.handleUnblock, "")).Methods("POST")
+
+	m := r.PathPrefix("/messages").Subrouter()
+	m.HandleFunc("", app.handleLoginRequired(app.handleInbox, "")).Methods("GET")
+	m.HandleFunc("/sent", app.handleLoginRequired(app.handleOutbox, "")).Methods("GET")
+	m.HandleFunc("/new", app.handleLoginRequired(app.handleAddConversation, "")).Methods("GET")
+	m.HandleFunc("/new", app.handleLoginRequired(app.handleSaveConversation, "")).Methods("POST")
+	m.HandleFunc("/{id:[0-9]+}", app.handleLoginRequired(app.handleConversation, "")).Methods("GET")
+	m.HandleFunc("/{id:[0-9]+}", app.handleLoginRequired(app.handleSaveMessage, "")).Methods("POST")
+
+	s := r.PathPrefix("/subscriptions").Subrouter()
+	s.HandleFunc("", app.handleLoginRequired(app.handleSubscriptions, "")).Methods("GET")
+	s.HandleFunc("", app.handleLoginRequired(app.handleSaveSubscriptions, "")).Methods("POST")
+
+	n := r.PathPrefix("/notifications").Subrouter()
+	n.HandleFunc("", app.handleLoginRequired(app.handleNotifications, "")).Methods("GET")
+	n.HandleFunc("/read", app.handleLoginRequired(app.handleMarkNotificationsRead, "")).Methods("POST")
+	n.HandleFunc("/{id:[0-9]+}", app.handleLoginRequired(app.handleNotification, "")).Methods("GET")
+
+	r.HandleFunc("/mail/inbound", app.handleInboundMail).Methods("POST")
+
+	a := r.PathPrefix("/admin").Subrouter()
+	a.HandleFunc("/settings", app.handleAdminRequired(app.handleSettings)).Methods("GET")
+	a.HandleFunc("/settings", app.handleAdminRequired(app.handleSaveSettings)).Methods("POST")
+	a.HandleFunc("/webhooks", app.handleAdminRequired(app.handleWebhooks)).Methods("GET")
+	a.HandleFunc("/webhooks", app.handleAdminRequired(app.handleSaveWebhook)).Methods("POST")
+	a.HandleFunc("/webhooks/{id:[0-9]+}", app.handleAdminRequired(app.handleWebhook)).Methods("GET")
+	a.HandleFunc("/webhooks/{id:[0-9]+}/delete", app.handleAdminRequired(app.handleDeleteWebhook)).Methods("POST")
+
+	api := r.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/forum/{id:[0-9]+}/topics", app.handleAPITopics).Methods("GET")
+	api.HandleFunc("/topic/{id:[0-9]+}/posts", app.handleAPIPosts).Methods("GET")
+
+	r.NotFoundHandler = http.HandlerFunc(app.handleNotFound)
+
+	http.Handle("/", withRequestID(withPage(withoutGzipForStreams(r, httpgzip.NewHandler(r)))))
 
 	log.Printf("Serving on %s\n", *listen)
 	log.Fatal(http.ListenAndServe(*listen, nil))
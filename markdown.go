@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"html"
+	"html/template"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/mt2d2/forum/model"
+	"github.com/russross/blackfriday"
+)
+
+// markdownBaseExtensions are always enabled, existing posts rely on them.
+const markdownBaseExtensions = blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
+	blackfriday.EXTENSION_SPACE_HEADERS |
+	blackfriday.EXTENSION_HEADER_IDS |
+	blackfriday.EXTENSION_BACKSLASH_LINE_BREAK
+
+const markdownHTMLFlags = blackfriday.HTML_USE_XHTML |
+	blackfriday.HTML_USE_SMARTYPANTS |
+	blackfriday.HTML_SMARTYPANTS_FRACTIONS |
+	blackfriday.HTML_SMARTYPANTS_DASHES |
+	blackfriday.HTML_SMARTYPANTS_LATEX_DASHES
+
+// markdownExtensions maps the names accepted by -markdown to blackfriday
+// extensions. tasklists and highlight are implemented by postRenderer.
+var markdownExtensions = map[string]int{
+	"tables":        blackfriday.EXTENSION_TABLES,
+	"fenced":        blackfriday.EXTENSION_FENCED_CODE,
+	"autolink":      blackfriday.EXTENSION_AUTOLINK,
+	"strikethrough": blackfriday.EXTENSION_STRIKETHROUGH,
+	"definitions":   blackfriday.EXTENSION_DEFINITION_LISTS,
+	"footnotes":     blackfriday.EXTENSION_FOOTNOTES,
+	"tasklists":     0,
+	"highlight":     0,
+}
+
+// sanitizerPolicies are the policies forums can be given with -forum-policies.
+var sanitizerPolicies = map[string]func() *bluemonday.Policy{
+	"ugc":  ugcPolicy,
+	"text": textPolicy,
+}
+
+const defaultSanitizerPolicy = "ugc"
+
+// markdownRevision is part of the renderer version, bump it when a change to
+// the rendering code should invalidate cached posts.
+const markdownRevision = 2
+
+// rerenderBatchSize is how many posts the rerender command loads at once.
+const rerenderBatchSize = 500
+
+// ugcPolicy allows what users may post anywhere, including images and media.
+func ugcPolicy() *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+	policy.AllowElements("video", "audio", "source")
+	policy.AllowAttrs("controls").OnElements("video", "audio")
+	policy.AllowAttrs("src").Matching(regexp.MustCompile(`[\p{L}\p{N}\s\-_',:\[\]!\./\\\(\)&]*`)).Globally()
+	allowRendererMarkup(policy)
+	return policy
+}
+
+// textPolicy allows formatted text and links but no images, media or raw
+// html beyond what Markdown produces.
+func textPolicy() *bluemonday.Policy {
+	policy := bluemonday.NewPolicy()
+	policy.AllowStandardURLs()
+	policy.AllowStandardAttributes()
+	policy.AllowAttrs("href").OnElements("a")
+	policy.RequireNoFollowOnLinks(true)
+	policy.AllowElements("p", "br", "hr", "div", "h1", "h2", "h3", "h4", "h5", "h6",
+		"strong", "em", "del", "code", "pre", "blockquote", "sup",
+		"ul", "ol", "li", "dl", "dt", "dd",
+		"table", "thead", "tbody", "tr", "th", "td")
+	policy.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|center|right)$`)).OnElements("th", "td")
+	allowRendererMarkup(policy)
+	return policy
+}
+
+// allowRendererMarkup lets through the markup added by postRenderer.
+func allowRendererMarkup(policy *bluemonday.Policy) {
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^hl-[a-z]$`)).OnElements("span")
+	policy.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	policy.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^(checked|disabled)?$`)).OnElements("input")
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^linkPreview[A-Za-z]*$`)).OnElements("div", "a", "p")
+}
+
+// markdownRenderer turns post text into sanitized html. It is safe for
+// concurrent use.
+type markdownRenderer struct {
+	extensions int
+	taskLists  bool
+	highlight  bool
+	policy     *bluemonday.Policy
+	forums     map[int]*bluemonday.Policy
+	// users tells if a username belongs to a member, only their mentions are
+	// linked.
+	users func(username string) bool
+	// version changes with the configuration, html cached under another
+	// version is rendered again.
+	version string
+}
+
+// newMarkdownRenderer configures a renderer from a comma separated list of
+// extensions and comma separated forum id=policy pairs.
+func newMarkdownRenderer(extensions, forumPolicies string) (*markdownRenderer, error) {
+	m := &markdownRenderer{
+		extensions: markdownBaseExtensions,
+		policy:     sanitizerPolicies[defaultSanitizerPolicy](),
+		forums:     make(map[int]*bluemonday.Policy),
+	}
+	config := make([]string, 0)
+
+	for _, name := range strings.Split(extensions, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		config = append(config, name)
+
+		extension, ok := markdownExtensions[name]
+		if !ok {
+			return nil, errors.New("unknown markdown extension " + name)
+		}
+		m.extensions |= extension
+		m.taskLists = m.taskLists || name == "tasklists"
+		m.highlight = m.highlight || name == "highlight"
+	}
+
+	policies := make(map[string]*bluemonday.Policy)
+	for _, pair := range strings.Split(forumPolicies, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("forum policy must be id=policy, not " + pair)
+		}
+		forumId, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, errors.New("invalid forum id in " + pair)
+		}
+		newPolicy, ok := sanitizerPolicies[parts[1]]
+		if !ok {
+			return nil, errors.New("unknown sanitizer policy " + parts[1])
+		}
+
+		// forums sharing a policy share one instance
+		if _, ok := policies[parts[1]]; !ok {
+			policies[parts[1]] = newPolicy()
+		}
+		m.forums[forumId] = policies[parts[1]]
+		config = append(config, strconv.Itoa(forumId)+"="+parts[1])
+	}
+
+	sort.Strings(config)
+	sum := sha1.Sum([]byte(strconv.Itoa(markdownRevision) + ":" + strings.Join(config, ",")))
+	m.version = hex.EncodeToString(sum[:8])
+
+	return m, nil
+}
+
+func newMarkdown() *markdownRenderer {
+	m, err := newMarkdownRenderer(*markdownOptions, *forumPolicies)
+	if err != nil {
+		log.Panicln(err)
+	}
+	return m
+}
+
+// linkMentions turns @username mentions of members into links to their
+// profile.
+func (m *markdownRenderer) linkMentions(markdown string) string {
+	return model.ReplaceMentions(markdown, func(username string) string {
+		if m.users == nil || !m.users(username) {
+			return "@" + username
+		}
+		return "[@" + username + "](/user/profile/" + url.PathEscape(username) + ")"
+	})
+}
+
+// render converts markdown to html sanitized with the policy of the given
+// forum, -1 uses the default policy.
+func (m *markdownRenderer) render(forumId int, markdown string) template.HTML {
+	return m.renderWithPreviews(forumId, markdown, nil)
+}
+
+// renderWithPreviews renders like render, replacing standalone links that
+// have a preview with a card.
+func (m *markdownRenderer) renderWithPreviews(forumId int, markdown string, previews map[string]model.LinkPreview) template.HTML {
+	renderer := &postRenderer{blackfriday.HtmlRenderer(markdownHTMLFlags, "", ""), m.taskLists, m.highlight, previews}
+	unsafe := blackfriday.MarkdownOptions([]byte(m.linkMentions(markdown)), renderer, blackfriday.Options{Extensions: m.extensions})
+
+	policy, ok := m.forums[forumId]
+	if !ok {
+		policy = m.policy
+	}
+
+	return template.HTML(policy.SanitizeBytes(unsafe))
+}
+
+// renderPosts returns the html of posts keyed by post id. Posts that were not
+// rendered by the current renderer version, with the current previews of
+// their links, yet are rendered and cached.
+func (app *app) renderPosts(posts []model.Post) (map[int]template.HTML, error) {
+	previews, err := app.postLinkPreviews(posts)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[int]string, len(posts))
+	for _, post := range posts {
+		versions[post.Id] = app.renderVersion(previews[post.Id])
+	}
+	cached, err := model.FindRenderedPosts(app.db, versions)
+	if err != nil {
+		return nil, err
+	}
+
+	html := make(map[int]template.HTML, len(posts))
+	uncached := make([]model.Post, 0)
+	for _, post := range posts {
+		if rendered, ok := cached[post.Id]; ok {
+			html[post.Id] = template.HTML(rendered)
+		} else {
+			uncached = append(uncached, post)
+		}
+	}
+
+	rendered, err := app.renderAndCache(uncached, previews)
+	if err != nil {
+		return nil, err
+	}
+	for id, postHTML := range rendered {
+		html[id] = postHTML
+	}
+
+	return html, nil
+}
+
+// renderVersion is the version the html of a post with previews is cached
+// under: the renderer version, followed by when the previews were fetched. A
+// refreshed preview makes the post render again, and html rendered with an
+// older preview while it was fetched is never used.
+func (app *app) renderVersion(previews map[string]model.LinkPreview) string {
+	if len(previews) == 0 {
+		return app.markdown.version
+	}
+
+	urls := make([]string, 0, len(previews))
+	for url := range previews {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	fetched := make([]string, len(urls))
+	for i, url := range urls {
+		fetched[i] = url + " " + strconv.FormatInt(previews[url].Fetched.UnixNano(), 10)
+	}
+	sum := sha1.Sum([]byte(strings.Join(fetched, "\n")))
+	return app.markdown.version + "-" + hex.EncodeToString(sum[:4])
+}
+
+// renderAndCache renders posts with the previews of their links, keyed by
+// post id, and caches the html.
+func (app *app) renderAndCache(posts []model.Post, previews map[int]map[string]model.LinkPreview) (map[int]template.HTML, error) {
+	html := make(map[int]template.HTML, len(posts))
+	for _, post := range posts {
+		html[post.Id] = app.markdown.renderWithPreviews(post.ForumId, post.Text, previews[post.Id])
+		err := model.SaveRenderedPost(app.db, post.Id, app.renderVersion(previews[post.Id]), string(html[post.Id]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return html, nil
+}
+
+// rerenderPosts renders every post again with the current configuration,
+// instead of waiting for each to be rendered when it is next shown.
+func (app *app) rerenderPosts() error {
+	count := 0
+	afterId := 0
+	for {
+		posts, err := model.FindPostsAfterId(app.db, afterId, rerenderBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(posts) == 0 {
+			break
+		}
+
+		previews, err := app.postLinkPreviews(posts)
+		if err != nil {
+			return err
+		}
+		_, err = app.renderAndCache(posts, previews)
+		if err != nil {
+			return err
+		}
+
+		count += len(posts)
+		afterId = posts[len(posts)-1].Id
+	}
+
+	log.Printf("rendered %d posts with renderer version %s\n", count, app.markdown.version)
+	return nil
+}
+
+// postRenderer adds task lists, highlighted code blocks and link preview
+// cards to blackfriday's html renderer.
+type postRenderer struct {
+	blackfriday.Renderer
+	taskLists bool
+	highlight bool
+	previews  map[string]model.LinkPreview
+}
+
+// linkParagraph matches a paragraph that is nothing but a link to its url.
+var linkParagraph = regexp.MustCompile(`^<p><a href="([^"]+)">([^<]+)</a></p>$`)
+
+func (r *postRenderer) Paragraph(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	r.Renderer.Paragraph(out, text)
+	if len(r.previews) == 0 {
+		return
+	}
+
+	match := linkParagraph.FindSubmatch(bytes.TrimSpace(out.Bytes()[marker:]))
+	if match == nil || !bytes.Equal(match[1], match[2]) {
+		return
+	}
+	preview, ok := r.previews[html.UnescapeString(string(match[1]))]
+	if !ok {
+		return
+	}
+
+	out.Truncate(marker)
+	if out.Len() > 0 {
+		out.WriteByte('\n')
+	}
+	writeLinkPreview(out, preview)
+}
+
+var taskListItem = regexp.MustCompile(`^(<p>)?\[([ xX])\]\s`)
+
+func (r *postRenderer) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	if match := taskListItem.FindSubmatchIndex(text); r.taskLists && match != nil {
+		item := new(bytes.Buffer)
+		if match[2] >= 0 {
+			item.WriteString("<p>")
+		}
+		item.WriteString(`<input type="checkbox" disabled="disabled"`)
+		if text[match[4]] != ' ' {
+			item.WriteString(` checked="checked"`)
+		}
+		item.WriteString(" /> ")
+		item.Write(text[match[1]:])
+		text = item.Bytes()
+	}
+
+	r.Renderer.ListItem(out, text, flags)
+}
+
+func (r *postRenderer) BlockCode(out *bytes.Buffer, text []byte, info string) {
+	lang := strings.ToLower(strings.TrimSpace(info))
+	if i := strings.IndexAny(lang, "\t "); i >= 0 {
+		lang = lang[:i]
+	}
+
+	if !r.highlight || highlightLanguages[lang] == nil {
+		r.Renderer.BlockCode(out, text, info)
+		return
+	}
+
+	if out.Len() > 0 {
+		out.WriteByte('\n')
+	}
+	highlight(out, text, lang)
+}
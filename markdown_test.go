@@ -0,0 +1,218 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mt2d2/forum/model"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testMember tells if a username is a member of the forum the renderers link
+// mentions for, only test is.
+func testMember(username string) bool {
+	return username == "test"
+}
+
+// testRenderers returns a renderer per sanitizer policy, each giving forum 1
+// that policy.
+func testRenderers(t *testing.T) map[string]*markdownRenderer {
+	renderers := make(map[string]*markdownRenderer)
+	for name := range sanitizerPolicies {
+		renderer, err := newMarkdownRenderer(*markdownOptions, "1="+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderer.users = testMember
+		renderers[name] = renderer
+	}
+	return renderers
+}
+
+// TestMarkdownGolden renders testdata/markdown/*.md with every sanitizer
+// policy and compares the result to <name>.<policy>.html. Run with -update
+// after an intended change and review the diff.
+func TestMarkdownGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "markdown", "*.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no golden files")
+	}
+
+	for policy, renderer := range testRenderers(t) {
+		for _, input := range inputs {
+			markdown, err := ioutil.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			html := []byte(renderer.render(1, string(markdown)))
+			golden := strings.TrimSuffix(input, ".md") + "." + policy + ".html"
+			if *updateGolden {
+				err = ioutil.WriteFile(golden, html, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(html) != string(want) {
+				t.Errorf("%s rendered with %s differs from %s:\n%s", input, policy, golden, html)
+			}
+		}
+	}
+}
+
+// unsafeHTML matches markup that must never survive sanitizing, whatever the
+// golden files say.
+var unsafeHTML = regexp.MustCompile(`(?i)<script|<iframe|<svg|<style|<meta|<form|\son[a-z]+=|javascript:|data:text|style=|type="text"`)
+
+func TestMarkdownXSS(t *testing.T) {
+	markdown, err := ioutil.ReadFile(filepath.Join("testdata", "markdown", "xss.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for policy, renderer := range testRenderers(t) {
+		html := string(renderer.render(1, string(markdown)))
+		if match := unsafeHTML.FindString(html); match != "" {
+			t.Errorf("%s policy let %q through:\n%s", policy, match, html)
+		}
+	}
+}
+
+func TestMarkdownMentions(t *testing.T) {
+	renderer := testRenderers(t)[defaultSanitizerPolicy]
+
+	html := string(renderer.render(1, "@test and @nobody, https://medium.com/@test/post"))
+	if strings.Count(html, `href="/user/profile/test"`) != 1 {
+		t.Errorf("only the mention of a member should be linked, got %s", html)
+	}
+	if !strings.Contains(html, `href="https://medium.com/@test/post"`) {
+		t.Errorf("mentions in urls should be left alone, got %s", html)
+	}
+}
+
+func TestMarkdownForumPolicies(t *testing.T) {
+	renderer, err := newMarkdownRenderer(*markdownOptions, "2=text")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := "![cat](/attachment/1/cat.png)"
+	if html := renderer.render(1, image); !strings.Contains(string(html), "<img") {
+		t.Errorf("forums without a policy should allow images, got %s", html)
+	}
+	if html := renderer.render(2, image); strings.Contains(string(html), "<img") {
+		t.Errorf("text forums should not allow images, got %s", html)
+	}
+
+	if _, err := newMarkdownRenderer(*markdownOptions, "2=nope"); err == nil {
+		t.Error("unknown policies should be rejected")
+	}
+	if _, err := newMarkdownRenderer("tables,nope", ""); err == nil {
+		t.Error("unknown extensions should be rejected")
+	}
+}
+
+func TestMarkdownExtensions(t *testing.T) {
+	plain, err := newMarkdownRenderer("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := "| a | b |\n|---|---|\n| c | d |\n"
+	if html := plain.render(-1, table); strings.Contains(string(html), "<table") {
+		t.Errorf("tables should be off, got %s", html)
+	}
+	if html := plain.render(-1, "- [x] done\n"); strings.Contains(string(html), "checkbox") {
+		t.Errorf("task lists should be off, got %s", html)
+	}
+
+	fenced, err := newMarkdownRenderer("fenced", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html := fenced.render(-1, "```go\n// code\n```\n"); strings.Contains(string(html), "hl-c") {
+		t.Errorf("highlighting should be off, got %s", html)
+	}
+}
+
+func TestRenderPosts(t *testing.T) {
+	db, err := model.GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown, err := newMarkdownRenderer("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &app{db: db, markdown: markdown, previews: newLinkPreviewer("", time.Second)}
+
+	post, err := model.FindOnePost(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	html, err := app.renderPosts([]model.Post{post})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html[1] != markdown.render(post.ForumId, post.Text) {
+		t.Errorf("wrong html %s", html[1])
+	}
+
+	// the cache is used while the version matches
+	err = model.SaveRenderedPost(db, 1, markdown.version, "<p>cached</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	html, err = app.renderPosts([]model.Post{post})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html[1] != "<p>cached</p>" {
+		t.Errorf("cached html should be used, got %s", html[1])
+	}
+
+	app.markdown, err = newMarkdownRenderer("", "1=text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.markdown.version == markdown.version {
+		t.Fatal("changing the configuration should change the version")
+	}
+	html, err = app.renderPosts([]model.Post{post})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html[1] == "<p>cached</p>" {
+		t.Error("html of another version should be rendered again")
+	}
+
+	err = app.rerenderPosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := model.FindRenderedPosts(db, map[int]string{1: app.markdown.version, 2: app.markdown.version, 30: app.markdown.version})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rendered) != 3 {
+		t.Errorf("every post should be rendered, got %d", len(rendered))
+	}
+}
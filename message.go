@@ -0,0 +1,211 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mt2d2/forum/model"
+)
+
+func (app *app) handleInbox(w http.ResponseWriter, req *http.Request) {
+	app.handleMailbox(w, req, false)
+}
+
+func (app *app) handleOutbox(w http.ResponseWriter, req *http.Request) {
+	app.handleMailbox(w, req, true)
+}
+
+func (app *app) handleMailbox(w http.ResponseWriter, req *http.Request, outbox bool) {
+	session, _ := app.sessions.Get(req, "forumSession")
+	userID, _ := session.Values["user_id"].(int)
+
+	find := model.FindInbox
+	if outbox {
+		find = model.FindOutbox
+	}
+
+	conversations, err := find(app.db, userID)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	app.addBreadCrumb(req, "/messages", app.T(req, "Messages"))
+	if outbox {
+		app.addBreadCrumb(req, "/messages/sent", app.T(req, "Sent"))
+	}
+
+	app.renderTemplate(w, req, "messages", &messagesView{Conversations: conversations, Outbox: outbox})
+}
+
+func (app *app) handleAddConversation(w http.ResponseWriter, req *http.Request) {
+	app.addBreadCrumb(req, "/messages", app.T(req, "Messages"))
+	app.addBreadCrumb(req, "/messages/new", app.T(req, "New Message"))
+
+	app.renderTemplate(w, req, "addConversation", &addConversationView{To: req.URL.Query().Get("to")})
+}
+
+func (app *app) handleSaveConversation(w http.ResponseWriter, req *http.Request) {
+	session, _ := app.sessions.Get(req, "forumSession")
+	userID, _ := session.Values["user_id"].(int)
+
+	req.ParseForm()
+
+	sender, err := model.FindOneUserById(app.db, userID)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	conversation := model.NewConversation()
+	conversation.Subject = req.PostFormValue("Subject")
+	conversation.CreatorId = userID
+	conversation.Participants = append(conversation.Participants, sender)
+
+	errs := make([]error, 0)
+	to := strings.FieldsFunc(req.PostFormValue("To"), func(r rune) bool { return r == ',' || r == ' ' })
+	for _, username := range to {
+		recipient, err := model.FindOneUserByUsername(app.db, username)
+		if err != nil {
+			errs = append(errs, model.Errorf("There is no user named %s.", username))
+			continue
+		}
+		if !conversation.HasParticipant(recipient.Id) {
+			conversation.Participants = append(conversation.Participants, recipient)
+		}
+	}
+
+	message := model.NewMessage()
+	message.UserId = userID
+	message.Text = req.PostFormValue("Text")
+
+	_, conversationErrs := model.ValidateConversation(app.db, conversation)
+	errs = append(errs, conversationErrs...)
+	_, messageErrs := model.ValidateFirstMessage(message)
+	errs = append(errs, messageErrs...)
+
+	if len(errs) > 0 {
+		app.addErrorFlashes(w, req, errs)
+		http.Redirect(w, req, "/messages/new?to="+url.QueryEscape(req.PostFormValue("To")), http.StatusFound)
+		return
+	}
+
+	err = model.SaveConversation(app.db, conversation, message)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	http.Redirect(w, req, "/messages/"+strconv.Itoa(conversation.Id), http.StatusFound)
+}
+
+// findParticipatingConversation loads a conversation, refusing users that are
+// not taking part in it.
+func (app *app) findParticipatingConversation(w http.ResponseWriter, req *http.Request, userID int) (*model.Conversation, bool) {
+	conversation, err := model.FindOneConversation(app.db, mux.Vars(req)["id"])
+	if err != nil {
+		app.handleError(w, req, err)
+		return nil, false
+	}
+
+	if !conversation.HasParticipant(userID) {
+		app.addErrorFlash(w, req, errors.New("You can only read your own messages!"))
+		http.Redirect(w, req, "/messages", http.StatusFound)
+		return nil, false
+	}
+
+	return conversation, true
+}
+
+func (app *app) handleConversation(w http.ResponseWriter, req *http.Request) {
+	session, _ := app.sessions.Get(req, "forumSession")
+	userID, _ := session.Values["user_id"].(int)
+
+	conversation, ok := app.findParticipatingConversation(w, req, userID)
+	if !ok {
+		return
+	}
+
+	messages, err := model.FindMessages(app.db, conversation.Id)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	err = model.MarkConversationRead(app.db, conversation.Id, userID)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	app.addBreadCrumb(req, "/messages", app.T(req, "Messages"))
+	app.addBreadCrumb(req, "/messages/"+strconv.Itoa(conversation.Id), conversation.Subject)
+
+	app.renderTemplate(w, req, "conversation", &conversationView{Conversation: conversation, Messages: messages})
+}
+
+func (app *app) handleSaveMessage(w http.ResponseWriter, req *http.Request) {
+	session, _ := app.sessions.Get(req, "forumSession")
+	userID, _ := session.Values["user_id"].(int)
+
+	conversation, ok := app.findParticipatingConversation(w, req, userID)
+	if !ok {
+		return
+	}
+
+	req.ParseForm()
+
+	message := model.NewMessage()
+	message.ConversationId = conversation.Id
+	message.UserId = userID
+	message.Text = req.PostFormValue("Text")
+
+	ok, errs := model.ValidateMessage(app.db, conversation, message)
+	if !ok {
+		app.addErrorFlashes(w, req, errs)
+		http.Redirect(w, req, "/messages/"+strconv.Itoa(conversation.Id), http.StatusFound)
+		return
+	}
+
+	err := model.SaveMessage(app.db, message)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	http.Redirect(w, req, "/messages/"+strconv.Itoa(conversation.Id)+"#message-"+strconv.Itoa(message.Id), http.StatusFound)
+}
+
+func (app *app) handleBlock(w http.ResponseWriter, req *http.Request) {
+	app.handleBlocking(w, req, model.BlockUser, "User blocked.")
+}
+
+func (app *app) handleUnblock(w http.ResponseWriter, req *http.Request) {
+	app.handleBlocking(w, req, model.UnblockUser, "User unblocked.")
+}
+
+func (app *app) handleBlocking(w http.ResponseWriter, req *http.Request, update func(db *sql.DB, userId, blockedId int) error, success string) {
+	session, _ := app.sessions.Get(req, "forumSession")
+	userID, _ := session.Values["user_id"].(int)
+
+	username := mux.Vars(req)["username"]
+	blocked, err := model.FindOneUserByUsername(app.db, username)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	err = update(app.db, userID, blocked.Id)
+	if err != nil {
+		app.addErrorFlash(w, req, err)
+	} else {
+		app.addSuccessFlash(w, req, success)
+	}
+
+	http.Redirect(w, req, "/user/profile/"+url.PathEscape(username), http.StatusFound)
+}
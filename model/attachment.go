@@ -0,0 +1,186 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Attachment is a file uploaded with a post. The file itself lives in storage
+// under StorageKey.
+type Attachment struct {
+	Id          int
+	PostId      int
+	UserId      int
+	Filename    string
+	ContentType string
+	Size        int64
+	StorageKey  string
+	Created     time.Time
+}
+
+// AttachmentLimits restricts what users may upload.
+type AttachmentLimits struct {
+	MaxSize   int64
+	UserQuota int64
+	Types     []string
+}
+
+func NewAttachment() *Attachment {
+	return &Attachment{-1, -1, -1, "", "", 0, "", time.Now().UTC()}
+}
+
+func (attachment *Attachment) IsImage() bool {
+	return strings.HasPrefix(attachment.ContentType, "image/")
+}
+
+// FormatSize returns a human readable size such as 1.5 MB.
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return strconv.FormatInt(size, 10) + " B"
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGT"[exp])
+}
+
+// UsedAttachmentSpace returns the total size of a user's attachments.
+func UsedAttachmentSpace(db *sql.DB, userId int) (int64, error) {
+	var used int64
+
+	row := db.QueryRow("SELECT ifnull(sum(size), 0) FROM attachments WHERE user_id = ?", userId)
+	err := row.Scan(&used)
+	if err != nil {
+		return 0, err
+	}
+
+	return used, nil
+}
+
+// ValidateAttachments checks a batch of uploads by the same user against the
+// limits, counting the whole batch towards the user's quota.
+func ValidateAttachments(db *sql.DB, userId int, attachments []*Attachment, limits AttachmentLimits) (ok bool, errs []error) {
+	errs = make([]error, 0)
+
+	var total int64
+	for _, attachment := range attachments {
+		total += attachment.Size
+
+		if strings.TrimSpace(attachment.Filename) == "" {
+			errs = append(errs, errors.New("Attachment must have a file name."))
+		}
+
+		if attachment.Size > limits.MaxSize {
+			errs = append(errs, Errorf("%s is larger than %s.", attachment.Filename, FormatSize(limits.MaxSize)))
+		}
+
+		allowed := false
+		for _, contentType := range limits.Types {
+			if contentType == attachment.ContentType {
+				allowed = true
+			}
+		}
+		if !allowed {
+			errs = append(errs, Errorf("%s is not an allowed type of file.", attachment.Filename))
+		}
+	}
+
+	used, err := UsedAttachmentSpace(db, userId)
+	if err != nil {
+		errs = append(errs, err)
+	} else if used+total > limits.UserQuota {
+		errs = append(errs, Errorf("Attachments would exceed your quota of %s.", FormatSize(limits.UserQuota)))
+	}
+
+	return len(errs) == 0, errs
+}
+
+func SaveAttachment(db execer, attachment *Attachment) error {
+	result, err := db.Exec("INSERT INTO attachments (id, post_id, user_id, filename, content_type, size, storage_key, created) VALUES (NULL,?,?,?,?,?,?,?)",
+		attachment.PostId, attachment.UserId, attachment.Filename, attachment.ContentType, attachment.Size, attachment.StorageKey, attachment.Created)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	attachment.Id = int(id)
+
+	return nil
+}
+
+const selectAttachments = "SELECT id, post_id, user_id, filename, content_type, size, storage_key, created FROM attachments"
+
+func scanAttachment(row rowScanner) (Attachment, error) {
+	var (
+		id          int
+		postId      int
+		userId      int
+		filename    string
+		contentType string
+		size        int64
+		storageKey  string
+		created     time.Time
+	)
+
+	err := row.Scan(&id, &postId, &userId, &filename, &contentType, &size, &storageKey, &created)
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	return Attachment{id, postId, userId, filename, contentType, size, storageKey, created}, nil
+}
+
+func FindOneAttachment(db *sql.DB, reqId string) (Attachment, error) {
+	attachment, err := scanAttachment(db.QueryRow(selectAttachments+" WHERE id = ?", reqId))
+	if err != nil {
+		return Attachment{}, queryError(err, "attachment with id "+reqId)
+	}
+
+	return attachment, nil
+}
+
+// FindAttachments returns the attachments of the given posts keyed by post id.
+func FindAttachments(db *sql.DB, postIds []int) (map[int][]Attachment, error) {
+	attachments := make(map[int][]Attachment)
+	if len(postIds) == 0 {
+		return attachments, nil
+	}
+
+	args := make([]interface{}, len(postIds))
+	for i, id := range postIds {
+		args[i] = id
+	}
+
+	rows, err := db.Query(selectAttachments+" WHERE post_id IN (?"+strings.Repeat(",?", len(postIds)-1)+") ORDER BY id", args...)
+	if err != nil {
+		return nil, &InternalError{"could not query for attachments", err}
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		attachments[attachment.PostId] = append(attachments[attachment.PostId], attachment)
+	}
+
+	return attachments, nil
+}
+
+func DeleteAttachments(db execer, postId int) error {
+	_, err := db.Exec("DELETE FROM attachments WHERE post_id = ?", postId)
+	return err
+}
@@ -0,0 +1,154 @@
+package model
+
+import "testing"
+
+var testAttachmentLimits = AttachmentLimits{4096, 8192, []string{"image/png", "text/plain; charset=utf-8"}}
+
+func TestValidateAttachments(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := NewAttachment()
+	image.Filename = "dog.png"
+	image.ContentType = "image/png"
+	image.Size = 1024
+
+	if ok, errs := ValidateAttachments(db, 1, []*Attachment{image}, testAttachmentLimits); !ok {
+		t.Error(errs)
+	}
+
+	large := NewAttachment()
+	large.Filename = "large.png"
+	large.ContentType = "image/png"
+	large.Size = 5000
+	if ok, _ := ValidateAttachments(db, 2, []*Attachment{large}, testAttachmentLimits); ok {
+		t.Error("attachments must not be larger than the maximum size")
+	}
+
+	script := NewAttachment()
+	script.Filename = "evil.html"
+	script.ContentType = "text/html; charset=utf-8"
+	script.Size = 10
+	if ok, _ := ValidateAttachments(db, 2, []*Attachment{script}, testAttachmentLimits); ok {
+		t.Error("attachments must be an allowed type")
+	}
+
+	// user 1 already uses 2148 bytes
+	if ok, _ := ValidateAttachments(db, 1, []*Attachment{image, image, image, image, image, image}, testAttachmentLimits); ok {
+		t.Error("attachments must not exceed the user's quota")
+	}
+	if ok, _ := ValidateAttachments(db, 2, []*Attachment{image, image, image, image, image, image}, testAttachmentLimits); !ok {
+		t.Error("quota should be per user")
+	}
+}
+
+func TestSaveAttachment(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachment := NewAttachment()
+	attachment.PostId = 1
+	attachment.UserId = 2
+	attachment.Filename = "dog.png"
+	attachment.ContentType = "image/png"
+	attachment.Size = 1024
+	attachment.StorageKey = "abc"
+
+	err = SaveAttachment(db, attachment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := FindOneAttachment(db, "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.Id != 3 || saved.Filename != "dog.png" || !saved.IsImage() {
+		t.Error("wrong attachment")
+	}
+
+	used, err := UsedAttachmentSpace(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != 1024 {
+		t.Errorf("user 2 should use 1024 bytes, not %d", used)
+	}
+}
+
+func TestSavePostWithAttachments(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := NewPost()
+	post.Text = "look"
+	post.TopicId = 4
+	post.UserId = 2
+
+	attachment := NewAttachment()
+	attachment.UserId = 2
+	attachment.Filename = "dog.png"
+	attachment.ContentType = "image/png"
+	attachment.Size = 1024
+	attachment.StorageKey = "abc"
+
+	err = SavePostWithAttachments(db, post, []*Attachment{attachment})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachments, err := FindAttachments(db, []int{post.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.PostId != post.Id || len(attachments[post.Id]) != 1 || attachments[post.Id][0].StorageKey != "abc" {
+		t.Error("attachment should be saved with the post")
+	}
+}
+
+func TestFindAttachments(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachments, err := FindAttachments(db, []int{1, 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments[1]) != 0 || len(attachments[30]) != 2 || attachments[30][0].Filename != "cat.png" {
+		t.Error("wrong attachments")
+	}
+
+	err = DeletePost(db, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachments, err = FindAttachments(db, []int{30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments[30]) != 0 {
+		t.Error("deleting a post should delete its attachments")
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	sizes := map[int64]string{100: "100 B", 2048: "2.0 KB", 1536 * 1024: "1.5 MB"}
+	for size, formatted := range sizes {
+		if FormatSize(size) != formatted {
+			t.Errorf("FormatSize(%d) = %s, want %s", size, FormatSize(size), formatted)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// BlockUser stops blockedId from starting conversations with or messaging userId.
+func BlockUser(db *sql.DB, userId int, blockedId int) error {
+	if userId == blockedId {
+		return errors.New("You cannot block yourself.")
+	}
+
+	_, err := db.Exec("INSERT OR IGNORE INTO blocks (user_id, blocked_id) VALUES (?,?)", userId, blockedId)
+	return err
+}
+
+func UnblockUser(db *sql.DB, userId int, blockedId int) error {
+	_, err := db.Exec("DELETE FROM blocks WHERE user_id = ? AND blocked_id = ?", userId, blockedId)
+	return err
+}
+
+func HasBlocked(db *sql.DB, userId int, blockedId int) bool {
+	var count int
+	row := db.QueryRow("SELECT count(*) FROM blocks WHERE user_id = ? AND blocked_id = ?", userId, blockedId)
+	return row.Scan(&count) == nil && count > 0
+}
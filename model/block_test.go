@@ -0,0 +1,43 @@
+package model
+
+import "testing"
+
+func TestBlockUser(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = BlockUser(db, 1, 1)
+	if err == nil {
+		t.Error("should not block yourself")
+	}
+
+	err = BlockUser(db, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = BlockUser(db, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !HasBlocked(db, 1, 2) {
+		t.Error("user should be blocked")
+	}
+
+	if HasBlocked(db, 2, 1) {
+		t.Error("blocking is one way")
+	}
+
+	err = UnblockUser(db, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if HasBlocked(db, 1, 2) {
+		t.Error("user should be unblocked")
+	}
+}
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// The post counts and last post of topics and forums are stored on their rows
+// so listings do not count posts on every page view. SavePost bumps them,
+// SaveTopic counts the new topic and DeletePost recounts what it touched.
+
+const (
+	topicPostCount = `(SELECT count(*) FROM posts WHERE posts.topic_id = topics.id)`
+	topicLastPost  = `(SELECT posts.%s FROM posts WHERE posts.topic_id = topics.id
+		ORDER BY datetime(posts.published) DESC, posts.id DESC LIMIT 1)`
+
+	forumTopicCount = `(SELECT count(*) FROM topics forum_topics WHERE forum_topics.forum_id = forums.id)`
+	forumPostCount  = `(SELECT ifnull(sum(forum_topics.post_count), 0) FROM topics forum_topics WHERE forum_topics.forum_id = forums.id)`
+	forumLastPost   = `(SELECT forum_topics.%s FROM topics forum_topics
+		WHERE forum_topics.forum_id = forums.id AND forum_topics.last_post_id IS NOT NULL
+		ORDER BY datetime(forum_topics.last_post_at) DESC, forum_topics.last_post_id DESC LIMIT 1)`
+)
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// querier is a *sql.DB or a *sql.Tx that is also read from.
+type querier interface {
+	execer
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// recountTopics recomputes the counters of the topics matching where. Only
+// rows that drifted are written, the number of which is returned.
+func recountTopics(db execer, where string, args ...interface{}) (int64, error) {
+	lastPostId := fmt.Sprintf(topicLastPost, "id")
+	lastPostAt := fmt.Sprintf(topicLastPost, "published")
+
+	result, err := db.Exec(`UPDATE topics SET post_count = `+topicPostCount+`,
+			last_post_id = `+lastPostId+`, last_post_at = `+lastPostAt+`
+		WHERE (`+where+`) AND (post_count IS NOT `+topicPostCount+`
+			OR last_post_id IS NOT `+lastPostId+` OR last_post_at IS NOT `+lastPostAt+`)`, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// recountForums recomputes the counters of the forums matching where from
+// their topics, which must be counted first.
+func recountForums(db execer, where string, args ...interface{}) (int64, error) {
+	lastPostId := fmt.Sprintf(forumLastPost, "last_post_id")
+	lastPostAt := fmt.Sprintf(forumLastPost, "last_post_at")
+
+	result, err := db.Exec(`UPDATE forums SET topic_count = `+forumTopicCount+`, post_count = `+forumPostCount+`,
+			last_post_id = `+lastPostId+`, last_post_at = `+lastPostAt+`
+		WHERE (`+where+`) AND (topic_count IS NOT `+forumTopicCount+` OR post_count IS NOT `+forumPostCount+`
+			OR last_post_id IS NOT `+lastPostId+` OR last_post_at IS NOT `+lastPostAt+`)`, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// countPost adds a new post to the counters of its topic and forum.
+func countPost(tx *sql.Tx, post *Post) error {
+	_, err := tx.Exec("UPDATE topics SET post_count = post_count + 1, last_post_id = ?, last_post_at = ? WHERE id = ?",
+		post.Id, post.Published, post.TopicId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE forums SET post_count = post_count + 1, last_post_id = ?, last_post_at = ? WHERE id = (SELECT forum_id FROM topics WHERE id = ?)",
+		post.Id, post.Published, post.TopicId)
+	return err
+}
+
+// Recount recomputes the counters of every topic and forum, repairing any
+// drift, and returns how many topics and forums were off.
+func Recount(db *sql.DB) (topics int64, forums int64, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	topics, err = recountTopics(tx, "1")
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+
+	forums, err = recountForums(tx, "1")
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+
+	return topics, forums, tx.Commit()
+}
@@ -0,0 +1,136 @@
+package model
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMockupCounters(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topics, forums, err := Recount(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topics != 0 || forums != 0 {
+		t.Errorf("mockup counters are off for %d topics and %d forums", topics, forums)
+	}
+}
+
+func TestSavePostCounters(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := NewPost()
+	post.Text = "counted"
+	post.Published = time.Now()
+	post.TopicId = 5
+	post.UserId = 1
+	err = SavePost(db, post)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic, err := FindOneTopic(db, "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic.PostCount != 1 || topic.LastPostId != post.Id || !topic.LastPostAt.Equal(post.Published) {
+		t.Errorf("wrong topic counters %v", topic)
+	}
+	if topic.Forum.PostCount != 13 || topic.Forum.LastPostId != post.Id {
+		t.Errorf("wrong forum counters %v", topic.Forum)
+	}
+
+	newTopic := NewTopic()
+	newTopic.Title = "counted"
+	newTopic.ForumId = 1
+	err = SaveTopic(db, newTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forum, err := FindOneForum(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forum.TopicCount != 4 {
+		t.Errorf("wrong topic count %d", forum.TopicCount)
+	}
+}
+
+func TestDeletePostCounters(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// post 30 is the last post of topic 4 and forum 1
+	err = DeletePost(db, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic, err := FindOneTopic(db, "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic.PostCount != 0 || topic.LastPostId != -1 || !topic.LastPostAt.IsZero() {
+		t.Errorf("wrong topic counters %v", topic)
+	}
+	if topic.Forum.PostCount != 11 || topic.Forum.LastPostId != 26 {
+		t.Errorf("wrong forum counters %v", topic.Forum)
+	}
+}
+
+func TestRecount(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec("UPDATE topics SET post_count = 99, last_post_id = NULL WHERE id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("UPDATE forums SET topic_count = 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topics, forums, err := Recount(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topics != 1 || forums != 2 {
+		t.Errorf("recount should repair 1 topic and 2 forums, repaired %d and %d", topics, forums)
+	}
+
+	for id, count := range map[int]int{1: 3, 2: 2} {
+		forum, err := FindOneForum(db, strconv.Itoa(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if forum.TopicCount != count {
+			t.Errorf("forum %d should have %d topics, has %d", id, count, forum.TopicCount)
+		}
+	}
+
+	topic, err := FindOneTopic(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic.PostCount != 11 || topic.LastPostId != 26 {
+		t.Errorf("wrong topic counters %v", topic)
+	}
+}
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// NotFoundError is returned when the record asked for does not exist.
+type NotFoundError struct {
+	What string
+}
+
+func (err *NotFoundError) Error() string {
+	return "could not find " + err.What
+}
+
+// ValidationError is returned for input that can not be used, with a
+// message per problem.
+type ValidationError struct {
+	Errs []error
+}
+
+func NewValidationError(errs ...error) *ValidationError {
+	return &ValidationError{errs}
+}
+
+func (err *ValidationError) Error() string {
+	messages := make([]string, 0, len(err.Errs))
+	for _, e := range err.Errs {
+		messages = append(messages, e.Error())
+	}
+	return strings.Join(messages, " ")
+}
+
+// ForbiddenError is returned when the user may not do what they asked for,
+// Err telling them why.
+type ForbiddenError struct {
+	Err error
+}
+
+func (err *ForbiddenError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *ForbiddenError) Unwrap() error {
+	return err.Err
+}
+
+// FormattedError is a message for the user with values filled into it. The
+// format is kept apart from the values so that the message can be translated
+// before they are.
+type FormattedError struct {
+	Format string
+	Args   []interface{}
+}
+
+func Errorf(format string, args ...interface{}) error {
+	return &FormattedError{format, args}
+}
+
+func (err *FormattedError) Error() string {
+	return fmt.Sprintf(err.Format, err.Args...)
+}
+
+// InternalError wraps a failure that is not the user's doing, like a failed
+// query. Its message is for the log only.
+type InternalError struct {
+	Message string
+	Err     error
+}
+
+func (err *InternalError) Error() string {
+	return err.Message + ": " + err.Err.Error()
+}
+
+func (err *InternalError) Unwrap() error {
+	return err.Err
+}
+
+// queryError classifies the error of a query for what, a missing row being a
+// NotFoundError.
+func queryError(err error, what string) error {
+	if err == sql.ErrNoRows {
+		return &NotFoundError{what}
+	}
+	return &InternalError{"could not query for " + what, err}
+}
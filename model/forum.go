@@ -2,8 +2,7 @@ package model
 
 import (
 	"database/sql"
-	"errors"
-	"strconv"
+	"time"
 )
 
 type Forum struct {
@@ -11,77 +10,72 @@ type Forum struct {
 	Title       string
 	Description string
 
+	// counters kept up to date by SaveTopic, SavePost and DeletePost,
+	// LastPostId is -1 for forums without posts
 	TopicCount int
 	PostCount  int
+	LastPostId int
+	LastPostAt time.Time
 }
 
-func FindOneForum(db *sql.DB, reqId string) (*Forum, error) {
+const selectForums = `SELECT forums.id, forums.title, forums.description,
+		forums.topic_count, forums.post_count, forums.last_post_id, forums.last_post_at
+	FROM forums`
+
+func scanForum(row rowScanner) (Forum, error) {
 	var (
 		id          int
 		title       string
 		description string
+		topicCount  int
+		postCount   int
+		lastPostId  sql.NullInt64
+		lastPostAt  *time.Time
 	)
 
-	row := db.QueryRow("SELECT * FROM forums WHERE id = ?", reqId)
-	err := row.Scan(&id, &title, &description)
-	if err != nil {
-		return nil, errors.New("could not query for forum with id " + reqId)
-	}
-
-	topicCount, postCount, err := topicAndPostCount(db, reqId)
+	err := row.Scan(&id, &title, &description, &topicCount, &postCount, &lastPostId, &lastPostAt)
 	if err != nil {
-		return nil, err
+		return Forum{}, err
 	}
 
-	return &Forum{id, title, description, topicCount, postCount}, nil
+	forum := Forum{id, title, description, topicCount, postCount, -1, time.Time{}}
+	forum.LastPostId, forum.LastPostAt = lastPost(lastPostId, lastPostAt)
+	return forum, nil
 }
 
-func topicAndPostCount(db *sql.DB, reqId string) (int, int, error) {
-	var topicCount int
-	var postCount int
-
-	row := db.QueryRow(`select
-												count(distinct topics.id),
-	 											count(posts.id)
-											from forums
-												left join topics on topics.forum_id = forums.id
-												left join posts on posts.topic_id = topics.id
-											where forums.id = ?`, reqId)
+// lastPost maps the nullable last post columns to the -1 sentinel and zero
+// time used for forums and topics without posts.
+func lastPost(id sql.NullInt64, at *time.Time) (int, time.Time) {
+	if !id.Valid || at == nil {
+		return -1, time.Time{}
+	}
+	return int(id.Int64), *at
+}
 
-	err := row.Scan(&topicCount, &postCount)
+func FindOneForum(db *sql.DB, reqId string) (*Forum, error) {
+	forum, err := scanForum(db.QueryRow(selectForums+" WHERE forums.id = ?", reqId))
 	if err != nil {
-		return 0, 0, err
+		return nil, queryError(err, "forum with id "+reqId)
 	}
 
-	return topicCount, postCount, nil
+	return &forum, nil
 }
 
 func FindForums(db *sql.DB) ([]Forum, error) {
-	rows, err := db.Query("SELECT * FROM forums")
-	defer rows.Close()
+	rows, err := db.Query(selectForums + " ORDER BY forums.id")
 	if err != nil {
-		return nil, errors.New("could not query for forums")
+		return nil, &InternalError{"could not query for forums", err}
 	}
+	defer rows.Close()
 
 	forums := make([]Forum, 0)
 	for rows.Next() {
-		var (
-			id          int
-			title       string
-			description string
-		)
-
-		err := rows.Scan(&id, &title, &description)
-		if err != nil {
-			return nil, errors.New("could not process row")
-		}
-
-		topicCount, postCount, err := topicAndPostCount(db, strconv.Itoa(id))
+		forum, err := scanForum(rows)
 		if err != nil {
-			return nil, err
+			return nil, &InternalError{"could not process row", err}
 		}
 
-		forums = append(forums, Forum{id, title, description, topicCount, postCount})
+		forums = append(forums, forum)
 	}
 
 	return forums, nil
@@ -3,6 +3,7 @@ package model
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -14,6 +15,8 @@ func mockForum1() *Forum {
 		Description: "tester forum",
 		TopicCount:  3,
 		PostCount:   12,
+		LastPostId:  30,
+		LastPostAt:  time.Date(2014, 11, 4, 6, 8, 47, 772019858, time.UTC),
 	}
 }
 
@@ -24,6 +27,8 @@ func mockForum2() *Forum {
 		Description: "eine Prüfung",
 		TopicCount:  2,
 		PostCount:   18,
+		LastPostId:  29,
+		LastPostAt:  time.Date(2014, 11, 4, 5, 56, 58, 608376074, time.UTC),
 	}
 }
 
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+const (
+	LinkPreviewOK     = "ok"
+	LinkPreviewFailed = "failed"
+)
+
+// LinkPreview is what a page says about itself through OpenGraph or oEmbed,
+// cached by url. Failed fetches are kept too so they are not retried on every
+// view.
+type LinkPreview struct {
+	URL         string
+	Title       string
+	Description string
+	Image       string
+	Status      string
+	Fetched     time.Time
+}
+
+func NewLinkPreview(url string) *LinkPreview {
+	return &LinkPreview{url, "", "", "", LinkPreviewFailed, time.Now().UTC()}
+}
+
+func SaveLinkPreview(db *sql.DB, preview *LinkPreview) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO link_previews (url, title, description, image, status, fetched) VALUES (?,?,?,?,?,?)",
+		preview.URL, preview.Title, preview.Description, preview.Image, preview.Status, preview.Fetched)
+	return err
+}
+
+// FindLinkPreviews returns the cached previews of the given urls keyed by url.
+func FindLinkPreviews(db *sql.DB, urls []string) (map[string]LinkPreview, error) {
+	previews := make(map[string]LinkPreview)
+	if len(urls) == 0 {
+		return previews, nil
+	}
+
+	args := make([]interface{}, len(urls))
+	for i, url := range urls {
+		args[i] = url
+	}
+
+	rows, err := db.Query("SELECT url, title, description, image, status, fetched FROM link_previews WHERE url IN (?"+strings.Repeat(",?", len(urls)-1)+")", args...)
+	if err != nil {
+		return nil, &InternalError{"could not query for link previews", err}
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var preview LinkPreview
+		err := rows.Scan(&preview.URL, &preview.Title, &preview.Description, &preview.Image, &preview.Status, &preview.Fetched)
+		if err != nil {
+			return nil, err
+		}
+
+		previews[preview.URL] = preview
+	}
+
+	return previews, nil
+}
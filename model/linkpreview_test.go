@@ -0,0 +1,41 @@
+package model
+
+import "testing"
+
+func TestLinkPreviews(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preview := NewLinkPreview("http://example.com/")
+	preview.Title = "Example"
+	preview.Status = LinkPreviewOK
+	err = SaveLinkPreview(db, preview)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previews, err := FindLinkPreviews(db, []string{"http://example.com/", "http://example.org/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(previews) != 1 || previews["http://example.com/"].Title != "Example" {
+		t.Errorf("wrong previews %v", previews)
+	}
+
+	// fetching again replaces the preview
+	preview.Title = "Changed"
+	err = SaveLinkPreview(db, preview)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previews, err = FindLinkPreviews(db, []string{"http://example.com/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previews["http://example.com/"].Title != "Changed" {
+		t.Error("preview should have been replaced")
+	}
+}
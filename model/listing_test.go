@@ -0,0 +1,211 @@
+package model
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// queryCount is the number of statements run through the sqlite3-counting
+// driver.
+var queryCount int64
+
+func init() {
+	sql.Register("sqlite3-counting", countingDriver{&sqlite3.SQLiteDriver{}})
+}
+
+// countingDriver wraps sqlite3, counting every statement. Its connections
+// only implement Prepare, so database/sql prepares each query it runs.
+type countingDriver struct {
+	driver.Driver
+}
+
+func (d countingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{conn}, nil
+}
+
+type countingConn struct {
+	conn driver.Conn
+}
+
+func (c countingConn) Prepare(query string) (driver.Stmt, error) {
+	atomic.AddInt64(&queryCount, 1)
+	return c.conn.Prepare(query)
+}
+
+func (c countingConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c countingConn) Begin() (driver.Tx, error) {
+	return c.conn.Begin()
+}
+
+// countQueries returns the number of statements run by f.
+func countQueries(tb testing.TB, f func() error) int64 {
+	before := atomic.LoadInt64(&queryCount)
+	if err := f(); err != nil {
+		tb.Fatal(err)
+	}
+	return atomic.LoadInt64(&queryCount) - before
+}
+
+// generateDB fills a shared in memory database with the mockup data plus
+// generated forums, topics and posts, counted with Recount. It returns a plain connection that keeps
+// the database alive and a counting one for the code under test.
+func generateDB(tb testing.TB, name string, forums, topicsPerForum, postsPerTopic int) (*sql.DB, *sql.DB) {
+	dsn := "file:" + name + "?mode=memory&cache=shared"
+
+	setup, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := setup.Exec(MockupDB); err != nil {
+		tb.Fatal(err)
+	}
+
+	tx, err := setup.Begin()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	published := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	for f := 0; f < forums; f++ {
+		result, err := tx.Exec("INSERT INTO forums (title, description) VALUES (?,?)", "forum "+strconv.Itoa(f), "generated")
+		if err != nil {
+			tb.Fatal(err)
+		}
+		forumId, _ := result.LastInsertId()
+
+		for t := 0; t < topicsPerForum; t++ {
+			result, err := tx.Exec("INSERT INTO topics (title, description, forum_id) VALUES (?,?,?)", "topic "+strconv.Itoa(t), "generated", forumId)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			topicId, _ := result.LastInsertId()
+
+			for p := 0; p < postsPerTopic; p++ {
+				published = published.Add(time.Minute)
+				_, err := tx.Exec("INSERT INTO posts (text, published, topic_id, user_id) VALUES (?,?,?,?)", "post "+strconv.Itoa(p), published, topicId, 1)
+				if err != nil {
+					tb.Fatal(err)
+				}
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+	if _, _, err := Recount(setup); err != nil {
+		tb.Fatal(err)
+	}
+
+	counting, err := sql.Open("sqlite3-counting", dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return setup, counting
+}
+
+// listings run the queries behind the index, forum and topic pages.
+var listings = map[string]func(db *sql.DB) error{
+	"FindForums": func(db *sql.DB) error {
+		_, err := FindForums(db)
+		return err
+	},
+	"FindOneForum": func(db *sql.DB) error {
+		_, err := FindOneForum(db, "3")
+		return err
+	},
+	"FindTopics": func(db *sql.DB) error {
+		_, err := FindTopics(db, "3", 10, 0)
+		return err
+	},
+	"FindOneTopic": func(db *sql.DB) error {
+		_, err := FindOneTopic(db, "10")
+		return err
+	},
+	"FindPosts": func(db *sql.DB) error {
+		_, err := FindPosts(db, "10", 10, 0)
+		return err
+	},
+}
+
+func TestListingQueryCount(t *testing.T) {
+	small, smallCounting := generateDB(t, "small", 2, 5, 2)
+	defer small.Close()
+	defer smallCounting.Close()
+	large, largeCounting := generateDB(t, "large", 20, 30, 10)
+	defer large.Close()
+	defer largeCounting.Close()
+
+	for name, listing := range listings {
+		smallQueries := countQueries(t, func() error { return listing(smallCounting) })
+		largeQueries := countQueries(t, func() error { return listing(largeCounting) })
+		if smallQueries != 1 || largeQueries != 1 {
+			t.Errorf("%s should run one query, ran %d on the small and %d on the large database", name, smallQueries, largeQueries)
+		}
+	}
+}
+
+func TestListingCounts(t *testing.T) {
+	setup, db := generateDB(t, "counts", 3, 4, 5)
+	defer setup.Close()
+	defer db.Close()
+
+	forums, err := FindForums(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forums) != 5 || forums[2].TopicCount != 4 || forums[2].PostCount != 20 {
+		t.Errorf("wrong forum counts %v", forums)
+	}
+
+	topics, err := FindTopics(db, strconv.Itoa(forums[2].Id), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 4 || topics[0].PostCount != 5 {
+		t.Errorf("wrong topic counts %v", topics)
+	}
+
+	topic, err := FindOneTopic(db, strconv.Itoa(topics[0].Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic.PostCount != 5 || topic.Forum.Id != forums[2].Id || topic.Forum.TopicCount != 4 || topic.Forum.PostCount != 20 {
+		t.Errorf("wrong topic %v with forum %v", topic, topic.Forum)
+	}
+}
+
+func benchmarkListing(b *testing.B, name string) {
+	setup, db := generateDB(b, "bench"+name, 20, 100, 20)
+	defer setup.Close()
+	defer db.Close()
+
+	listing := listings[name]
+	b.ResetTimer()
+	queries := countQueries(b, func() error {
+		for i := 0; i < b.N; i++ {
+			if err := listing(db); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	b.ReportMetric(float64(queries)/float64(b.N), "queries/op")
+}
+
+func BenchmarkFindForums(b *testing.B)   { benchmarkListing(b, "FindForums") }
+func BenchmarkFindOneForum(b *testing.B) { benchmarkListing(b, "FindOneForum") }
+func BenchmarkFindTopics(b *testing.B)   { benchmarkListing(b, "FindTopics") }
+func BenchmarkFindOneTopic(b *testing.B) { benchmarkListing(b, "FindOneTopic") }
+func BenchmarkFindPosts(b *testing.B)    { benchmarkListing(b, "FindPosts") }
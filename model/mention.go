@@ -0,0 +1,103 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+)
+
+// a mention starts a line or follows whitespace or punctuation, never a
+// character of a url or mail address such as the / of medium.com/@alice
+var mentionPattern = regexp.MustCompile(`(^|[\s(\[{"'*!?,;])@([\w.\-]*\w)`)
+
+// markdownLink matches inline links and images, whose text and destination
+// are left alone.
+var markdownLink = regexp.MustCompile(`!?\[[^\]]*\]\([^)]*\)`)
+
+// linkDefinition matches the reference definitions of links, [id]: url.
+var linkDefinition = regexp.MustCompile(`^ {0,3}\[[^\]]+\]:`)
+
+// ReplaceMentions calls replace for every @username in markdown text and
+// substitutes the result. Mentions in code spans, fenced and indented code
+// blocks and links are left alone.
+func ReplaceMentions(text string, replace func(username string) string) string {
+	lines := strings.Split(text, "\n")
+	fenced := false
+	// an indented line is code after a blank line or more code, otherwise it
+	// continues a paragraph
+	blockStart := true
+	indented := false
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+			blockStart, indented = false, false
+			continue
+		}
+		if fenced {
+			continue
+		}
+		if trimmed == "" {
+			blockStart = true
+			continue
+		}
+		indented = (blockStart || indented) && (strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t"))
+		blockStart = false
+		if indented || linkDefinition.MatchString(line) {
+			continue
+		}
+
+		// odd segments between backticks are inline code
+		segments := strings.Split(line, "`")
+		for j := 0; j < len(segments); j += 2 {
+			segments[j] = replaceMentionsOutsideLinks(segments[j], replace)
+		}
+		lines[i] = strings.Join(segments, "`")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// replaceMentionsOutsideLinks replaces the mentions of text that are not part
+// of a link's text or destination.
+func replaceMentionsOutsideLinks(text string, replace func(username string) string) string {
+	links := markdownLink.FindAllStringIndex(text, -1)
+	inLink := func(i int) bool {
+		for _, link := range links {
+			if i >= link[0] && i < link[1] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var replaced strings.Builder
+	last := 0
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		// match[4] is where the username starts, after the @
+		if inLink(match[4]) {
+			continue
+		}
+		replaced.WriteString(text[last : match[4]-1])
+		replaced.WriteString(replace(text[match[4]:match[5]]))
+		last = match[5]
+	}
+	replaced.WriteString(text[last:])
+	return replaced.String()
+}
+
+// FindMentions returns the distinct usernames mentioned in markdown text.
+func FindMentions(text string) []string {
+	usernames := make([]string, 0)
+	seen := make(map[string]bool)
+
+	ReplaceMentions(text, func(username string) string {
+		if !seen[username] {
+			seen[username] = true
+			usernames = append(usernames, username)
+		}
+		return username
+	})
+
+	return usernames
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindMentions(t *testing.T) {
+	text := "@test hello, @tester and @test again.\nmail me at test@test.com"
+	if !reflect.DeepEqual(FindMentions(text), []string{"test", "tester"}) {
+		t.Error("wrong mentions")
+	}
+}
+
+func TestFindMentionsSkipsCode(t *testing.T) {
+	text := "`@inline` @real\n```\n@fenced\n```\n@after"
+	if !reflect.DeepEqual(FindMentions(text), []string{"real", "after"}) {
+		t.Error("mentions in code should be skipped")
+	}
+}
+
+func TestReplaceMentions(t *testing.T) {
+	replaced := ReplaceMentions("(@test) @tester.", func(username string) string {
+		return "<" + username + ">"
+	})
+
+	if replaced != "(<test>) <tester>." {
+		t.Errorf("wrong replacement: %s", replaced)
+	}
+}
+
+func TestFindMentionsSkipsURLs(t *testing.T) {
+	text := "read https://medium.com/@alice/post by @carol: [this](https://example.com/@bob/y), [@dave](/user/profile/dave) and <https://example.com/@erin>\n\n[ref]: @frank"
+	if mentions := FindMentions(text); !reflect.DeepEqual(mentions, []string{"carol"}) {
+		t.Errorf("mentions in urls and links should be skipped, got %v", mentions)
+	}
+}
+
+func TestFindMentionsSkipsIndentedCode(t *testing.T) {
+	text := "@first\n    @continued\n\n    @code\n\tmore @code\n\n@last"
+	if mentions := FindMentions(text); !reflect.DeepEqual(mentions, []string{"first", "continued", "last"}) {
+		t.Errorf("mentions in indented code should be skipped, got %v", mentions)
+	}
+}
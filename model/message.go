@@ -0,0 +1,369 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Conversation struct {
+	Id        int
+	Subject   string
+	CreatorId int
+	Created   time.Time
+
+	// relations
+	Participants []User
+	LastMessage  *Message
+	UnreadCount  int
+}
+
+type Message struct {
+	Id             int
+	ConversationId int
+	UserId         int
+	Text           string
+	Published      time.Time
+
+	// relations
+	User *User
+}
+
+func NewConversation() *Conversation {
+	return &Conversation{-1, "", -1, time.Now().UTC(), []User{}, nil, 0}
+}
+
+func NewMessage() *Message {
+	return &Message{-1, -1, -1, "", time.Now().UTC(), nil}
+}
+
+func (conversation *Conversation) HasParticipant(userId int) bool {
+	for _, participant := range conversation.Participants {
+		if participant.Id == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// blockedBy returns an error for every participant that blocked the sender.
+func blockedBy(db *sql.DB, participants []User, senderId int) []error {
+	errs := make([]error, 0)
+	for _, participant := range participants {
+		if participant.Id != senderId && HasBlocked(db, participant.Id, senderId) {
+			errs = append(errs, Errorf("%s is not accepting messages from you.", participant.Username))
+		}
+	}
+	return errs
+}
+
+func ValidateConversation(db *sql.DB, conversation *Conversation) (ok bool, errs []error) {
+	errs = make([]error, 0)
+
+	trimmedSubject := strings.TrimSpace(conversation.Subject)
+
+	if trimmedSubject == "" {
+		errs = append(errs, errors.New("Conversation must have a subject."))
+	}
+
+	if len(trimmedSubject) > 255 {
+		errs = append(errs, errors.New("Conversation subject is too long."))
+	}
+
+	if _, err := FindOneUserById(db, conversation.CreatorId); conversation.CreatorId == -1 || err != nil {
+		errs = append(errs, errors.New("Conversation must be started by a valid user."))
+	}
+
+	recipients := 0
+	for _, participant := range conversation.Participants {
+		if participant.Id != conversation.CreatorId {
+			recipients++
+		}
+	}
+	if recipients == 0 {
+		errs = append(errs, errors.New("Conversation must have at least one recipient."))
+	}
+
+	errs = append(errs, blockedBy(db, conversation.Participants, conversation.CreatorId)...)
+
+	return len(errs) == 0, errs
+}
+
+// ValidateMessage checks a reply to an existing conversation.
+func ValidateMessage(db *sql.DB, conversation *Conversation, message *Message) (ok bool, errs []error) {
+	errs = validateMessageText(message)
+
+	if !conversation.HasParticipant(message.UserId) {
+		errs = append(errs, errors.New("Message must be sent by a participant."))
+	}
+
+	errs = append(errs, blockedBy(db, conversation.Participants, message.UserId)...)
+
+	return len(errs) == 0, errs
+}
+
+// ValidateFirstMessage validates the message a conversation is started with,
+// before the conversation is saved. Its sender is checked by
+// ValidateConversation.
+func ValidateFirstMessage(message *Message) (ok bool, errs []error) {
+	errs = validateMessageText(message)
+	return len(errs) == 0, errs
+}
+
+// validateMessageText checks what a message needs wherever it is sent.
+func validateMessageText(message *Message) []error {
+	errs := make([]error, 0)
+
+	if strings.TrimSpace(message.Text) == "" {
+		errs = append(errs, errors.New("Message must have some text."))
+	}
+
+	return errs
+}
+
+// SaveConversation stores a new conversation with its participants and first
+// message.
+func SaveConversation(db *sql.DB, conversation *Conversation, message *Message) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("INSERT INTO conversations (id, subject, creator_id, created) VALUES (NULL,?,?,?)",
+		strings.TrimSpace(conversation.Subject), conversation.CreatorId, conversation.Created)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	conversation.Id = int(id)
+
+	for _, participant := range conversation.Participants {
+		_, err = tx.Exec("INSERT OR IGNORE INTO conversation_participants (conversation_id, user_id, last_read_message_id) VALUES (?,?,0)", conversation.Id, participant.Id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	message.ConversationId = conversation.Id
+	err = saveMessage(tx, message)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func SaveMessage(db *sql.DB, message *Message) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = saveMessage(tx, message)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func saveMessage(tx *sql.Tx, message *Message) error {
+	result, err := tx.Exec("INSERT INTO messages (id, conversation_id, user_id, text, published) VALUES (NULL,?,?,?,?)",
+		message.ConversationId, message.UserId, message.Text, message.Published)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	message.Id = int(id)
+
+	// senders have read their own messages
+	_, err = tx.Exec("UPDATE conversation_participants SET last_read_message_id = ? WHERE conversation_id = ? AND user_id = ?",
+		message.Id, message.ConversationId, message.UserId)
+	return err
+}
+
+func findParticipants(db *sql.DB, conversationId int) ([]User, error) {
+	rows, err := db.Query(`SELECT users.id, users.username FROM conversation_participants
+		JOIN users ON conversation_participants.user_id = users.id
+		WHERE conversation_participants.conversation_id = ? ORDER BY users.username`, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	participants := make([]User, 0)
+	for rows.Next() {
+		var (
+			id       int
+			username string
+		)
+
+		err := rows.Scan(&id, &username)
+		if err != nil {
+			return nil, err
+		}
+
+		participants = append(participants, User{id, username, "", []byte{}, []byte{}})
+	}
+
+	return participants, nil
+}
+
+func FindOneConversation(db *sql.DB, reqId string) (*Conversation, error) {
+	var (
+		id        int
+		subject   string
+		creatorId int
+		created   time.Time
+	)
+
+	row := db.QueryRow("SELECT id, subject, creator_id, created FROM conversations WHERE id = ?", reqId)
+	err := row.Scan(&id, &subject, &creatorId, &created)
+	if err != nil {
+		return nil, queryError(err, "conversation with id "+reqId)
+	}
+
+	participants, err := findParticipants(db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conversation{id, subject, creatorId, created, participants, nil, 0}, nil
+}
+
+func FindMessages(db *sql.DB, conversationId int) ([]Message, error) {
+	rows, err := db.Query(`SELECT messages.id, messages.conversation_id, messages.user_id, messages.text, messages.published, users.username
+		FROM messages JOIN users ON messages.user_id = users.id
+		WHERE messages.conversation_id = ? ORDER BY messages.id ASC`, conversationId)
+	if err != nil {
+		return nil, &InternalError{"could not query for messages for conversation " + strconv.Itoa(conversationId), err}
+	}
+	defer rows.Close()
+
+	messages := make([]Message, 0)
+	for rows.Next() {
+		var (
+			id             int
+			conversationId int
+			userId         int
+			text           string
+			published      time.Time
+			username       string
+		)
+
+		err := rows.Scan(&id, &conversationId, &userId, &text, &published, &username)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, Message{id, conversationId, userId, text, published,
+			&User{userId, username, "", []byte{}, []byte{}}})
+	}
+
+	return messages, nil
+}
+
+// findConversations lists a user's conversations, most recently active first,
+// keeping only those with at least one message matching senderFilter.
+func findConversations(db *sql.DB, userId int, senderFilter string) ([]Conversation, error) {
+	rows, err := db.Query(`SELECT conversations.id, conversations.subject, conversations.creator_id, conversations.created,
+			last.id, last.user_id, last.text, last.published, last_users.username,
+			(SELECT count(*) FROM messages unread
+				WHERE unread.conversation_id = conversations.id
+					AND unread.id > conversation_participants.last_read_message_id
+					AND unread.user_id != conversation_participants.user_id)
+		FROM conversations
+			JOIN conversation_participants ON conversation_participants.conversation_id = conversations.id
+			JOIN messages last ON last.id = (SELECT max(id) FROM messages WHERE conversation_id = conversations.id)
+			JOIN users last_users ON last.user_id = last_users.id
+		WHERE conversation_participants.user_id = ?
+			AND EXISTS (SELECT 1 FROM messages sent WHERE sent.conversation_id = conversations.id AND sent.user_id `+senderFilter+` ?)
+		ORDER BY last.id DESC`, userId, userId)
+	if err != nil {
+		return nil, &InternalError{"could not query for conversations for user " + strconv.Itoa(userId), err}
+	}
+	defer rows.Close()
+
+	conversations := make([]Conversation, 0)
+	for rows.Next() {
+		var (
+			id           int
+			subject      string
+			creatorId    int
+			created      time.Time
+			lastId       int
+			lastUserId   int
+			lastText     string
+			lastPosted   time.Time
+			lastUsername string
+			unreadCount  int
+		)
+
+		err := rows.Scan(&id, &subject, &creatorId, &created, &lastId, &lastUserId, &lastText, &lastPosted, &lastUsername, &unreadCount)
+		if err != nil {
+			return nil, err
+		}
+
+		lastMessage := &Message{lastId, id, lastUserId, lastText, lastPosted,
+			&User{lastUserId, lastUsername, "", []byte{}, []byte{}}}
+		conversations = append(conversations, Conversation{id, subject, creatorId, created, nil, lastMessage, unreadCount})
+	}
+	rows.Close()
+
+	for i := range conversations {
+		conversations[i].Participants, err = findParticipants(db, conversations[i].Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return conversations, nil
+}
+
+// FindInbox returns the conversations in which someone else sent the user a message.
+func FindInbox(db *sql.DB, userId int) ([]Conversation, error) {
+	return findConversations(db, userId, "!=")
+}
+
+// FindOutbox returns the conversations in which the user sent a message.
+func FindOutbox(db *sql.DB, userId int) ([]Conversation, error) {
+	return findConversations(db, userId, "=")
+}
+
+func MarkConversationRead(db *sql.DB, conversationId int, userId int) error {
+	_, err := db.Exec(`UPDATE conversation_participants
+		SET last_read_message_id = (SELECT ifnull(max(id), 0) FROM messages WHERE conversation_id = ?)
+		WHERE conversation_id = ? AND user_id = ?`, conversationId, conversationId, userId)
+	return err
+}
+
+func CountUnreadMessages(db *sql.DB, userId int) (int, error) {
+	var count int
+
+	row := db.QueryRow(`SELECT count(*) FROM messages
+		JOIN conversation_participants ON conversation_participants.conversation_id = messages.conversation_id
+		WHERE conversation_participants.user_id = ?
+			AND messages.id > conversation_participants.last_read_message_id
+			AND messages.user_id != ?`, userId, userId)
+	err := row.Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
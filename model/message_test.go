@@ -0,0 +1,241 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestEmptyConversation(t *testing.T) {
+	conversation := NewConversation()
+	if conversation.Id != -1 || conversation.Subject != "" || conversation.CreatorId != -1 || len(conversation.Participants) != 0 {
+		t.Error("conversation not empty")
+	}
+
+	message := NewMessage()
+	if message.Id != -1 || message.ConversationId != -1 || message.UserId != -1 || message.Text != "" {
+		t.Error("message not empty")
+	}
+}
+
+func TestValidateConversation(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conversation := NewConversation()
+	ok, errs := ValidateConversation(db, conversation)
+	if ok || len(errs) != 3 {
+		t.Error("blank conversation should not validate")
+	}
+
+	test := *mockUserTest()
+	tester := *mockUserTester()
+
+	conversation.Subject = "Hi"
+	conversation.CreatorId = 1
+	conversation.Participants = []User{test}
+	ok, errs = ValidateConversation(db, conversation)
+	if ok || len(errs) != 1 {
+		t.Error("still missing a recipient")
+	}
+
+	conversation.Participants = []User{test, tester}
+	ok, errs = ValidateConversation(db, conversation)
+	if !ok || len(errs) != 0 {
+		t.Error("conversation should now validate")
+	}
+
+	err = BlockUser(db, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, errs = ValidateConversation(db, conversation)
+	if ok || len(errs) != 1 {
+		t.Error("blocked users cannot start conversations")
+	}
+}
+
+func TestValidateMessage(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conversation, err := FindOneConversation(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := NewMessage()
+	message.ConversationId = 1
+	ok, errs := ValidateMessage(db, conversation, message)
+	if ok || len(errs) != 2 {
+		t.Error("blank message should not validate")
+	}
+
+	message.UserId = 2
+	message.Text = "reply"
+	ok, errs = ValidateMessage(db, conversation, message)
+	if !ok || len(errs) != 0 {
+		t.Error("message should now validate")
+	}
+
+	err = BlockUser(db, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, errs = ValidateMessage(db, conversation, message)
+	if ok || len(errs) != 1 {
+		t.Error("blocked users cannot reply")
+	}
+}
+
+func TestValidateFirstMessage(t *testing.T) {
+	message := NewMessage()
+	ok, errs := ValidateFirstMessage(message)
+	if ok || len(errs) != 1 {
+		t.Error("blank message should not validate")
+	}
+
+	message.Text = "hi"
+	ok, errs = ValidateFirstMessage(message)
+	if !ok || len(errs) != 0 {
+		t.Error("message should now validate")
+	}
+}
+
+func TestFindOneConversation(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conversation, err := FindOneConversation(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conversation.Subject != "hello" || conversation.CreatorId != 1 {
+		t.Error("wrong conversation")
+	}
+
+	if len(conversation.Participants) != 2 || !conversation.HasParticipant(1) || !conversation.HasParticipant(2) {
+		t.Error("wrong participants")
+	}
+
+	messages, err := FindMessages(db, conversation.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 3 || messages[1].User.Username != "tester" {
+		t.Error("wrong messages")
+	}
+}
+
+func TestSaveConversation(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conversation := NewConversation()
+	conversation.Subject = "new"
+	conversation.CreatorId = 2
+	conversation.Participants = []User{*mockUserTester(), *mockUserTest()}
+
+	message := NewMessage()
+	message.UserId = 2
+	message.Text = "first"
+
+	err = SaveConversation(db, conversation, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conversation.Id != 2 || message.ConversationId != 2 {
+		t.Error("ids not set")
+	}
+
+	inbox, err := FindInbox(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inbox) != 2 || inbox[0].Id != 2 || inbox[0].UnreadCount != 1 || inbox[0].LastMessage.Text != "first" {
+		t.Error("new conversation should be first in the inbox")
+	}
+
+	outbox, err := FindOutbox(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(outbox) != 2 || outbox[0].UnreadCount != 0 {
+		t.Error("sent conversation should be in the outbox")
+	}
+}
+
+func TestUnreadMessages(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := CountUnreadMessages(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 1 {
+		t.Error("wrong unread count")
+	}
+
+	message := NewMessage()
+	message.ConversationId = 1
+	message.UserId = 1
+	message.Text = "still there?"
+	err = SaveMessage(db, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err = CountUnreadMessages(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 2 {
+		t.Error("new message should be unread")
+	}
+
+	count, err = CountUnreadMessages(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Error("own messages are read")
+	}
+
+	err = MarkConversationRead(db, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err = CountUnreadMessages(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Error("conversation should be read")
+	}
+}
@@ -0,0 +1,209 @@
+package model
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+const (
+	NotificationReply      = "reply"
+	NotificationMention    = "mention"
+	NotificationQuote      = "quote"
+	NotificationModeration = "moderation"
+)
+
+// notificationPriority decides which kind of notification a user gets when a
+// single post concerns them in several ways.
+var notificationPriority = map[string]int{
+	NotificationReply:   1,
+	NotificationMention: 2,
+	NotificationQuote:   3,
+}
+
+// Notification tells a user about a post, or about a post of theirs a
+// moderator removed, which has no PostId but only the TopicId it was in.
+type Notification struct {
+	Id      int
+	UserId  int
+	Kind    string
+	PostId  int
+	TopicId int
+	ActorId int
+	Created time.Time
+	Read    bool
+
+	// relations
+	Actor *User
+	Topic *Topic
+}
+
+func NewNotification() *Notification {
+	return &Notification{-1, -1, "", -1, -1, -1, time.Now().UTC(), false, nil, nil}
+}
+
+func SaveNotification(db execer, notification *Notification) error {
+	_, err := db.Exec("INSERT INTO notifications (id, user_id, kind, post_id, topic_id, actor_id, created, read) VALUES (NULL,?,?,?,?,?,?,?)",
+		notification.UserId, notification.Kind, nullableId(notification.PostId), nullableId(notification.TopicId), notification.ActorId, notification.Created, notification.Read)
+	return err
+}
+
+// notifyPost notifies the author of the topic, the author of the post being
+// replied to and every mentioned user about a new post. Each user gets at most
+// one notification per post, and authors are never notified of their own posts.
+// db is the transaction saving the post.
+func notifyPost(db querier, post *Post) error {
+	recipients := make(map[int]string)
+	addRecipient := func(userId int, kind string) {
+		if userId == post.UserId {
+			return
+		}
+		if notificationPriority[kind] > notificationPriority[recipients[userId]] {
+			recipients[userId] = kind
+		}
+	}
+
+	var topicStarterId int
+	row := db.QueryRow("SELECT user_id FROM posts WHERE topic_id = ? ORDER BY datetime(published) ASC, id ASC LIMIT 1", post.TopicId)
+	if err := row.Scan(&topicStarterId); err == nil {
+		addRecipient(topicStarterId, NotificationReply)
+	}
+
+	for _, username := range FindMentions(post.Text) {
+		var userId int
+		if err := db.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userId); err == nil {
+			addRecipient(userId, NotificationMention)
+		}
+	}
+
+	if post.ReplyTo != -1 {
+		var parentUserId int
+		if err := db.QueryRow("SELECT user_id FROM posts WHERE id = ?", post.ReplyTo).Scan(&parentUserId); err == nil {
+			addRecipient(parentUserId, NotificationQuote)
+		}
+	}
+
+	for userId, kind := range recipients {
+		notification := NewNotification()
+		notification.UserId = userId
+		notification.Kind = kind
+		notification.PostId = post.Id
+		notification.ActorId = post.UserId
+		notification.Created = post.Published
+
+		err := SaveNotification(db, notification)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// notificationTopics joins the topic of a notification, the one of its post
+// or the one a removed post was in.
+const notificationTopics = `LEFT JOIN posts ON notifications.post_id = posts.id
+		JOIN topics ON topics.id = ifnull(posts.topic_id, notifications.topic_id)`
+
+const selectNotifications = `SELECT notifications.id, notifications.user_id, notifications.kind, ifnull(notifications.post_id, -1),
+		notifications.actor_id, notifications.created, notifications.read,
+		actors.username, topics.id, topics.title
+	FROM notifications
+		JOIN users actors ON notifications.actor_id = actors.id
+		` + notificationTopics
+
+func scanNotification(row rowScanner) (Notification, error) {
+	var (
+		id            int
+		userId        int
+		kind          string
+		postId        int
+		actorId       int
+		created       time.Time
+		read          bool
+		actorUsername string
+		topicId       int
+		topicTitle    string
+	)
+
+	err := row.Scan(&id, &userId, &kind, &postId, &actorId, &created, &read, &actorUsername, &topicId, &topicTitle)
+	if err != nil {
+		return Notification{}, err
+	}
+
+	return Notification{id, userId, kind, postId, topicId, actorId, created, read,
+		&User{actorId, actorUsername, "", []byte{}, []byte{}},
+		&Topic{topicId, topicTitle, "", -1, -1, -1, time.Time{}, nil}}, nil
+}
+
+func FindOneNotification(db *sql.DB, reqId string) (Notification, error) {
+	notification, err := scanNotification(db.QueryRow(selectNotifications+" WHERE notifications.id = ?", reqId))
+	if err != nil {
+		return Notification{}, queryError(err, "notification with id "+reqId)
+	}
+
+	return notification, nil
+}
+
+func FindUnreadNotifications(db *sql.DB, userId int, limit int) ([]Notification, error) {
+	return findNotifications(db, " AND NOT notifications.read", userId, limit, 0)
+}
+
+func FindNotifications(db *sql.DB, userId int, limit int, offset int) ([]Notification, error) {
+	return findNotifications(db, "", userId, limit, offset)
+}
+
+func findNotifications(db *sql.DB, filter string, userId int, limit int, offset int) ([]Notification, error) {
+	rows, err := db.Query(selectNotifications+" WHERE notifications.user_id = ?"+filter+" ORDER BY datetime(notifications.created) DESC, notifications.id DESC LIMIT ? OFFSET ?", userId, limit, offset)
+	if err != nil {
+		return nil, &InternalError{"could not query for notifications for user " + strconv.Itoa(userId), err}
+	}
+	defer rows.Close()
+
+	notifications := make([]Notification, 0)
+	for rows.Next() {
+		notification, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		notifications = append(notifications, notification)
+	}
+
+	return notifications, nil
+}
+
+func CountUnreadNotifications(db *sql.DB, userId int) (int, error) {
+	var count int
+
+	row := db.QueryRow("SELECT count(*) FROM notifications "+notificationTopics+" WHERE notifications.user_id = ? AND NOT notifications.read", userId)
+	err := row.Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func MarkNotificationRead(db *sql.DB, reqId int) error {
+	_, err := db.Exec("UPDATE notifications SET read = 1 WHERE id = ?", reqId)
+	return err
+}
+
+// MarkNotificationsRead marks the given notifications of a user as read,
+// ignoring ids that belong to other users.
+func MarkNotificationsRead(db *sql.DB, userId int, reqIds []int) error {
+	for _, reqId := range reqIds {
+		_, err := db.Exec("UPDATE notifications SET read = 1 WHERE id = ? AND user_id = ?", reqId, userId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func MarkAllNotificationsRead(db *sql.DB, userId int) error {
+	_, err := db.Exec("UPDATE notifications SET read = 1 WHERE user_id = ?", userId)
+	return err
+}
@@ -0,0 +1,266 @@
+package model
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEmptyNotification(t *testing.T) {
+	notification := NewNotification()
+	if notification.Id != -1 || notification.UserId != -1 || notification.PostId != -1 || notification.Read {
+		t.Error("notification not empty")
+	}
+}
+
+func TestFindUnreadNotifications(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifications, err := FindUnreadNotifications(db, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(notifications) != 1 {
+		t.Fatal("wrong number of notifications")
+	}
+
+	notification := notifications[0]
+	if notification.Kind != NotificationMention || notification.PostId != 30 {
+		t.Error("wrong notification")
+	}
+
+	if notification.Actor == nil || notification.Actor.Username != "test" {
+		t.Error("no actor relation")
+	}
+
+	if notification.Topic == nil || notification.Topic.Id != 4 {
+		t.Error("no topic relation")
+	}
+}
+
+func TestMarkNotificationRead(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = MarkNotificationRead(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notification, err := FindOneNotification(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !notification.Read {
+		t.Error("notification should be read")
+	}
+
+	notifications, err := FindUnreadNotifications(db, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(notifications) != 0 {
+		t.Error("no notifications should be unread")
+	}
+}
+
+func TestSavePostNotifiesMentions(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := &Post{-1, "@tester @test @nobody look", time.Now().UTC(), 1, 1, -1, -1, nil, nil}
+	err = SavePost(db, post)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifications, err := FindUnreadNotifications(db, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(notifications) != 2 || notifications[0].PostId != post.Id {
+		t.Fatal("mentioned user should be notified")
+	}
+	mentionId := notifications[0].Id
+
+	notifications, err = FindUnreadNotifications(db, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(notifications) != 0 {
+		t.Error("author should not be notified of their own mention")
+	}
+
+	err = DeletePost(db, post.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := FindOneNotification(db, strconv.Itoa(mentionId)); err == nil {
+		t.Error("notification should be deleted with its post")
+	}
+}
+
+func TestSavePostNotifiesTopicStarter(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := &Post{-1, "a reply", time.Now().UTC(), 4, 2, -1, -1, nil, nil}
+	err = SavePost(db, post)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quote := &Post{-1, "> a quote", time.Now().UTC(), 4, 2, 30, -1, nil, nil}
+	err = SavePost(db, quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifications, err := FindUnreadNotifications(db, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(notifications) != 2 {
+		t.Fatal("topic starter should be notified once per post")
+	}
+
+	if notifications[0].Kind != NotificationQuote || notifications[0].PostId != quote.Id {
+		t.Error("quoting a post should notify its author")
+	}
+
+	if notifications[1].Kind != NotificationReply || notifications[1].PostId != post.Id {
+		t.Error("replying to a topic should notify its starter")
+	}
+}
+
+func TestModeratePostNotifiesAuthor(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// post 30 of topic 4 is by user 1
+	err = ModeratePost(db, 30, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifications, err := FindUnreadNotifications(db, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 1 {
+		t.Fatalf("the author should be notified once, got %d notifications", len(notifications))
+	}
+	if n := notifications[0]; n.Kind != NotificationModeration || n.PostId != -1 || n.TopicId != 4 || n.Topic.Id != 4 || n.ActorId != 2 {
+		t.Errorf("wrong moderation notification %+v", n)
+	}
+	if count, err := CountUnreadNotifications(db, 1); err != nil || count != 1 {
+		t.Errorf("moderation notifications should count as unread, got %d, %v", count, err)
+	}
+
+	err = ModeratePost(db, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := CountUnreadNotifications(db, 1); count != 1 {
+		t.Error("deleting one's own post should not notify")
+	}
+}
+
+func TestMarkNotificationsRead(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := CountUnreadNotifications(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 1 {
+		t.Error("wrong unread count")
+	}
+
+	err = MarkNotificationsRead(db, 1, []int{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err = CountUnreadNotifications(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 1 {
+		t.Error("should not mark another user's notifications")
+	}
+
+	err = MarkNotificationsRead(db, 2, []int{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err = CountUnreadNotifications(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Error("notification should be read")
+	}
+
+	notifications, err := FindNotifications(db, 2, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(notifications) != 1 || !notifications[0].Read {
+		t.Error("read notifications should still be listed")
+	}
+}
+
+func TestMarkAllNotificationsRead(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = MarkAllNotificationsRead(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := CountUnreadNotifications(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Error("all notifications should be read")
+	}
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -14,87 +15,452 @@ type Post struct {
 	Published time.Time
 	TopicId   int
 	UserId    int
+	ReplyTo   int
+	// ForumId is read from the post's topic, it picks the forum's sanitizer
+	// policy when rendering.
+	ForumId int
 
 	// relations
-	User *User
+	User   *User
+	Parent *Post
 }
 
 func NewPost() *Post {
-	return &Post{-1, "", time.Now().UTC(), -1, -1, nil}
+	return &Post{-1, "", time.Now().UTC(), -1, -1, -1, -1, nil, nil}
 }
 
 func ValidatePost(db *sql.DB, post *Post) (ok bool, errs []error) {
-	errs = make([]error, 0)
-
-	if strings.TrimSpace(post.Text) == "" {
-		errs = append(errs, errors.New("Post must have some text."))
-	}
+	errs = validatePostText(db, post)
 
 	if _, err := FindOneTopic(db, strconv.Itoa(post.TopicId)); post.TopicId == -1 || err != nil {
 		errs = append(errs, errors.New("Post must belong to a valid topic."))
 	}
 
+	if post.ReplyTo != -1 {
+		parent, err := FindOnePost(db, strconv.Itoa(post.ReplyTo))
+		if err != nil || parent.TopicId != post.TopicId {
+			errs = append(errs, errors.New("Post can only reply to a post in the same topic."))
+		}
+	}
+
+	return len(errs) == 0, errs
+}
+
+// ValidateFirstPost validates the post a topic is started with, before the
+// topic is saved.
+func ValidateFirstPost(db *sql.DB, post *Post) (ok bool, errs []error) {
+	errs = validatePostText(db, post)
+	return len(errs) == 0, errs
+}
+
+// validatePostText checks what a post needs wherever it is posted, its text
+// and author.
+func validatePostText(db *sql.DB, post *Post) []error {
+	errs := make([]error, 0)
+
+	if strings.TrimSpace(post.Text) == "" {
+		errs = append(errs, errors.New("Post must have some text."))
+	}
+
 	if _, err := FindOneUserById(db, post.UserId); post.UserId == -1 || err != nil {
 		errs = append(errs, errors.New("Post must belong to a valid user."))
 	}
 
-	return len(errs) == 0, errs
+	return errs
 }
 
+// nullableId maps the -1 sentinel used for unset ids to NULL.
+func nullableId(id int) interface{} {
+	if id == -1 {
+		return nil
+	}
+	return id
+}
+
+// SavePost inserts a post, counting it in its topic and forum, notifying the
+// users it concerns and subscribing its author.
 func SavePost(db *sql.DB, post *Post) error {
-	_, err := db.Exec("INSERT INTO posts (id, text, published, topic_id, user_id) VALUES (NULL,?,?,?,?)", post.Text, post.Published, post.TopicId, post.UserId)
-	return err
+	return SavePostWithAttachments(db, post, nil)
+}
+
+// SavePostWithAttachments stores a post together with the attachments
+// uploaded with it, whose files are already in storage.
+func SavePostWithAttachments(db *sql.DB, post *Post, attachments []*Attachment) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = insertPost(tx, post)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, attachment := range attachments {
+		attachment.PostId = post.Id
+		err = SaveAttachment(tx, attachment)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// insertPost inserts a post, counts it in its topic and forum, notifies the
+// users it concerns and subscribes its author.
+func insertPost(tx *sql.Tx, post *Post) error {
+	result, err := tx.Exec("INSERT INTO posts (id, text, published, topic_id, user_id, reply_to) VALUES (NULL,?,?,?,?,?)", post.Text, post.Published, post.TopicId, post.UserId, nullableId(post.ReplyTo))
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	post.Id = int(id)
+
+	err = countPost(tx, post)
+	if err != nil {
+		return err
+	}
+
+	err = notifyPost(tx, post)
+	if err != nil {
+		return err
+	}
+
+	// posting in a topic follows it
+	return subscribe(tx, post.UserId, "topic_id", post.TopicId, FrequencyImmediate)
 }
 
+// DeletePost deletes a post of its author's.
 func DeletePost(db *sql.DB, reqId int) error {
-	_, err := db.Exec("delete from posts where id=?", reqId)
-	return err
+	return deletePost(db, reqId, -1)
 }
 
-func FindOnePost(db *sql.DB, reqId string) (Post, error) {
+// ModeratePost deletes a post on behalf of a moderator, telling its author
+// in which topic it was removed.
+func ModeratePost(db *sql.DB, reqId int, moderatorId int) error {
+	return deletePost(db, reqId, moderatorId)
+}
+
+func deletePost(db *sql.DB, reqId int, moderatorId int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// replies to the deleted post become top level posts
+	_, err = tx.Exec("update posts set reply_to=NULL where reply_to=?", reqId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("delete from notifications where post_id=?", reqId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// files in storage are removed by the caller
+	err = DeleteAttachments(tx, reqId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = DeleteRenderedPost(tx, reqId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var topicId, authorId int
+	err = tx.QueryRow("select topic_id, user_id from posts where id=?", reqId).Scan(&topicId, &authorId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if moderatorId != -1 && moderatorId != authorId {
+		notification := NewNotification()
+		notification.UserId = authorId
+		notification.Kind = NotificationModeration
+		notification.TopicId = topicId
+		notification.ActorId = moderatorId
+		err = SaveNotification(tx, notification)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec("delete from posts where id=?", reqId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = recountTopics(tx, "id = ?", topicId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = recountForums(tx, "id = (SELECT forum_id FROM topics WHERE id = ?)", topicId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const selectPosts = `SELECT posts.id, posts.text, posts.published, posts.topic_id, posts.user_id, posts.reply_to, topics.forum_id,
+		users.username, parent_users.id, parent_users.username
+	FROM posts
+		JOIN topics ON posts.topic_id = topics.id
+		JOIN users ON posts.user_id = users.id
+		LEFT JOIN posts parents ON posts.reply_to = parents.id
+		LEFT JOIN users parent_users ON parents.user_id = parent_users.id`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPost(row rowScanner) (Post, error) {
 	var (
-		id        int
-		text      string
-		published time.Time
-		topicId   int
-		userId    int
-		username  string
+		id             int
+		text           string
+		published      time.Time
+		topicId        int
+		userId         int
+		replyTo        sql.NullInt64
+		forumId        int
+		username       string
+		parentUserId   sql.NullInt64
+		parentUsername sql.NullString
 	)
 
-	row := db.QueryRow("SELECT posts.*, users.username FROM posts JOIN users ON posts.user_id = users.id WHERE posts.id=?", reqId)
-	err := row.Scan(&id, &text, &published, &topicId, &userId, &username)
+	err := row.Scan(&id, &text, &published, &topicId, &userId, &replyTo, &forumId, &username, &parentUserId, &parentUsername)
 	if err != nil {
 		return Post{}, err
 	}
 
-	return Post{id, text, published, topicId, userId, &User{userId, username, "", []byte{}, []byte{}}}, nil
+	post := Post{id, text, published, topicId, userId, -1, forumId,
+		&User{userId, username, "", []byte{}, []byte{}}, nil}
+
+	if replyTo.Valid {
+		post.ReplyTo = int(replyTo.Int64)
+		if parentUserId.Valid {
+			parentUser := &User{int(parentUserId.Int64), parentUsername.String, "", []byte{}, []byte{}}
+			post.Parent = &Post{post.ReplyTo, "", time.Time{}, topicId, parentUser.Id, -1, forumId, parentUser, nil}
+		}
+	}
+
+	return post, nil
+}
+
+func FindOnePost(db *sql.DB, reqId string) (Post, error) {
+	post, err := scanPost(db.QueryRow(selectPosts+" WHERE posts.id=?", reqId))
+	if err != nil {
+		return Post{}, queryError(err, "post with id "+reqId)
+	}
+
+	return post, nil
 }
 
+// postOrder is the order of posts within a topic. It matches the
+// posts_topic_published index; the keyset conditions below spell out their
+// comparisons, rather than comparing row values, so sqlite can seek it.
+const postOrder = " ORDER BY datetime(posts.published) ASC, posts.id ASC"
+
+// postAnchor selects a column of the post at an offset into a topic, read from
+// the index alone.
+const postAnchor = `(SELECT %s FROM posts anchor WHERE anchor.topic_id = ?
+	ORDER BY datetime(anchor.published), anchor.id LIMIT 1 OFFSET ?)`
+
+// FindPosts returns a page of a topic's posts. Instead of skipping offset
+// posts with OFFSET, the page starts at an anchor looked up on the index.
 func FindPosts(db *sql.DB, reqId string, limit int, offset int) ([]Post, error) {
-	rows, err := db.Query("SELECT posts.*, users.username FROM posts JOIN users ON posts.user_id = users.id WHERE topic_id=? ORDER BY datetime(published) ASC LIMIT ? OFFSET ?", reqId, limit, offset)
+	published := fmt.Sprintf(postAnchor, "datetime(anchor.published)")
+	id := fmt.Sprintf(postAnchor, "anchor.id")
+
+	posts, err := queryPosts(db, selectPosts+` WHERE posts.topic_id = ?
+			AND datetime(posts.published) >= `+published+`
+			AND (datetime(posts.published) > `+published+` OR posts.id >= `+id+`)`+postOrder+" LIMIT ?",
+		reqId, reqId, offset, reqId, offset, reqId, offset, limit)
+	if err != nil {
+		return nil, &InternalError{"could not query for posts for topic " + reqId, err}
+	}
+
+	return posts, nil
+}
+
+// PostCursor is a position between posts of a topic, for keyset pagination.
+type PostCursor struct {
+	Published time.Time
+	Id        int
+}
+
+func (post Post) Cursor() PostCursor {
+	return PostCursor{post.Published, post.Id}
+}
+
+// String encodes the cursor for urls. Posts are ordered by the second they
+// were published, so that is all the cursor keeps.
+func (cursor PostCursor) String() string {
+	return strconv.FormatInt(cursor.Published.Unix(), 10) + "-" + strconv.Itoa(cursor.Id)
+}
+
+func ParsePostCursor(value string) (PostCursor, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 2 {
+		return PostCursor{}, errors.New("invalid cursor " + value)
+	}
+
+	seconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return PostCursor{}, errors.New("invalid cursor " + value)
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return PostCursor{}, errors.New("invalid cursor " + value)
+	}
+
+	return PostCursor{time.Unix(seconds, 0).UTC(), id}, nil
+}
+
+// FindPostsAfter returns up to limit posts of a topic following a cursor, or
+// from the first post if after is nil.
+func FindPostsAfter(db *sql.DB, reqId string, after *PostCursor, limit int) ([]Post, error) {
+	var (
+		posts []Post
+		err   error
+	)
+	if after == nil {
+		posts, err = queryPosts(db, selectPosts+" WHERE posts.topic_id = ?"+postOrder+" LIMIT ?", reqId, limit)
+	} else {
+		posts, err = queryPosts(db, selectPosts+` WHERE posts.topic_id = ?
+				AND datetime(posts.published) >= datetime(?)
+				AND (datetime(posts.published) > datetime(?) OR posts.id > ?)`+postOrder+" LIMIT ?",
+			reqId, after.Published, after.Published, after.Id, limit)
+	}
+	if err != nil {
+		return nil, &InternalError{"could not query for posts for topic " + reqId, err}
+	}
+
+	return posts, nil
+}
+
+// FindPostsBefore returns up to limit posts of a topic preceding a cursor, or
+// up to the last post if before is nil. Posts are still in topic order.
+func FindPostsBefore(db *sql.DB, reqId string, before *PostCursor, limit int) ([]Post, error) {
+	const reverseOrder = " ORDER BY datetime(posts.published) DESC, posts.id DESC"
+
+	var (
+		posts []Post
+		err   error
+	)
+	if before == nil {
+		posts, err = queryPosts(db, selectPosts+" WHERE posts.topic_id = ?"+reverseOrder+" LIMIT ?", reqId, limit)
+	} else {
+		posts, err = queryPosts(db, selectPosts+` WHERE posts.topic_id = ?
+				AND datetime(posts.published) <= datetime(?)
+				AND (datetime(posts.published) < datetime(?) OR posts.id < ?)`+reverseOrder+" LIMIT ?",
+			reqId, before.Published, before.Published, before.Id, limit)
+	}
+	if err != nil {
+		return nil, &InternalError{"could not query for posts for topic " + reqId, err}
+	}
+
+	for i, j := 0, len(posts)-1; i < j; i, j = i+1, j-1 {
+		posts[i], posts[j] = posts[j], posts[i]
+	}
+	return posts, nil
+}
+
+func queryPosts(db *sql.DB, query string, args ...interface{}) ([]Post, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]Post, 0)
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		posts = append(posts, post)
+	}
+
+	return posts, rows.Err()
+}
+
+// FindPostIndex returns the zero based position of a post within its topic,
+// using the same ordering as FindPosts.
+func FindPostIndex(db *sql.DB, reqId string) (int, error) {
+	var index int
+
+	row := db.QueryRow(`select count(*)
+											from posts, (select topic_id, published, id from posts where id = ?) as target
+											where posts.topic_id = target.topic_id
+												and (datetime(posts.published) < datetime(target.published)
+													or (datetime(posts.published) = datetime(target.published) and posts.id < target.id))`, reqId)
+
+	err := row.Scan(&index)
+	if err != nil {
+		return 0, &InternalError{"could not find position of post with id " + reqId, err}
+	}
+
+	return index, nil
+}
+
+func FindPostsByUser(db *sql.DB, userId int, limit int, offset int) ([]Post, error) {
+	rows, err := db.Query(selectPosts+" WHERE posts.user_id=? ORDER BY datetime(posts.published) DESC, posts.id DESC LIMIT ? OFFSET ?", userId, limit, offset)
+	if err != nil {
+		return nil, &InternalError{"could not query for posts for user " + strconv.Itoa(userId), err}
+	}
 	defer rows.Close()
+
+	posts := make([]Post, 0)
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		posts = append(posts, post)
+	}
+
+	return posts, nil
+}
+
+// FindLatestPosts returns the most recently published posts across all
+// topics, newest first.
+func FindLatestPosts(db *sql.DB, limit int) ([]Post, error) {
+	rows, err := db.Query(selectPosts+" ORDER BY datetime(posts.published) DESC, posts.id DESC LIMIT ?", limit)
 	if err != nil {
-		return nil, errors.New("could not query for posts for topic " + reqId)
+		return nil, &InternalError{"could not query for latest posts", err}
 	}
+	defer rows.Close()
 
 	posts := make([]Post, 0)
 	for rows.Next() {
-		var (
-			id        int
-			text      string
-			published time.Time
-			topicId   int
-			userId    int
-			username  string
-		)
-
-		err := rows.Scan(&id, &text, &published, &topicId, &userId, &username)
+		post, err := scanPost(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		posts = append(posts, Post{id, text, published, topicId, userId,
-			&User{userId, username, "", []byte{}, []byte{}}})
+		posts = append(posts, post)
 	}
 
 	return posts, nil
@@ -3,13 +3,14 @@ package model
 import (
 	"math"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
 )
 
 func TestEmptyPost(t *testing.T) {
 	post := NewPost()
-	if !reflect.DeepEqual(post, &Post{-1, "", post.Published, -1, -1, nil}) {
+	if !reflect.DeepEqual(post, &Post{-1, "", post.Published, -1, -1, -1, -1, nil, nil}) {
 		t.Error("post not empty")
 	}
 }
@@ -67,7 +68,7 @@ func TestWhitespacePost(t *testing.T) {
 	}
 
 	whitespace := "\t\n\t\n\t\n    \t\n\t\n\t\n"
-	post := &Post{1, whitespace, time.Now().UTC(), 1, 1, nil}
+	post := &Post{1, whitespace, time.Now().UTC(), 1, 1, -1, -1, nil, nil}
 	ok, errs := ValidatePost(db, post)
 	if ok || len(errs) != 1 {
 		t.Error("whitespace is only invalid item")
@@ -167,3 +168,252 @@ func TestDeletePost(t *testing.T) {
 		t.Error("wrong number of posts")
 	}
 }
+
+func TestValidatePostReplyTo(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := &Post{-1, "reply", time.Now().UTC(), 1, 1, 3, -1, nil, nil}
+	ok, errs := ValidatePost(db, post)
+	if !ok || len(errs) != 0 {
+		t.Error("reply within the topic should validate")
+	}
+
+	post.ReplyTo = 5
+	ok, errs = ValidatePost(db, post)
+	if ok || len(errs) != 1 {
+		t.Error("should not reply to a post in another topic")
+	}
+
+	post.ReplyTo = math.MaxInt32
+	ok, errs = ValidatePost(db, post)
+	if ok || len(errs) != 1 {
+		t.Error("should not reply to a missing post")
+	}
+}
+
+func TestFindOnePostReply(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post, err := FindOnePost(db, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if post.ReplyTo != 1 {
+		t.Error("wrong reply to")
+	}
+
+	if post.Parent == nil || post.Parent.Id != 1 || post.Parent.User.Username != "test" {
+		t.Error("no parent relation")
+	}
+
+	post, err = FindOnePost(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if post.ReplyTo != -1 || post.Parent != nil {
+		t.Error("post is not a reply")
+	}
+}
+
+func TestSavePostReply(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := &Post{-1, "reply", time.Now().UTC(), 4, 2, 30, -1, nil, nil}
+	if err := SavePost(db, post); err != nil {
+		t.Fatal(err)
+	}
+
+	posts, err := FindPosts(db, "4", math.MaxUint32, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(posts) != 2 {
+		t.Fatal("wrong number of posts")
+	}
+
+	if posts[1].ReplyTo != 30 || posts[1].Parent == nil {
+		t.Error("reply not saved")
+	}
+}
+
+func TestDeletePostWithReplies(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = DeletePost(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post, err := FindOnePost(db, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if post.ReplyTo != -1 || post.Parent != nil {
+		t.Error("reply should become a top level post")
+	}
+}
+
+func TestFindPostIndex(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts, err := FindPosts(db, "1", math.MaxUint32, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, post := range posts {
+		index, err := FindPostIndex(db, strconv.Itoa(post.Id))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if index != i {
+			t.Errorf("post %d should be at index %d, not %d", post.Id, i, index)
+		}
+	}
+}
+
+func TestFindLatestPosts(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts, err := FindLatestPosts(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(posts) != 2 || posts[0].Id != 30 || posts[1].Id != 29 {
+		t.Error("should find the newest posts first")
+	}
+}
+
+func TestPostCursor(t *testing.T) {
+	cursor := PostCursor{time.Date(2014, 11, 4, 6, 8, 47, 772019858, time.UTC), 30}
+	parsed, err := ParsePostCursor(cursor.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Id != 30 || !parsed.Published.Equal(cursor.Published.Truncate(time.Second)) {
+		t.Errorf("cursor %s parsed as %v", cursor, parsed)
+	}
+
+	for _, value := range []string{"", "1", "a-1", "1-b", "1-2-3"} {
+		if _, err := ParsePostCursor(value); err == nil {
+			t.Errorf("%q should not parse", value)
+		}
+	}
+}
+
+func TestFindPostsKeyset(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// posts published within the same second are ordered by id
+	published := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 7; i++ {
+		post := NewPost()
+		post.Text = "post " + strconv.Itoa(i)
+		post.Published = published.Add(time.Duration(i/3) * time.Second)
+		post.TopicId = 5
+		post.UserId = 1
+		err = SavePost(db, post)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := FindPosts(db, "5", math.MaxUint32, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 7 {
+		t.Fatalf("expected 7 posts, got %d", len(all))
+	}
+
+	forward := make([]Post, 0)
+	var after *PostCursor
+	for {
+		page, err := FindPostsAfter(db, "5", after, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		forward = append(forward, page...)
+		cursor := page[len(page)-1].Cursor()
+		after = &cursor
+	}
+
+	backward := make([]Post, 0)
+	var before *PostCursor
+	for {
+		page, err := FindPostsBefore(db, "5", before, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		backward = append(page, backward...)
+		cursor := page[0].Cursor()
+		before = &cursor
+	}
+
+	for i, post := range all {
+		if forward[i].Id != post.Id || backward[i].Id != post.Id {
+			t.Errorf("post %d should be %d, walked forward to %d and backward to %d", i, post.Id, forward[i].Id, backward[i].Id)
+		}
+	}
+	if len(forward) != len(all) || len(backward) != len(all) {
+		t.Errorf("walked %d posts forward and %d backward, expected %d", len(forward), len(backward), len(all))
+	}
+
+	// numbered pages start at their anchor
+	for offset := 0; offset < len(all); offset++ {
+		page, err := FindPosts(db, "5", 2, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 || page[0].Id != all[offset].Id {
+			t.Errorf("page at offset %d should start with post %d, got %v", offset, all[offset].Id, page)
+		}
+	}
+	page, err := FindPosts(db, "5", 2, len(all))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 0 {
+		t.Errorf("pages past the end should be empty, got %v", page)
+	}
+}
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"database/sql"
+	"strconv"
+)
+
+const (
+	MinPageSize = 5
+	MaxPageSize = 100
+)
+
+// Variants of the site's look a user can choose.
+const (
+	VariantLight = "light"
+	VariantDark  = "dark"
+)
+
+var Variants = []string{VariantLight, VariantDark}
+
+// Preferences are a user's choices of how the forum is shown. Zero values
+// stand for the site defaults.
+type Preferences struct {
+	UserId        int `schema:"-"`
+	PostsPerPage  int
+	TopicsPerPage int
+	Variant       string
+	// Language is the tag of the locale the forum is shown in, empty for the
+	// one the browser asks for.
+	Language string
+}
+
+func NewPreferences(userId int) *Preferences {
+	return &Preferences{userId, 0, 0, "", ""}
+}
+
+func validPageSize(size int) bool {
+	return size == 0 || (size >= MinPageSize && size <= MaxPageSize)
+}
+
+func ValidatePreferences(prefs *Preferences) (ok bool, errs []error) {
+	errs = make([]error, 0)
+
+	if !validPageSize(prefs.PostsPerPage) {
+		errs = append(errs, Errorf("Posts per page must be between %d and %d.", MinPageSize, MaxPageSize))
+	}
+
+	if !validPageSize(prefs.TopicsPerPage) {
+		errs = append(errs, Errorf("Topics per page must be between %d and %d.", MinPageSize, MaxPageSize))
+	}
+
+	if prefs.Variant != "" && prefs.Variant != VariantLight && prefs.Variant != VariantDark {
+		errs = append(errs, Errorf("Variant must be %s or %s.", VariantLight, VariantDark))
+	}
+
+	return len(errs) == 0, errs
+}
+
+// FindPreferences returns a user's preferences, the defaults if they never
+// saved any.
+func FindPreferences(db *sql.DB, userId int) (*Preferences, error) {
+	prefs := NewPreferences(userId)
+
+	row := db.QueryRow("SELECT posts_per_page, topics_per_page, variant, language FROM user_preferences WHERE user_id = ?", userId)
+	err := row.Scan(&prefs.PostsPerPage, &prefs.TopicsPerPage, &prefs.Variant, &prefs.Language)
+	if err == sql.ErrNoRows {
+		return prefs, nil
+	}
+	if err != nil {
+		return nil, &InternalError{"could not query for preferences of user " + strconv.Itoa(userId), err}
+	}
+
+	return prefs, nil
+}
+
+func SavePreferences(db *sql.DB, prefs *Preferences) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO user_preferences (user_id, posts_per_page, topics_per_page, variant, language) VALUES (?,?,?,?,?)",
+		prefs.UserId, prefs.PostsPerPage, prefs.TopicsPerPage, prefs.Variant, prefs.Language)
+	return err
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPreferences(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefs, err := FindPreferences(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(prefs, NewPreferences(1)) {
+		t.Errorf("users without preferences should get the defaults, got %v", prefs)
+	}
+
+	prefs.PostsPerPage = 50
+	err = SavePreferences(db, prefs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefs.TopicsPerPage = 20
+	prefs.Variant = VariantDark
+	prefs.Language = "de"
+	err = SavePreferences(db, prefs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := FindPreferences(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, &Preferences{1, 50, 20, VariantDark, "de"}) {
+		t.Errorf("wrong saved preferences %v", saved)
+	}
+}
+
+func TestValidatePreferences(t *testing.T) {
+	for _, size := range []int{0, MinPageSize, 25, MaxPageSize} {
+		if ok, errs := ValidatePreferences(&Preferences{1, size, size, "", ""}); !ok {
+			t.Errorf("%d per page should be valid, got %v", size, errs)
+		}
+	}
+
+	for _, size := range []int{-1, 1, MinPageSize - 1, MaxPageSize + 1} {
+		if ok, errs := ValidatePreferences(&Preferences{1, size, size, "", ""}); ok || len(errs) != 2 {
+			t.Errorf("%d per page should be invalid", size)
+		}
+	}
+}
+
+func TestValidateVariant(t *testing.T) {
+	for _, variant := range []string{"", VariantLight, VariantDark} {
+		if ok, errs := ValidatePreferences(&Preferences{1, 0, 0, variant, ""}); !ok {
+			t.Errorf("variant %q should be valid, got %v", variant, errs)
+		}
+	}
+
+	if ok, _ := ValidatePreferences(&Preferences{1, 0, 0, "neon", ""}); ok {
+		t.Error("unknown variants should be invalid")
+	}
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// FindRenderedPosts returns the cached html of posts keyed by post id, given
+// the version each is rendered by, leaving out posts that were rendered by
+// another version.
+func FindRenderedPosts(db *sql.DB, versions map[int]string) (map[int]string, error) {
+	rendered := make(map[int]string)
+	if len(versions) == 0 {
+		return rendered, nil
+	}
+
+	args := make([]interface{}, 0, len(versions))
+	for id := range versions {
+		args = append(args, id)
+	}
+
+	rows, err := db.Query("SELECT post_id, version, html FROM rendered_posts WHERE post_id IN (?"+strings.Repeat(",?", len(versions)-1)+")", args...)
+	if err != nil {
+		return nil, &InternalError{"could not query for rendered posts", err}
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			postId  int
+			version string
+			html    string
+		)
+		err := rows.Scan(&postId, &version, &html)
+		if err != nil {
+			return nil, err
+		}
+
+		if version == versions[postId] {
+			rendered[postId] = html
+		}
+	}
+
+	return rendered, nil
+}
+
+// SaveRenderedPost caches the html of a post, replacing what an older
+// renderer version left.
+func SaveRenderedPost(db *sql.DB, postId int, version string, html string) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO rendered_posts (post_id, version, html) VALUES (?,?,?)", postId, version, html)
+	return err
+}
+
+// DeleteRenderedPost drops the cached html of a post, it has to be called
+// whenever the text of a post changes.
+func DeleteRenderedPost(db execer, postId int) error {
+	_, err := db.Exec("DELETE FROM rendered_posts WHERE post_id = ?", postId)
+	return err
+}
+
+// DeleteRenderedMentions drops the cached html of posts that mention a
+// username. Mentions are only linked while their user exists, so it has to be
+// called whenever a user is created. Posts mentioning a longer username that
+// starts with it are rendered again too.
+func DeleteRenderedMentions(db execer, username string) error {
+	_, err := db.Exec("DELETE FROM rendered_posts WHERE post_id IN (SELECT id FROM posts WHERE instr(text, ?) > 0)", "@"+username)
+	return err
+}
+
+// FindPostsAfterId returns posts with an id greater than afterId in id order,
+// for walking over every post in batches.
+func FindPostsAfterId(db *sql.DB, afterId int, limit int) ([]Post, error) {
+	rows, err := db.Query(selectPosts+" WHERE posts.id > ? ORDER BY posts.id ASC LIMIT ?", afterId, limit)
+	if err != nil {
+		return nil, &InternalError{"could not query for posts", err}
+	}
+	defer rows.Close()
+
+	posts := make([]Post, 0)
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		posts = append(posts, post)
+	}
+
+	return posts, nil
+}
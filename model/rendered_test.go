@@ -0,0 +1,126 @@
+package model
+
+import "testing"
+
+func TestRenderedPosts(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SaveRenderedPost(db, 1, "v1", "<p>one</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveRenderedPost(db, 2, "v1", "<p>two</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveRenderedPost(db, 2, "v2", "<p>two again</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rendered, err := FindRenderedPosts(db, map[int]string{1: "v1", 2: "v1", 3: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rendered) != 1 || rendered[1] != "<p>one</p>" {
+		t.Errorf("only post 1 is rendered by v1, got %v", rendered)
+	}
+
+	rendered, err = FindRenderedPosts(db, map[int]string{1: "v2", 2: "v2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rendered) != 1 || rendered[2] != "<p>two again</p>" {
+		t.Errorf("saving should replace the older version, got %v", rendered)
+	}
+
+	rendered, err = FindRenderedPosts(db, map[int]string{1: "v1", 2: "v2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rendered) != 2 {
+		t.Errorf("posts may be rendered by different versions, got %v", rendered)
+	}
+
+	err = DeletePost(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err = FindRenderedPosts(db, map[int]string{2: "v2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rendered) != 0 {
+		t.Error("deleting a post should delete its html")
+	}
+}
+
+func TestDeleteRenderedMentions(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := NewPost()
+	post.Text = "waiting for @newbie"
+	post.TopicId = 4
+	post.UserId = 2
+	err = SavePost(db, post)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SaveRenderedPost(db, 1, "v1", "<p>one</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveRenderedPost(db, post.Id, "v1", "<p>waiting for @newbie</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := NewUser()
+	user.Username = "newbie"
+	user.PasswordHash = []byte("hash")
+	err = SaveUser(db, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rendered, err := FindRenderedPosts(db, map[int]string{1: "v1", post.Id: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rendered[post.Id]; ok || len(rendered) != 1 {
+		t.Errorf("creating a user should only drop the html of posts mentioning them, got %v", rendered)
+	}
+}
+
+func TestFindPostsAfterId(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts, err := FindPostsAfterId(db, 0, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 5 || posts[0].Id != 1 || posts[4].Id != 5 {
+		t.Fatal("wrong first batch of posts")
+	}
+
+	posts, err = FindPostsAfterId(db, posts[4].Id, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) == 0 || posts[0].Id != 6 {
+		t.Error("wrong second batch of posts")
+	}
+}
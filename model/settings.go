@@ -0,0 +1,121 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Names of the rows of the settings table.
+const (
+	settingTitle  = "title"
+	settingLogo   = "logo"
+	settingFooter = "footer"
+	settingTheme  = "theme"
+)
+
+const maxFooterLength = 1000
+
+// Settings customize the site for everyone. An empty Theme is the forum's own
+// look and an empty Logo shows the Title instead.
+type Settings struct {
+	Title  string
+	Logo   string
+	Footer string
+	Theme  string
+}
+
+func NewSettings() *Settings {
+	return &Settings{"Forum", "", "", ""}
+}
+
+func ValidateSettings(settings *Settings) (ok bool, errs []error) {
+	errs = make([]error, 0)
+
+	if strings.TrimSpace(settings.Title) == "" {
+		errs = append(errs, errors.New("Site must have a title."))
+	}
+
+	if len(settings.Title) > 255 {
+		errs = append(errs, errors.New("Site title is too long."))
+	}
+
+	if settings.Logo != "" {
+		u, err := url.Parse(settings.Logo)
+		if err != nil || len(settings.Logo) > 255 ||
+			!(u.Scheme == "http" || u.Scheme == "https" || (u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path, "/"))) {
+			errs = append(errs, errors.New("Logo must be an http or https URL, or a path starting with /."))
+		}
+	}
+
+	if len(settings.Footer) > maxFooterLength {
+		errs = append(errs, errors.New("Footer text is too long."))
+	}
+
+	return len(errs) == 0, errs
+}
+
+// FindSettings returns the site's settings, the defaults for those never
+// saved.
+func FindSettings(db *sql.DB) (*Settings, error) {
+	settings := NewSettings()
+
+	rows, err := db.Query("SELECT name, value FROM settings")
+	if err != nil {
+		return nil, &InternalError{"could not query for settings", err}
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, value string
+		err = rows.Scan(&name, &value)
+		if err != nil {
+			return nil, &InternalError{"could not scan settings", err}
+		}
+
+		switch name {
+		case settingTitle:
+			settings.Title = value
+		case settingLogo:
+			settings.Logo = value
+		case settingFooter:
+			settings.Footer = value
+		case settingTheme:
+			settings.Theme = value
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, &InternalError{"could not query for settings", err}
+	}
+
+	return settings, nil
+}
+
+func SaveSettings(db *sql.DB, settings *Settings) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return &InternalError{"could not begin saving settings", err}
+	}
+
+	values := map[string]string{
+		settingTitle:  strings.TrimSpace(settings.Title),
+		settingLogo:   strings.TrimSpace(settings.Logo),
+		settingFooter: strings.TrimSpace(settings.Footer),
+		settingTheme:  settings.Theme,
+	}
+	for name, value := range values {
+		_, err = tx.Exec("INSERT OR REPLACE INTO settings (name, value) VALUES (?,?)", name, value)
+		if err != nil {
+			tx.Rollback()
+			return &InternalError{"could not save setting " + name, err}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return &InternalError{"could not save settings", err}
+	}
+	return nil
+}
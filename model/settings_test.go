@@ -0,0 +1,61 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSettings(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := FindSettings(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(settings, NewSettings()) {
+		t.Errorf("unsaved settings should be the defaults, got %v", settings)
+	}
+
+	settings = &Settings{" My Forum ", "/static/logo.png", "Be nice.", "slate"}
+	err = SaveSettings(db, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := FindSettings(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, &Settings{"My Forum", "/static/logo.png", "Be nice.", "slate"}) {
+		t.Errorf("wrong saved settings %v", saved)
+	}
+}
+
+func TestValidateSettings(t *testing.T) {
+	valid := []*Settings{
+		NewSettings(),
+		{"Forum", "https://example.com/logo.png", "", ""},
+		{"Forum", "/static/logo.png", "footer", "slate"},
+	}
+	for _, settings := range valid {
+		if ok, errs := ValidateSettings(settings); !ok {
+			t.Errorf("%v should be valid, got %v", settings, errs)
+		}
+	}
+
+	invalid := []*Settings{
+		{" ", "", "", ""},
+		{"Forum", "javascript:alert(1)", "", ""},
+		{"Forum", "logo.png", "", ""},
+		{"Forum", "//example.com/logo.png", "", ""},
+	}
+	for _, settings := range invalid {
+		if ok, _ := ValidateSettings(settings); ok {
+			t.Errorf("%v should be invalid", settings)
+		}
+	}
+}
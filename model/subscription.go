@@ -0,0 +1,245 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+const (
+	FrequencyImmediate = "immediate"
+	FrequencyDaily     = "daily"
+	FrequencyWeekly    = "weekly"
+)
+
+var frequencyIntervals = map[string]time.Duration{
+	FrequencyImmediate: 0,
+	FrequencyDaily:     24 * time.Hour,
+	FrequencyWeekly:    7 * 24 * time.Hour,
+}
+
+// Subscription follows either a topic or a whole forum. Posts newer than
+// LastPostId are sent to the user once the frequency interval has passed
+// since LastSent.
+type Subscription struct {
+	Id         int
+	UserId     int
+	TopicId    int
+	ForumId    int
+	Frequency  string
+	LastSent   time.Time
+	LastPostId int
+
+	// relations
+	Topic *Topic
+	Forum *Forum
+}
+
+func NewSubscription() *Subscription {
+	return &Subscription{-1, -1, -1, -1, FrequencyImmediate, time.Now().UTC(), -1, nil, nil}
+}
+
+func ValidFrequency(frequency string) bool {
+	_, ok := frequencyIntervals[frequency]
+	return ok
+}
+
+func latestPostId(db querier) (int, error) {
+	var id int
+
+	row := db.QueryRow("SELECT ifnull(max(id), 0) FROM posts")
+	err := row.Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// subscribe adds a subscription for one of topic_id or forum_id, doing nothing
+// if the user is already subscribed. Only posts made after subscribing are sent.
+func subscribe(db querier, userId int, column string, id int, frequency string) error {
+	if !ValidFrequency(frequency) {
+		return errors.New("Invalid subscription frequency.")
+	}
+
+	var count int
+	row := db.QueryRow("SELECT count(*) FROM subscriptions WHERE user_id = ? AND "+column+" = ?", userId, id)
+	err := row.Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	lastPostId, err := latestPostId(db)
+	if err != nil {
+		return err
+	}
+
+	subscription := NewSubscription()
+	subscription.UserId = userId
+	subscription.Frequency = frequency
+	subscription.LastPostId = lastPostId
+	if column == "topic_id" {
+		subscription.TopicId = id
+	} else {
+		subscription.ForumId = id
+	}
+
+	_, err = db.Exec("INSERT INTO subscriptions (id, user_id, topic_id, forum_id, frequency, last_sent, last_post_id) VALUES (NULL,?,?,?,?,?,?)",
+		subscription.UserId, nullableId(subscription.TopicId), nullableId(subscription.ForumId), subscription.Frequency, subscription.LastSent, subscription.LastPostId)
+	return err
+}
+
+func SubscribeTopic(db *sql.DB, userId int, topicId int, frequency string) error {
+	return subscribe(db, userId, "topic_id", topicId, frequency)
+}
+
+func SubscribeForum(db *sql.DB, userId int, forumId int, frequency string) error {
+	return subscribe(db, userId, "forum_id", forumId, frequency)
+}
+
+func UnsubscribeTopic(db *sql.DB, userId int, topicId int) error {
+	_, err := db.Exec("DELETE FROM subscriptions WHERE user_id = ? AND topic_id = ?", userId, topicId)
+	return err
+}
+
+func UnsubscribeForum(db *sql.DB, userId int, forumId int) error {
+	_, err := db.Exec("DELETE FROM subscriptions WHERE user_id = ? AND forum_id = ?", userId, forumId)
+	return err
+}
+
+func IsSubscribedToTopic(db *sql.DB, userId int, topicId int) bool {
+	var count int
+	row := db.QueryRow("SELECT count(*) FROM subscriptions WHERE user_id = ? AND topic_id = ?", userId, topicId)
+	return row.Scan(&count) == nil && count > 0
+}
+
+func IsSubscribedToForum(db *sql.DB, userId int, forumId int) bool {
+	var count int
+	row := db.QueryRow("SELECT count(*) FROM subscriptions WHERE user_id = ? AND forum_id = ?", userId, forumId)
+	return row.Scan(&count) == nil && count > 0
+}
+
+func SetSubscriptionFrequency(db *sql.DB, userId int, reqId int, frequency string) error {
+	if !ValidFrequency(frequency) {
+		return errors.New("Invalid subscription frequency.")
+	}
+
+	_, err := db.Exec("UPDATE subscriptions SET frequency = ? WHERE id = ? AND user_id = ?", frequency, reqId, userId)
+	return err
+}
+
+func DeleteSubscription(db *sql.DB, userId int, reqId int) error {
+	_, err := db.Exec("DELETE FROM subscriptions WHERE id = ? AND user_id = ?", reqId, userId)
+	return err
+}
+
+const selectSubscriptions = `SELECT subscriptions.id, subscriptions.user_id, subscriptions.topic_id, subscriptions.forum_id,
+		subscriptions.frequency, subscriptions.last_sent, subscriptions.last_post_id,
+		ifnull(topics.title, ''), ifnull(forums.title, '')
+	FROM subscriptions
+		LEFT JOIN topics ON subscriptions.topic_id = topics.id
+		LEFT JOIN forums ON subscriptions.forum_id = forums.id`
+
+func scanSubscription(row rowScanner) (Subscription, error) {
+	var (
+		id         int
+		userId     int
+		topicId    sql.NullInt64
+		forumId    sql.NullInt64
+		frequency  string
+		lastSent   time.Time
+		lastPostId int
+		topicTitle string
+		forumTitle string
+	)
+
+	err := row.Scan(&id, &userId, &topicId, &forumId, &frequency, &lastSent, &lastPostId, &topicTitle, &forumTitle)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	subscription := Subscription{id, userId, -1, -1, frequency, lastSent, lastPostId, nil, nil}
+	if topicId.Valid {
+		subscription.TopicId = int(topicId.Int64)
+		subscription.Topic = &Topic{subscription.TopicId, topicTitle, "", -1, -1, -1, time.Time{}, nil}
+	}
+	if forumId.Valid {
+		subscription.ForumId = int(forumId.Int64)
+		subscription.Forum = &Forum{subscription.ForumId, forumTitle, "", 0, 0, -1, time.Time{}}
+	}
+
+	return subscription, nil
+}
+
+func findSubscriptions(db *sql.DB, query string, args ...interface{}) ([]Subscription, error) {
+	rows, err := db.Query(selectSubscriptions+query, args...)
+	if err != nil {
+		return nil, &InternalError{"could not query for subscriptions", err}
+	}
+	defer rows.Close()
+
+	subscriptions := make([]Subscription, 0)
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	return subscriptions, nil
+}
+
+func FindSubscriptions(db *sql.DB, userId int) ([]Subscription, error) {
+	return findSubscriptions(db, " WHERE subscriptions.user_id = ? ORDER BY subscriptions.id", userId)
+}
+
+// FindDueSubscriptions returns the subscriptions whose frequency interval has
+// passed at the given time.
+func FindDueSubscriptions(db *sql.DB, now time.Time) ([]Subscription, error) {
+	return findSubscriptions(db, ` WHERE subscriptions.frequency = ?
+		OR (subscriptions.frequency = ? AND datetime(subscriptions.last_sent) <= datetime(?))
+		OR (subscriptions.frequency = ? AND datetime(subscriptions.last_sent) <= datetime(?))
+		ORDER BY subscriptions.user_id, subscriptions.id`,
+		FrequencyImmediate,
+		FrequencyDaily, now.Add(-frequencyIntervals[FrequencyDaily]),
+		FrequencyWeekly, now.Add(-frequencyIntervals[FrequencyWeekly]))
+}
+
+// FindSubscriptionPosts returns the posts made since a subscription was last
+// sent, leaving out the subscriber's own posts.
+func FindSubscriptionPosts(db *sql.DB, subscription Subscription) ([]Post, error) {
+	filter := " WHERE posts.topic_id = ?"
+	id := subscription.TopicId
+	if subscription.TopicId == -1 {
+		filter = " WHERE posts.topic_id IN (SELECT id FROM topics WHERE forum_id = ?)"
+		id = subscription.ForumId
+	}
+
+	rows, err := db.Query(selectPosts+filter+" AND posts.id > ? AND posts.user_id != ? ORDER BY posts.id ASC",
+		id, subscription.LastPostId, subscription.UserId)
+	if err != nil {
+		return nil, &InternalError{"could not query for posts for subscription " + strconv.Itoa(subscription.Id), err}
+	}
+	defer rows.Close()
+
+	posts := make([]Post, 0)
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		posts = append(posts, post)
+	}
+
+	return posts, nil
+}
+
+func MarkSubscriptionSent(db *sql.DB, reqId int, sent time.Time, lastPostId int) error {
+	_, err := db.Exec("UPDATE subscriptions SET last_sent = ?, last_post_id = max(last_post_id, ?) WHERE id = ?", sent, lastPostId, reqId)
+	return err
+}
@@ -0,0 +1,227 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEmptySubscription(t *testing.T) {
+	subscription := NewSubscription()
+	if subscription.Id != -1 || subscription.TopicId != -1 || subscription.ForumId != -1 || subscription.Frequency != FrequencyImmediate {
+		t.Error("subscription not empty")
+	}
+}
+
+func TestSubscribeTopic(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if IsSubscribedToTopic(db, 1, 2) {
+		t.Error("should not be subscribed yet")
+	}
+
+	err = SubscribeTopic(db, 1, 2, FrequencyWeekly)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SubscribeTopic(db, 1, 2, FrequencyWeekly)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscriptions, err := FindSubscriptions(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(subscriptions) != 1 {
+		t.Fatal("should only subscribe once")
+	}
+
+	subscription := subscriptions[0]
+	if subscription.TopicId != 2 || subscription.ForumId != -1 || subscription.Topic == nil || subscription.Topic.Title != "test topic" {
+		t.Error("wrong topic subscription")
+	}
+
+	if subscription.LastPostId != 30 {
+		t.Error("only new posts should be sent")
+	}
+
+	err = UnsubscribeTopic(db, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if IsSubscribedToTopic(db, 1, 2) {
+		t.Error("should be unsubscribed")
+	}
+}
+
+func TestSubscribeForum(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SubscribeForum(db, 2, 1, "hourly")
+	if err == nil {
+		t.Error("invalid frequency should not subscribe")
+	}
+
+	err = SubscribeForum(db, 2, 1, FrequencyDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsSubscribedToForum(db, 2, 1) {
+		t.Fatal("should be subscribed")
+	}
+
+	post := &Post{-1, "new", time.Now().UTC(), 4, 1, -1, -1, nil, nil}
+	err = SavePost(db, post)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscriptions, err := FindSubscriptions(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(subscriptions) != 2 || subscriptions[1].Forum == nil || subscriptions[1].Forum.Title != "test" {
+		t.Fatal("wrong forum subscription")
+	}
+
+	posts, err := FindSubscriptionPosts(db, subscriptions[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(posts) != 1 || posts[0].Id != post.Id {
+		t.Error("new post in forum should be sent")
+	}
+
+	err = UnsubscribeForum(db, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if IsSubscribedToForum(db, 2, 1) {
+		t.Error("should be unsubscribed")
+	}
+}
+
+func TestSavePostSubscribes(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := &Post{-1, "new", time.Now().UTC(), 3, 2, -1, -1, nil, nil}
+	err = SavePost(db, post)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsSubscribedToTopic(db, 2, 3) {
+		t.Error("posting should subscribe to the topic")
+	}
+}
+
+func TestFindDueSubscriptions(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastSent := time.Date(2014, 11, 3, 0, 0, 0, 0, time.UTC)
+
+	due, err := FindDueSubscriptions(db, lastSent.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(due) != 0 {
+		t.Error("daily subscription is not due yet")
+	}
+
+	due, err = FindDueSubscriptions(db, lastSent.Add(25*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(due) != 1 {
+		t.Fatal("daily subscription should be due")
+	}
+
+	posts, err := FindSubscriptionPosts(db, due[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(posts) != 3 || posts[0].Id != 24 {
+		t.Error("wrong posts since last sent")
+	}
+
+	err = MarkSubscriptionSent(db, due[0].Id, time.Now().UTC(), posts[2].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscriptions, err := FindSubscriptions(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts, err = FindSubscriptionPosts(db, subscriptions[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(posts) != 0 {
+		t.Error("sent posts should not be sent again")
+	}
+}
+
+func TestSetSubscriptionFrequency(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SetSubscriptionFrequency(db, 2, 1, "never")
+	if err == nil {
+		t.Error("invalid frequency should not be set")
+	}
+
+	err = SetSubscriptionFrequency(db, 2, 1, FrequencyWeekly)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscriptions, err := FindSubscriptions(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if subscriptions[0].Frequency != FrequencyWeekly {
+		t.Error("frequency not updated")
+	}
+
+	err = DeleteSubscription(db, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if IsSubscribedToTopic(db, 2, 1) {
+		t.Error("subscription should be deleted")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Topic struct {
@@ -12,14 +13,19 @@ type Topic struct {
 	Title       string
 	Description string
 	ForumId     int
-	PostCount   int
+
+	// counters kept up to date by SavePost and DeletePost, LastPostId is -1
+	// for topics without posts
+	PostCount  int
+	LastPostId int
+	LastPostAt time.Time
 
 	// relations
 	Forum *Forum
 }
 
 func NewTopic() *Topic {
-	return &Topic{-1, "", "", -1, -1, nil}
+	return &Topic{-1, "", "", -1, -1, -1, time.Time{}, nil}
 }
 
 func ValidateTopic(db *sql.DB, topic *Topic) (ok bool, errs []error) {
@@ -47,78 +53,174 @@ func ValidateTopic(db *sql.DB, topic *Topic) (ok bool, errs []error) {
 	return len(errs) == 0, errs
 }
 
+// SaveTopic inserts a topic and counts it in its forum.
 func SaveTopic(db *sql.DB, topic *Topic) error {
-	_, err := db.Exec("INSERT INTO topics (id, title, description, forum_id) VALUES (NULL,?,?,?)", topic.Title, topic.Description, topic.ForumId)
-	return err
+	return SaveTopicWithPost(db, topic, nil)
 }
 
-func FindOneTopic(db *sql.DB, reqId string) (*Topic, error) {
+// SaveTopicWithPost inserts a topic and its first post, if post is not nil,
+// in one transaction.
+func SaveTopicWithPost(db *sql.DB, topic *Topic, post *Post) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("INSERT INTO topics (id, title, description, forum_id) VALUES (NULL,?,?,?)", topic.Title, topic.Description, topic.ForumId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE forums SET topic_count = topic_count + 1 WHERE id = ?", topic.ForumId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if post != nil {
+		post.TopicId = int(id)
+		err = insertPost(tx, post)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	topic.Id = int(id)
+
+	return nil
+}
+
+const selectTopics = `SELECT topics.id, topics.title, topics.description, topics.forum_id,
+		topics.post_count, topics.last_post_id, topics.last_post_at
+	FROM topics`
+
+func scanTopic(row rowScanner) (Topic, error) {
 	var (
 		id          int
 		title       string
 		description string
 		forumId     int
+		postCount   int
+		lastPostId  sql.NullInt64
+		lastPostAt  *time.Time
 	)
 
-	row := db.QueryRow("SELECT * FROM topics WHERE id = ?", reqId)
-	err := row.Scan(&id, &title, &description, &forumId)
+	err := row.Scan(&id, &title, &description, &forumId, &postCount, &lastPostId, &lastPostAt)
+	if err != nil {
+		return Topic{}, err
+	}
+
+	topic := Topic{id, title, description, forumId, postCount, -1, time.Time{}, nil}
+	topic.LastPostId, topic.LastPostAt = lastPost(lastPostId, lastPostAt)
+	return topic, nil
+}
+
+// FindOneTopic returns a topic with its forum in a single query.
+func FindOneTopic(db *sql.DB, reqId string) (*Topic, error) {
+	row := db.QueryRow(`SELECT topics.id, topics.title, topics.description, topics.forum_id,
+			topics.post_count, topics.last_post_id, topics.last_post_at,
+			forums.id, forums.title, forums.description,
+			forums.topic_count, forums.post_count, forums.last_post_id, forums.last_post_at
+		FROM topics
+			JOIN forums ON topics.forum_id = forums.id
+		WHERE topics.id = ?`, reqId)
+
+	var (
+		topic                            Topic
+		forum                            Forum
+		topicLastPostId, forumLastPostId sql.NullInt64
+		topicLastPostAt, forumLastPostAt *time.Time
+	)
+	err := row.Scan(&topic.Id, &topic.Title, &topic.Description, &topic.ForumId,
+		&topic.PostCount, &topicLastPostId, &topicLastPostAt,
+		&forum.Id, &forum.Title, &forum.Description,
+		&forum.TopicCount, &forum.PostCount, &forumLastPostId, &forumLastPostAt)
 	if err != nil {
-		return &Topic{}, errors.New("could not query for topic with id " + reqId)
+		return &Topic{}, queryError(err, "topic with id "+reqId)
 	}
 
-	forum, err := FindOneForum(db, strconv.Itoa(forumId))
+	topic.LastPostId, topic.LastPostAt = lastPost(topicLastPostId, topicLastPostAt)
+	forum.LastPostId, forum.LastPostAt = lastPost(forumLastPostId, forumLastPostAt)
+	topic.Forum = &forum
+	return &topic, nil
+}
+
+// FindTopics returns a page of a forum's topics, starting at an anchor looked
+// up on the forum index rather than skipping offset topics.
+func FindTopics(db *sql.DB, reqId string, limit int, offset int) ([]Topic, error) {
+	topics, err := queryTopics(db, selectTopics+` WHERE topics.forum_id = ?
+			AND topics.id >= (SELECT anchor.id FROM topics anchor WHERE anchor.forum_id = ? ORDER BY anchor.id LIMIT 1 OFFSET ?)
+		ORDER BY topics.id LIMIT ?`, reqId, reqId, offset, limit)
 	if err != nil {
-		return &Topic{}, err
+		return nil, &InternalError{"could not query for topics for forum " + reqId, err}
 	}
 
-	postCount, err := postCount(db, reqId)
+	return topics, nil
+}
+
+// FindTopicsAfter returns up to limit topics of a forum with an id greater
+// than afterId, 0 starting from the first topic.
+func FindTopicsAfter(db *sql.DB, reqId string, afterId int, limit int) ([]Topic, error) {
+	topics, err := queryTopics(db, selectTopics+" WHERE topics.forum_id = ? AND topics.id > ? ORDER BY topics.id LIMIT ?", reqId, afterId, limit)
 	if err != nil {
-		return &Topic{}, err
+		return nil, &InternalError{"could not query for topics for forum " + reqId, err}
 	}
 
-	return &Topic{id, title, description, forumId, postCount, forum}, nil
+	return topics, nil
 }
 
-func postCount(db *sql.DB, reqId string) (int, error) {
-	var count int
+// FindTopicsBefore returns up to limit topics of a forum with an id less than
+// beforeId, 0 ending at the last topic. Topics are still in id order.
+func FindTopicsBefore(db *sql.DB, reqId string, beforeId int, limit int) ([]Topic, error) {
+	const reverseOrder = " ORDER BY topics.id DESC"
 
-	row := db.QueryRow("SELECT count(*) FROM posts WHERE topic_id = ?", reqId)
-	err := row.Scan(&count)
+	var (
+		topics []Topic
+		err    error
+	)
+	if beforeId == 0 {
+		topics, err = queryTopics(db, selectTopics+" WHERE topics.forum_id = ?"+reverseOrder+" LIMIT ?", reqId, limit)
+	} else {
+		topics, err = queryTopics(db, selectTopics+" WHERE topics.forum_id = ? AND topics.id < ?"+reverseOrder+" LIMIT ?", reqId, beforeId, limit)
+	}
 	if err != nil {
-		return 0, err
+		return nil, &InternalError{"could not query for topics for forum " + reqId, err}
 	}
 
-	return count, nil
+	for i, j := 0, len(topics)-1; i < j; i, j = i+1, j-1 {
+		topics[i], topics[j] = topics[j], topics[i]
+	}
+	return topics, nil
 }
 
-func FindTopics(db *sql.DB, reqId string, limit int, offset int) ([]Topic, error) {
-	rows, err := db.Query("SELECT * FROM topics WHERE forum_id = ? LIMIT ? OFFSET ?", reqId, limit, offset)
-	defer rows.Close()
+func queryTopics(db *sql.DB, query string, args ...interface{}) ([]Topic, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		return nil, errors.New("could not query for topics for fourm " + reqId)
+		return nil, err
 	}
+	defer rows.Close()
 
 	topics := make([]Topic, 0)
 	for rows.Next() {
-		var (
-			id          int
-			title       string
-			description string
-			forumId     int
-		)
-
-		err := rows.Scan(&id, &title, &description, &forumId)
-		if err != nil {
-			return nil, errors.New("could not process row")
-		}
-
-		postCount, err := postCount(db, strconv.Itoa(id))
+		topic, err := scanTopic(rows)
 		if err != nil {
-			return nil, errors.New("could not could topics for forum with id " + strconv.Itoa(id))
+			return nil, &InternalError{"could not process row", err}
 		}
 
-		topics = append(topics, Topic{id, title, description, forumId, postCount, nil})
+		topics = append(topics, topic)
 	}
 
-	return topics, nil
+	return topics, rows.Err()
 }
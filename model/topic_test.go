@@ -3,13 +3,15 @@ package model
 import (
 	"math"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestEmptyTopic(t *testing.T) {
 	topic := NewTopic()
-	if !reflect.DeepEqual(topic, &Topic{-1, "", "", -1, -1, nil}) {
+	if !reflect.DeepEqual(topic, &Topic{-1, "", "", -1, -1, -1, time.Time{}, nil}) {
 		t.Error("topic not empty")
 	}
 }
@@ -81,6 +83,9 @@ func TestSaveTopic(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	if newTopic.Id == -1 {
+		t.Error("saving should set the topic id")
+	}
 
 	newTopicsForum1, err := FindTopics(db, "1", math.MaxInt64, 0)
 	if err != nil {
@@ -92,6 +97,40 @@ func TestSaveTopic(t *testing.T) {
 	}
 }
 
+func TestSaveTopicWithPost(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic := NewTopic()
+	topic.Title = "started with a post"
+	topic.ForumId = 1
+	post := NewPost()
+	post.Text = "first"
+	post.UserId = 1
+
+	if ok, _ := ValidateFirstPost(db, post); !ok {
+		t.Fatal("the first post should validate before its topic exists")
+	}
+	err = SaveTopicWithPost(db, topic, post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.TopicId != topic.Id || post.Id == -1 {
+		t.Errorf("post %d was saved in topic %d, want %d", post.Id, post.TopicId, topic.Id)
+	}
+
+	saved, err := FindOneTopic(db, strconv.Itoa(topic.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.PostCount != 1 || saved.LastPostId != post.Id {
+		t.Errorf("the first post was not counted: %d posts, last %d", saved.PostCount, saved.LastPostId)
+	}
+}
+
 func TestFindTopicsNoLimit(t *testing.T) {
 	db, err := GetMockupDB()
 	defer db.Close()
@@ -221,3 +260,52 @@ func TestValidateTopic(t *testing.T) {
 		t.Error("255 description should be ok")
 	}
 }
+
+func TestFindTopicsKeyset(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// forum 1 has topics 1, 4 and 5
+	topics, err := FindTopicsAfter(db, "1", 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 2 || topics[0].Id != 1 || topics[1].Id != 4 {
+		t.Errorf("wrong first page %v", topics)
+	}
+
+	topics, err = FindTopicsAfter(db, "1", 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 1 || topics[0].Id != 5 {
+		t.Errorf("wrong second page %v", topics)
+	}
+
+	topics, err = FindTopicsBefore(db, "1", 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 2 || topics[0].Id != 4 || topics[1].Id != 5 {
+		t.Errorf("wrong last page %v", topics)
+	}
+
+	topics, err = FindTopicsBefore(db, "1", 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 1 || topics[0].Id != 1 {
+		t.Errorf("wrong page before topic 4 %v", topics)
+	}
+
+	topics, err = FindTopics(db, "1", 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 2 || topics[0].Id != 4 {
+		t.Errorf("numbered page should start at its anchor %v", topics)
+	}
+}
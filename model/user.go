@@ -3,6 +3,7 @@ package model
 import (
 	"database/sql"
 	"errors"
+	"strconv"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -86,7 +87,7 @@ func FindOneUserByUsername(db *sql.DB, reqId string) (User, error) {
 	row := db.QueryRow("SELECT * FROM users WHERE username = ?", reqId)
 	err := row.Scan(&id, &username, &email, &passwordHash)
 	if err != nil {
-		return User{}, errors.New("could not query for user with username " + reqId)
+		return User{}, queryError(err, "user with username "+reqId)
 	}
 
 	return User{id, username, email, []byte{}, passwordHash}, nil
@@ -103,7 +104,7 @@ func FindOneUserById(db *sql.DB, reqId int) (User, error) {
 	row := db.QueryRow("SELECT * FROM users WHERE id = ?", reqId)
 	err := row.Scan(&id, &username, &email, &passwordHash)
 	if err != nil {
-		return User{}, errors.New("could not query for user with id " + string(reqId))
+		return User{}, queryError(err, "user with id "+strconv.Itoa(reqId))
 	}
 
 	return User{id, username, email, []byte{}, passwordHash}, nil
@@ -118,6 +119,30 @@ func SaveUser(db *sql.DB, user *User) error {
 		return errors.New("Password must be hashed.")
 	}
 
-	_, err := db.Exec("INSERT INTO users (id, username, email, password_hash) VALUES (NULL,?,?,?)", user.Username, user.Email, user.PasswordHash)
-	return err
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("INSERT INTO users (id, username, email, password_hash) VALUES (NULL,?,?,?)", user.Username, user.Email, user.PasswordHash)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	user.Id = int(id)
+
+	// earlier mentions of the new user become links
+	err = DeleteRenderedMentions(tx, user.Username)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
 }
@@ -0,0 +1,280 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EventTopicCreated   = "topic.created"
+	EventPostCreated    = "post.created"
+	EventPostDeleted    = "post.deleted"
+	EventUserRegistered = "user.registered"
+)
+
+// WebhookEvents lists every event a webhook can subscribe to.
+var WebhookEvents = []string{EventTopicCreated, EventPostCreated, EventPostDeleted, EventUserRegistered}
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook posts a signed JSON payload to URL whenever one of its events
+// happens.
+type Webhook struct {
+	Id      int
+	URL     string
+	Secret  string
+	Events  []string
+	Created time.Time
+}
+
+// WebhookDelivery is both a queued payload for a webhook and, once attempted,
+// the log of how that went.
+type WebhookDelivery struct {
+	Id           int
+	WebhookId    int
+	Event        string
+	Payload      string
+	Status       string
+	Attempts     int
+	NextAttempt  time.Time
+	ResponseCode int
+	Error        string
+	Created      time.Time
+
+	// relations
+	Webhook *Webhook
+}
+
+func NewWebhook() *Webhook {
+	return &Webhook{-1, "", "", []string{}, time.Now().UTC()}
+}
+
+func (webhook *Webhook) HasEvent(event string) bool {
+	for _, e := range webhook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateWebhook(webhook *Webhook) (ok bool, errs []error) {
+	errs = make([]error, 0)
+
+	u, err := url.Parse(strings.TrimSpace(webhook.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, errors.New("Webhook must have a valid http or https URL."))
+	}
+
+	if len(webhook.URL) > 255 {
+		errs = append(errs, errors.New("Webhook URL is too long."))
+	}
+
+	if strings.TrimSpace(webhook.Secret) == "" {
+		errs = append(errs, errors.New("Webhook must have a secret."))
+	}
+
+	if len(webhook.Events) == 0 {
+		errs = append(errs, errors.New("Webhook must have at least one event."))
+	}
+
+	for _, event := range webhook.Events {
+		if !ValidWebhookEvent(event) {
+			errs = append(errs, Errorf("Unknown webhook event %s.", event))
+		}
+	}
+
+	return len(errs) == 0, errs
+}
+
+func SaveWebhook(db *sql.DB, webhook *Webhook) error {
+	result, err := db.Exec("INSERT INTO webhooks (id, url, secret, events, created) VALUES (NULL,?,?,?,?)",
+		strings.TrimSpace(webhook.URL), webhook.Secret, strings.Join(webhook.Events, ","), webhook.Created)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	webhook.Id = int(id)
+
+	return nil
+}
+
+// DeleteWebhook removes a webhook along with its queue and delivery log.
+func DeleteWebhook(db *sql.DB, reqId int) error {
+	_, err := db.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", reqId)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM webhooks WHERE id = ?", reqId)
+	return err
+}
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var (
+		id      int
+		url     string
+		secret  string
+		events  string
+		created time.Time
+	)
+
+	err := row.Scan(&id, &url, &secret, &events, &created)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	return Webhook{id, url, secret, strings.Split(events, ","), created}, nil
+}
+
+func FindOneWebhook(db *sql.DB, reqId string) (Webhook, error) {
+	webhook, err := scanWebhook(db.QueryRow("SELECT id, url, secret, events, created FROM webhooks WHERE id = ?", reqId))
+	if err != nil {
+		return Webhook{}, queryError(err, "webhook with id "+reqId)
+	}
+
+	return webhook, nil
+}
+
+func FindWebhooks(db *sql.DB) ([]Webhook, error) {
+	rows, err := db.Query("SELECT id, url, secret, events, created FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, &InternalError{"could not query for webhooks", err}
+	}
+	defer rows.Close()
+
+	webhooks := make([]Webhook, 0)
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, nil
+}
+
+// QueueWebhookDeliveries queues a payload for every webhook subscribed to the
+// event.
+func QueueWebhookDeliveries(db *sql.DB, event string, payload string) error {
+	webhooks, err := FindWebhooks(db)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, webhook := range webhooks {
+		if !webhook.HasEvent(event) {
+			continue
+		}
+
+		_, err := db.Exec(`INSERT INTO webhook_deliveries (id, webhook_id, event, payload, status, attempts, next_attempt, response_code, error, created)
+			VALUES (NULL,?,?,?,?,0,?,0,'',?)`, webhook.Id, event, payload, DeliveryPending, now, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const selectWebhookDeliveries = `SELECT webhook_deliveries.id, webhook_deliveries.webhook_id, webhook_deliveries.event,
+		webhook_deliveries.payload, webhook_deliveries.status, webhook_deliveries.attempts, webhook_deliveries.next_attempt,
+		webhook_deliveries.response_code, webhook_deliveries.error, webhook_deliveries.created,
+		webhooks.url, webhooks.secret, webhooks.events, webhooks.created
+	FROM webhook_deliveries
+		JOIN webhooks ON webhook_deliveries.webhook_id = webhooks.id`
+
+func scanWebhookDelivery(row rowScanner) (WebhookDelivery, error) {
+	var (
+		id             int
+		webhookId      int
+		event          string
+		payload        string
+		status         string
+		attempts       int
+		nextAttempt    time.Time
+		responseCode   int
+		deliveryError  string
+		created        time.Time
+		webhookURL     string
+		webhookSecret  string
+		webhookEvents  string
+		webhookCreated time.Time
+	)
+
+	err := row.Scan(&id, &webhookId, &event, &payload, &status, &attempts, &nextAttempt, &responseCode, &deliveryError, &created,
+		&webhookURL, &webhookSecret, &webhookEvents, &webhookCreated)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+
+	return WebhookDelivery{id, webhookId, event, payload, status, attempts, nextAttempt, responseCode, deliveryError, created,
+		&Webhook{webhookId, webhookURL, webhookSecret, strings.Split(webhookEvents, ","), webhookCreated}}, nil
+}
+
+func findWebhookDeliveries(db *sql.DB, query string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := db.Query(selectWebhookDeliveries+query, args...)
+	if err != nil {
+		return nil, &InternalError{"could not query for webhook deliveries", err}
+	}
+	defer rows.Close()
+
+	deliveries := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+// FindDueWebhookDeliveries returns the pending deliveries whose next attempt
+// is due at the given time, oldest first.
+func FindDueWebhookDeliveries(db *sql.DB, now time.Time, limit int) ([]WebhookDelivery, error) {
+	return findWebhookDeliveries(db, ` WHERE webhook_deliveries.status = ? AND datetime(webhook_deliveries.next_attempt) <= datetime(?)
+		ORDER BY webhook_deliveries.id LIMIT ?`, DeliveryPending, now, limit)
+}
+
+// FindWebhookDeliveries returns the delivery log of a webhook, newest first.
+func FindWebhookDeliveries(db *sql.DB, webhookId int, limit int) ([]WebhookDelivery, error) {
+	return findWebhookDeliveries(db, " WHERE webhook_deliveries.webhook_id = ? ORDER BY webhook_deliveries.id DESC LIMIT ?", webhookId, limit)
+}
+
+// SaveWebhookAttempt records the outcome of an attempt to deliver.
+func SaveWebhookAttempt(db *sql.DB, delivery *WebhookDelivery) error {
+	_, err := db.Exec("UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt = ?, response_code = ?, error = ? WHERE id = ?",
+		delivery.Status, delivery.Attempts, delivery.NextAttempt, delivery.ResponseCode, delivery.Error, delivery.Id)
+	if err != nil {
+		return &InternalError{"could not save attempt for webhook delivery " + strconv.Itoa(delivery.Id), err}
+	}
+
+	return nil
+}
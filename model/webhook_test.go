@@ -0,0 +1,121 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateWebhook(t *testing.T) {
+	webhook := NewWebhook()
+	if ok, errs := ValidateWebhook(webhook); ok || len(errs) != 3 {
+		t.Error("empty webhook should not validate")
+	}
+
+	webhook.URL = "ftp://example.com/hook"
+	webhook.Secret = "secret"
+	webhook.Events = []string{EventPostCreated}
+	if ok, _ := ValidateWebhook(webhook); ok {
+		t.Error("webhook URL must be http or https")
+	}
+
+	webhook.URL = "https://example.com/hook"
+	webhook.Events = []string{EventPostCreated, "post.liked"}
+	if ok, _ := ValidateWebhook(webhook); ok {
+		t.Error("webhook events must be known")
+	}
+
+	webhook.Events = []string{EventPostCreated}
+	if ok, errs := ValidateWebhook(webhook); !ok {
+		t.Error(errs)
+	}
+}
+
+func TestSaveWebhook(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webhook := NewWebhook()
+	webhook.URL = "https://example.com/hook"
+	webhook.Secret = "secret"
+	webhook.Events = []string{EventTopicCreated, EventUserRegistered}
+
+	err = SaveWebhook(db, webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := FindOneWebhook(db, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhook.Id != 2 || saved.URL != webhook.URL || !saved.HasEvent(EventUserRegistered) || saved.HasEvent(EventPostCreated) {
+		t.Error("wrong webhook")
+	}
+
+	err = DeleteWebhook(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, err := FindWebhookDeliveries(db, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 0 {
+		t.Error("deleting a webhook should delete its deliveries")
+	}
+}
+
+func TestQueueWebhookDeliveries(t *testing.T) {
+	db, err := GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = QueueWebhookDeliveries(db, EventUserRegistered, `{"event":"user.registered"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = QueueWebhookDeliveries(db, EventPostDeleted, `{"event":"post.deleted"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	due, err := FindDueWebhookDeliveries(db, now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].Event != EventPostDeleted || due[0].Webhook.Secret != "secret" {
+		t.Fatal("only subscribed events should be queued")
+	}
+
+	delivery := due[0]
+	delivery.Attempts++
+	delivery.NextAttempt = now.Add(time.Hour)
+	delivery.ResponseCode = 500
+	err = SaveWebhookAttempt(db, &delivery)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due, err = FindDueWebhookDeliveries(db, now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 0 {
+		t.Error("retries should wait for their next attempt")
+	}
+
+	deliveries, err := FindWebhookDeliveries(db, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 || deliveries[0].Id != delivery.Id || deliveries[0].ResponseCode != 500 || deliveries[1].Status != DeliveryDelivered {
+		t.Error("wrong delivery log")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mt2d2/forum/model"
+)
+
+func (app *app) handleNotifications(w http.ResponseWriter, req *http.Request) {
+	session, _ := app.sessions.Get(req, "forumSession")
+	userID, _ := session.Values["user_id"].(int)
+
+	notifications, err := model.FindNotifications(app.db, userID, limitNotificationsPage, 0)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	app.addBreadCrumb(req, "/notifications", app.T(req, "Notifications"))
+
+	app.renderTemplate(w, req, "notifications", &notificationsView{AllNotifications: notifications})
+}
+
+func (app *app) handleMarkNotificationsRead(w http.ResponseWriter, req *http.Request) {
+	session, _ := app.sessions.Get(req, "forumSession")
+	userID, _ := session.Values["user_id"].(int)
+
+	req.ParseForm()
+
+	var err error
+	if req.PostFormValue("All") != "" {
+		err = model.MarkAllNotificationsRead(app.db, userID)
+	} else {
+		ids := make([]int, 0, len(req.PostForm["NotificationId"]))
+		for _, value := range req.PostForm["NotificationId"] {
+			if id, err := strconv.Atoi(value); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		err = model.MarkNotificationsRead(app.db, userID, ids)
+	}
+
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	http.Redirect(w, req, "/notifications", http.StatusFound)
+}
+
+func (app *app) handleNotification(w http.ResponseWriter, req *http.Request) {
+	session, _ := app.sessions.Get(req, "forumSession")
+	userID, _ := session.Values["user_id"].(int)
+
+	notification, err := model.FindOneNotification(app.db, mux.Vars(req)["id"])
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	if notification.UserId != userID {
+		app.addErrorFlash(w, req, errors.New("You can only read your own notifications!"))
+		http.Redirect(w, req, "/", http.StatusFound)
+		return
+	}
+
+	err = model.MarkNotificationRead(app.db, notification.Id)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	if notification.PostId == -1 {
+		http.Redirect(w, req, "/topic/"+strconv.Itoa(notification.TopicId), http.StatusFound)
+		return
+	}
+	http.Redirect(w, req, "/post/"+strconv.Itoa(notification.PostId), http.StatusFound)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+type breadCrumb struct{ URL, Title string }
+
+// page is the state a request gathers for the layout while it is handled.
+// It lives in the request context, so concurrent requests never share it.
+type page struct {
+	// breadCrumbs follow the index, which the layout adds in the reader's
+	// language
+	breadCrumbs []breadCrumb
+	locale      *locale
+}
+
+func newPage() *page {
+	return &page{}
+}
+
+// withPage gives every request a fresh page.
+func withPage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), pageKey, newPage())))
+	})
+}
+
+// pageOf returns the page of a request. Requests that did not pass through
+// withPage get an empty page of their own, which is simply thrown away.
+func pageOf(req *http.Request) *page {
+	if p, ok := req.Context().Value(pageKey).(*page); ok {
+		return p
+	}
+	return newPage()
+}
+
+func (app *app) addBreadCrumb(req *http.Request, url, title string) {
+	p := pageOf(req)
+	p.breadCrumbs = append(p.breadCrumbs, breadCrumb{url, title})
+}
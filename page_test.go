@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/mt2d2/forum/model"
+)
+
+// breadCrumbsOf cuts the breadcrumb list out of a rendered page.
+func breadCrumbsOf(body string) string {
+	start := strings.Index(body, `<ol class="breadcrumb">`)
+	if start < 0 {
+		return ""
+	}
+	end := strings.Index(body[start:], "</ol>")
+	return body[start : start+end]
+}
+
+func TestConcurrentBreadCrumbs(t *testing.T) {
+	db, err := model.GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown, err := newMarkdownRenderer("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &app{templates: testTemplates(t, markdown), db: db, sessions: sessions.NewCookieStore([]byte("test")), presence: newPresence(), markdown: markdown, previews: newLinkPreviewer("", 0)}
+	router := mux.NewRouter()
+	router.HandleFunc("/", app.handleIndex)
+	router.HandleFunc("/forum/{id:[0-9]+}", app.handleForum)
+	router.HandleFunc("/forum/{id:[0-9]+}/page/{page:[0-9]+}", app.handleForum)
+	router.HandleFunc("/topic/{id:[0-9]+}", app.handleTopic)
+	router.HandleFunc("/topic/{id:[0-9]+}/page/{page:[0-9]+}", app.handleTopic)
+	router.HandleFunc("/user/login", app.handleLogin)
+	router.NotFoundHandler = http.HandlerFunc(app.handleNotFound)
+	handler := withRequestID(withPage(router))
+
+	get := func(url string) (int, string) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w.Code, breadCrumbsOf(w.Body.String())
+	}
+
+	urls := []string{"/", "/forum/1", "/forum/2", "/topic/1", "/topic/1/page/2", "/topic/2", "/user/login", "/nowhere"}
+	want := make(map[string]string)
+	for _, url := range urls {
+		code, crumbs := get(url)
+		if crumbs == "" {
+			t.Fatalf("%s returned %d without breadcrumbs", url, code)
+		}
+		want[url] = crumbs
+	}
+	if strings.Count(want["/topic/1/page/2"], "<li>") != 4 {
+		t.Fatalf("topic page has breadcrumbs %s", want["/topic/1/page/2"])
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for _, url := range urls {
+			wg.Add(1)
+			go func(url string) {
+				defer wg.Done()
+				if _, crumbs := get(url); crumbs != want[url] {
+					t.Errorf("%s has breadcrumbs %s, want %s", url, crumbs, want[url])
+				}
+			}(url)
+		}
+	}
+	wg.Wait()
+}
+
+func TestPageOfWithoutMiddleware(t *testing.T) {
+	app := &app{}
+	req := httptest.NewRequest("GET", "/", nil)
+	app.addBreadCrumb(req, "/forum/1", "Forum")
+
+	if crumbs := pageOf(req).breadCrumbs; len(crumbs) != 0 {
+		t.Errorf("request without a page has breadcrumbs %v", crumbs)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import "strconv"
+
+// pagerWindow is the number of pages linked on either side of the current one.
+const pagerWindow = 2
+
+// pagerLink is one entry of a pager, a link that is the current page, or a
+// disabled placeholder for an arrow at either end or a gap between pages.
+type pagerLink struct {
+	Label    string
+	URL      string
+	Active   bool
+	Disabled bool
+}
+
+// newPager links the pages of base, which are base/page/<n> after the first:
+// first and previous arrows, a window of pages around the current one with
+// gaps marked, then next and last arrows. Topics with thousands of pages get
+// the same handful of links. A single page needs no pager.
+func newPager(base string, current, pages int) []pagerLink {
+	if pages <= 1 {
+		return nil
+	}
+
+	url := func(page int) string {
+		if page <= 1 {
+			return base
+		}
+		return base + "/page/" + strconv.Itoa(page)
+	}
+	gap := pagerLink{"…", "", false, true}
+
+	start := current - pagerWindow
+	if start < 1 {
+		start = 1
+	}
+	end := current + pagerWindow
+	if end > pages {
+		end = pages
+	}
+
+	links := []pagerLink{
+		{"«", url(1), false, current <= 1},
+		{"‹", url(current - 1), false, current <= 1},
+	}
+	if start > 1 {
+		links = append(links, gap)
+	}
+	for page := start; page <= end; page++ {
+		links = append(links, pagerLink{strconv.Itoa(page), url(page), page == current, false})
+	}
+	if end < pages {
+		links = append(links, gap)
+	}
+	links = append(links,
+		pagerLink{"›", url(current + 1), false, current >= pages},
+		pagerLink{"»", url(pages), false, current >= pages})
+
+	return links
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// pagerLabels writes a pager as its labels, the current page in brackets and
+// disabled entries in parentheses.
+func pagerLabels(links []pagerLink) string {
+	labels := make([]string, 0, len(links))
+	for _, link := range links {
+		switch {
+		case link.Active:
+			labels = append(labels, "["+link.Label+"]")
+		case link.Disabled:
+			labels = append(labels, "("+link.Label+")")
+		default:
+			labels = append(labels, link.Label)
+		}
+	}
+	return strings.Join(labels, " ")
+}
+
+func TestPager(t *testing.T) {
+	tests := []struct {
+		current, pages int
+		labels         string
+	}{
+		{1, 0, ""},
+		{1, 1, ""},
+		{1, 3, "(«) (‹) [1] 2 3 › »"},
+		{3, 3, "« ‹ 1 2 [3] (›) (»)"},
+		{1, 5000, "(«) (‹) [1] 2 3 (…) › »"},
+		{2500, 5000, "« ‹ (…) 2498 2499 [2500] 2501 2502 (…) › »"},
+		{5000, 5000, "« ‹ (…) 4998 4999 [5000] (›) (»)"},
+	}
+
+	for _, test := range tests {
+		labels := pagerLabels(newPager("/topic/1", test.current, test.pages))
+		if labels != test.labels {
+			t.Errorf("page %d of %d should be %q, got %q", test.current, test.pages, test.labels, labels)
+		}
+	}
+
+	links := newPager("/topic/1", 2, 3)
+	if links[0].URL != "/topic/1" || links[1].URL != "/topic/1" || links[len(links)-1].URL != "/topic/1/page/3" {
+		t.Errorf("wrong urls %v", links)
+	}
+}
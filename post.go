@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/Schema"
 	"github.com/gorilla/mux"
@@ -16,27 +17,113 @@ func (app *app) handleAddPost(w http.ResponseWriter, req *http.Request) {
 
 	topic, err := model.FindOneTopic(app.db, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		app.handleError(w, req, err)
 		return
 	}
 
-	app.addBreadCrumb("/forum/"+strconv.Itoa(topic.Forum.Id), topic.Forum.Title)
-	app.addBreadCrumb("/topic/"+strconv.Itoa(topic.Id), topic.Title)
-	app.addBreadCrumb("/topic/"+strconv.Itoa(topic.Id)+"/add", "Add Post")
+	app.addBreadCrumb(req, "/forum/"+strconv.Itoa(topic.Forum.Id), topic.Forum.Title)
+	app.addBreadCrumb(req, "/topic/"+strconv.Itoa(topic.Id), topic.Title)
+	app.addBreadCrumb(req, "/topic/"+strconv.Itoa(topic.Id)+"/add", app.T(req, "Add Post"))
+
+	results := &addPostView{
+		TopicId:           id,
+		MaxAttachments:    maxAttachmentsPerPost,
+		MaxAttachmentSize: model.FormatSize(*attachmentMaxSize),
+		AttachmentTypes:   strings.Join(attachmentLimits().Types, ", "),
+	}
+
+	if quoteId := req.URL.Query().Get("quote"); quoteId != "" {
+		quoted, err := model.FindOnePost(app.db, quoteId)
+		if err == nil && quoted.TopicId == topic.Id {
+			results.ReplyTo = quoted.Id
+			results.Text = quoteMarkdown(quoted)
+		}
+	}
 
-	results := make(map[string]interface{})
-	results["TopicId"] = id
 	app.renderTemplate(w, req, "addPost", results)
 }
 
+// quoteMarkdown attributes a post to its author and block quotes its text.
+func quoteMarkdown(post model.Post) string {
+	text := strings.Replace(strings.TrimSpace(post.Text), "\r\n", "\n", -1)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = "> " + line
+	}
+
+	return "**" + post.User.Username + "** [wrote](/post/" + strconv.Itoa(post.Id) + "):\n\n" +
+		strings.Join(lines, "\n") + "\n\n"
+}
+
+func (app *app) handlePost(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	post, err := model.FindOnePost(app.db, id)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	index, err := model.FindPostIndex(app.db, id)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	postsPerPage, _ := app.pageSizes(req)
+	page := index/postsPerPage + 1
+	http.Redirect(w, req, "/topic/"+strconv.Itoa(post.TopicId)+"/page/"+strconv.Itoa(page)+"#post-"+id, http.StatusFound)
+}
+
+// handlePreview renders the Text of a form exactly as it would be shown in a
+// post, for the editor preview. The forum, or the topic if given, picks the
+// sanitizer policy.
+func (app *app) handlePreview(w http.ResponseWriter, req *http.Request) {
+	req.Body = http.MaxBytesReader(w, req.Body, maxPreviewSize)
+	err := req.ParseForm()
+	if err != nil {
+		app.handleError(w, req, model.NewValidationError(errors.New("The text is too long to preview.")))
+		return
+	}
+
+	forumId, err := strconv.Atoi(req.PostFormValue("ForumId"))
+	if err != nil {
+		forumId = -1
+	}
+	if topicId := req.PostFormValue("TopicId"); topicId != "" {
+		topic, err := model.FindOneTopic(app.db, topicId)
+		if err == nil {
+			forumId = topic.ForumId
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(app.markdown.render(forumId, req.PostFormValue("Text"))))
+}
+
+// publishPost tells live topic readers and webhooks about a new post.
+func (app *app) publishPost(post *model.Post) {
+	app.hub.publish(topicChannel(post.TopicId), "post", post.Id)
+	app.queueWebhook(model.EventPostCreated, app.postWebhookData(post))
+}
+
 func (app *app) handleSavePost(w http.ResponseWriter, req *http.Request) {
-	req.ParseForm()
+	req.Body = http.MaxBytesReader(w, req.Body, maxPostSize())
+	err := req.ParseMultipartForm(maxUploadMemory)
+	if err != nil && err != http.ErrNotMultipart {
+		app.addErrorFlash(w, req, errors.New("Attachments are too large."))
+		http.Redirect(w, req, "/topic/"+mux.Vars(req)["id"]+"/add", http.StatusFound)
+		return
+	}
+	if req.MultipartForm != nil {
+		defer req.MultipartForm.RemoveAll()
+	}
 
 	post := model.NewPost()
 	decoder := schema.NewDecoder()
-	err := decoder.Decode(post, req.PostForm)
+	err = decoder.Decode(post, req.PostForm)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		app.handleError(w, req, model.NewValidationError(err))
 		return
 	}
 
@@ -45,26 +132,45 @@ func (app *app) handleSavePost(w http.ResponseWriter, req *http.Request) {
 		post.UserId = userID
 	}
 
-	ok, errors := model.ValidatePost(app.db, post)
+	files, err := uploads(req, post.UserId)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	ok, errs := model.ValidatePost(app.db, post)
+	if uploadsOk, uploadErrs := app.validateUploads(post.UserId, files); !uploadsOk {
+		ok = false
+		errs = append(errs, uploadErrs...)
+	}
 	if !ok {
-		app.addErrorFlashes(w, req, errors)
+		app.addErrorFlashes(w, req, errs)
 		http.Redirect(w, req, "/topic/"+req.PostFormValue("TopicId")+"/add", http.StatusFound)
 		return
 	}
 
-	err = model.SavePost(app.db, post)
+	attachments, err := app.storeUploads(files)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	err = model.SavePostWithAttachments(app.db, post, attachments)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		app.deleteUploads(attachments)
+		app.handleError(w, req, err)
 		return
 	}
+	app.publishPost(post)
 
 	topic, err := model.FindOneTopic(app.db, strconv.Itoa(post.TopicId))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		app.handleError(w, req, err)
 		return
 	}
 
-	http.Redirect(w, req, "/topic/"+req.PostFormValue("TopicId")+"/page/"+strconv.Itoa(numberOfTopicPages(*topic)), http.StatusFound)
+	postsPerPage, _ := app.pageSizes(req)
+	http.Redirect(w, req, "/topic/"+req.PostFormValue("TopicId")+"/page/"+strconv.Itoa(numberOfTopicPages(*topic, postsPerPage)), http.StatusFound)
 }
 
 func (app *app) handleDeletePost(w http.ResponseWriter, req *http.Request) {
@@ -74,23 +180,42 @@ func (app *app) handleDeletePost(w http.ResponseWriter, req *http.Request) {
 	if userID, ok := session.Values["user_id"].(int); ok {
 		user, err := model.FindOneUserById(app.db, userID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			app.handleError(w, req, err)
+			return
 		}
 
 		post, err := model.FindOnePost(app.db, req.PostFormValue("PostId"))
 		if err != nil {
-			app.addErrorFlash(w, req, err)
-			http.Redirect(w, req, "/", http.StatusFound)
+			app.handleError(w, req, err)
 			return
 		}
 
-		if user.Id != post.User.Id {
+		moderated := user.Id != post.User.Id
+		if moderated && !isAdmin(user.Username) {
 			app.addErrorFlash(w, req, errors.New("You can only delete your own posts!"))
 			http.Redirect(w, req, "/", http.StatusFound)
 			return
 		}
 
-		model.DeletePost(app.db, post.Id)
+		attachments, err := model.FindAttachments(app.db, []int{post.Id})
+		if err != nil {
+			app.handleError(w, req, err)
+			return
+		}
+
+		if moderated {
+			err = model.ModeratePost(app.db, post.Id, user.Id)
+		} else {
+			err = model.DeletePost(app.db, post.Id)
+		}
+		if err != nil {
+			app.handleError(w, req, err)
+			return
+		}
+		app.deleteStoredAttachments(attachments[post.Id])
+		app.hub.publish(topicChannel(post.TopicId), "delete", post.Id)
+		app.queueWebhook(model.EventPostDeleted, app.postWebhookData(&post))
+
 		http.Redirect(w, req, "/topic/"+req.PostFormValue("TopicId"), http.StatusFound)
 	} else {
 		app.addErrorFlash(w, req, errors.New("Must be logged in!"))
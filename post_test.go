@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHandlePreview(t *testing.T) {
+	markdown, err := newMarkdownRenderer(*markdownOptions, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &app{markdown: markdown}
+
+	form := url.Values{"Text": {"**bold** <script>alert(1)</script>"}}
+	req := httptest.NewRequest("POST", "/preview", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	app.handlePreview(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("preview returned %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "<strong>bold</strong>") {
+		t.Errorf("preview should render markdown, got %s", body)
+	}
+	if strings.Contains(body, "<script>") {
+		t.Errorf("preview should be sanitized, got %s", body)
+	}
+}
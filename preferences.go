@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/Schema"
+	"github.com/mt2d2/forum/model"
+)
+
+// pageSizes returns how many posts and topics a page shows the user making a
+// request, their own choice or the site default.
+func (app *app) pageSizes(req *http.Request) (posts int, topics int) {
+	posts, topics = *postsPerPage, *topicsPerPage
+
+	session, _ := app.sessions.Get(req, "forumSession")
+	if userID, ok := session.Values["user_id"].(int); ok {
+		if prefs, err := model.FindPreferences(app.db, userID); err == nil {
+			if prefs.PostsPerPage != 0 {
+				posts = prefs.PostsPerPage
+			}
+			if prefs.TopicsPerPage != 0 {
+				topics = prefs.TopicsPerPage
+			}
+		}
+	}
+
+	return posts, topics
+}
+
+func (app *app) handlePreferences(w http.ResponseWriter, req *http.Request) {
+	session, _ := app.sessions.Get(req, "forumSession")
+	userID, _ := session.Values["user_id"].(int)
+
+	prefs, err := model.FindPreferences(app.db, userID)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	app.addBreadCrumb(req, "/user/preferences", app.T(req, "Preferences"))
+
+	app.renderTemplate(w, req, "preferences", &preferencesView{
+		Preferences:   prefs,
+		PostsPerPage:  *postsPerPage,
+		TopicsPerPage: *topicsPerPage,
+		MinPageSize:   model.MinPageSize,
+		MaxPageSize:   model.MaxPageSize,
+		Variants:      model.Variants,
+		Locales:       app.locales.list(),
+	})
+}
+
+func (app *app) handleSavePreferences(w http.ResponseWriter, req *http.Request) {
+	session, _ := app.sessions.Get(req, "forumSession")
+	userID, _ := session.Values["user_id"].(int)
+
+	req.ParseForm()
+
+	prefs := model.NewPreferences(userID)
+	decoder := schema.NewDecoder()
+	err := decoder.Decode(prefs, req.PostForm)
+	if err != nil {
+		app.handleError(w, req, model.NewValidationError(err))
+		return
+	}
+
+	ok, errors := model.ValidatePreferences(prefs)
+	if !app.locales.exists(prefs.Language) {
+		ok = false
+		errors = append(errors, model.Errorf("Language %s is not available.", prefs.Language))
+	}
+	if !ok {
+		app.addErrorFlashes(w, req, errors)
+		http.Redirect(w, req, "/user/preferences", http.StatusFound)
+		return
+	}
+
+	err = model.SavePreferences(app.db, prefs)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	app.addSuccessFlash(w, req, "Preferences saved.")
+	http.Redirect(w, req, "/user/preferences", http.StatusFound)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/mt2d2/forum/model"
+)
+
+const (
+	presenceWriteWait  = 10 * time.Second
+	presencePongWait   = 60 * time.Second
+	presencePingPeriod = presencePongWait * 9 / 10
+	presenceBuffer     = 16
+	presenceIndexRoom  = "index"
+)
+
+var presenceUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+type presenceMessage struct {
+	Type     string         `json:"type"`
+	Members  []string       `json:"members,omitempty"`
+	Guests   int            `json:"guests"`
+	Username string         `json:"username,omitempty"`
+	Forums   map[string]int `json:"forums,omitempty"`
+}
+
+type presenceConn struct {
+	ws       *websocket.Conn
+	send     chan []byte
+	room     string
+	forumId  int
+	userId   int
+	username string
+}
+
+// presence tracks who is viewing each topic and forum. Viewers of a topic also
+// count towards its forum, and the index room is kept up to date with the
+// number of members in every forum.
+type presence struct {
+	mu    sync.Mutex
+	rooms map[string]map[*presenceConn]bool
+}
+
+func newPresence() *presence {
+	return &presence{rooms: make(map[string]map[*presenceConn]bool)}
+}
+
+func topicRoom(topicId int) string {
+	return "topic/" + strconv.Itoa(topicId)
+}
+
+func forumRoom(forumId int) string {
+	return "forum/" + strconv.Itoa(forumId)
+}
+
+func (p *presence) join(conn *presenceConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	room, ok := p.rooms[conn.room]
+	if !ok {
+		room = make(map[*presenceConn]bool)
+		p.rooms[conn.room] = room
+	}
+	room[conn] = true
+
+	p.changed(conn.room)
+}
+
+func (p *presence) leave(conn *presenceConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.remove(conn)
+}
+
+// remove must be called with the lock held.
+func (p *presence) remove(conn *presenceConn) {
+	room, ok := p.rooms[conn.room]
+	if !ok || !room[conn] {
+		return
+	}
+
+	delete(room, conn)
+	if len(room) == 0 {
+		delete(p.rooms, conn.room)
+	}
+	close(conn.send)
+
+	p.changed(conn.room)
+}
+
+// changed tells a room and the index about a join or leave. It must be called
+// with the lock held.
+func (p *presence) changed(name string) {
+	if name != presenceIndexRoom {
+		members, guests := p.viewers(p.rooms[name])
+		p.broadcast(name, nil, presenceMessage{Type: "presence", Members: members, Guests: guests})
+	}
+
+	if len(p.rooms[presenceIndexRoom]) > 0 {
+		p.broadcast(presenceIndexRoom, nil, presenceMessage{Type: "forums", Forums: p.forumCounts()})
+	}
+}
+
+// typing tells everyone else in the room that a member is writing a reply.
+func (p *presence) typing(conn *presenceConn) {
+	if conn.userId == -1 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.broadcast(conn.room, conn, presenceMessage{Type: "typing", Username: conn.username})
+}
+
+// broadcast must be called with the lock held. Connections that cannot keep
+// up are removed.
+func (p *presence) broadcast(name string, except *presenceConn, msg presenceMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	slow := make([]*presenceConn, 0)
+	for conn := range p.rooms[name] {
+		if conn == except {
+			continue
+		}
+
+		select {
+		case conn.send <- data:
+		default:
+			slow = append(slow, conn)
+		}
+	}
+
+	for _, conn := range slow {
+		p.remove(conn)
+	}
+}
+
+// viewers returns the sorted usernames of the members and the number of guests
+// in a set of connections, counting each member once.
+func (p *presence) viewers(conns map[*presenceConn]bool) ([]string, int) {
+	seen := make(map[int]bool)
+	members := make([]string, 0)
+	guests := 0
+
+	for conn := range conns {
+		if conn.userId == -1 {
+			guests++
+		} else if !seen[conn.userId] {
+			seen[conn.userId] = true
+			members = append(members, conn.username)
+		}
+	}
+
+	sort.Strings(members)
+	return members, guests
+}
+
+// forumCounts must be called with the lock held.
+func (p *presence) forumCounts() map[string]int {
+	members := make(map[int]map[int]bool)
+	for name, conns := range p.rooms {
+		if name == presenceIndexRoom {
+			continue
+		}
+
+		for conn := range conns {
+			if conn.userId == -1 {
+				continue
+			}
+			if members[conn.forumId] == nil {
+				members[conn.forumId] = make(map[int]bool)
+			}
+			members[conn.forumId][conn.userId] = true
+		}
+	}
+
+	counts := make(map[string]int)
+	for forumId, users := range members {
+		counts[strconv.Itoa(forumId)] = len(users)
+	}
+	return counts
+}
+
+// roomMembers returns the number of members currently viewing a room.
+func (p *presence) roomMembers(name string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	members, _ := p.viewers(p.rooms[name])
+	return len(members)
+}
+
+// forumMembers returns the number of members viewing each forum, keyed by id.
+func (p *presence) forumMembers() map[int]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	counts := make(map[int]int)
+	for id, count := range p.forumCounts() {
+		if forumId, err := strconv.Atoi(id); err == nil {
+			counts[forumId] = count
+		}
+	}
+	return counts
+}
+
+func (conn *presenceConn) writePump() {
+	ticker := time.NewTicker(presencePingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.ws.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-conn.send:
+			conn.ws.SetWriteDeadline(time.Now().Add(presenceWriteWait))
+			if !ok {
+				conn.ws.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := conn.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.ws.SetWriteDeadline(time.Now().Add(presenceWriteWait))
+			if err := conn.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readPump handles typing events until the connection fails or stops
+// answering pings, then removes the viewer.
+func (app *app) readPump(conn *presenceConn) {
+	defer func() {
+		app.presence.leave(conn)
+		conn.ws.Close()
+	}()
+
+	conn.ws.SetReadLimit(512)
+	conn.ws.SetReadDeadline(time.Now().Add(presencePongWait))
+	conn.ws.SetPongHandler(func(string) error {
+		conn.ws.SetReadDeadline(time.Now().Add(presencePongWait))
+		return nil
+	})
+
+	for {
+		var msg presenceMessage
+		if err := conn.ws.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		conn.ws.SetReadDeadline(time.Now().Add(presencePongWait))
+		if msg.Type == "typing" {
+			app.presence.typing(conn)
+		}
+	}
+}
+
+func (app *app) handlePresence(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	room := presenceIndexRoom
+	forumId := -1
+	switch vars["kind"] {
+	case "topic":
+		topic, err := model.FindOneTopic(app.db, vars["id"])
+		if err != nil {
+			app.handleError(w, req, err)
+			return
+		}
+		room = topicRoom(topic.Id)
+		forumId = topic.ForumId
+	case "forum":
+		forum, err := model.FindOneForum(app.db, vars["id"])
+		if err != nil {
+			app.handleError(w, req, err)
+			return
+		}
+		room = forumRoom(forum.Id)
+		forumId = forum.Id
+	}
+
+	conn := &presenceConn{nil, make(chan []byte, presenceBuffer), room, forumId, -1, ""}
+
+	session, _ := app.sessions.Get(req, "forumSession")
+	if userID, ok := session.Values["user_id"].(int); ok {
+		if user, err := model.FindOneUserById(app.db, userID); err == nil {
+			conn.userId = user.Id
+			conn.username = user.Username
+		}
+	}
+
+	ws, err := presenceUpgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// the upgrader has already replied
+		return
+	}
+	conn.ws = ws
+
+	go conn.writePump()
+	app.presence.join(conn)
+	app.readPump(conn)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func newTestPresenceConn(room string, forumId int, userId int, username string) *presenceConn {
+	return &presenceConn{nil, make(chan []byte, presenceBuffer), room, forumId, userId, username}
+}
+
+func lastPresenceMessage(t *testing.T, conn *presenceConn) presenceMessage {
+	var msg presenceMessage
+	for {
+		select {
+		case data := <-conn.send:
+			msg = presenceMessage{}
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			return msg
+		}
+	}
+}
+
+func TestPresenceJoinLeave(t *testing.T) {
+	p := newPresence()
+
+	index := newTestPresenceConn(presenceIndexRoom, -1, -1, "")
+	alice := newTestPresenceConn(topicRoom(1), 1, 2, "alice")
+	aliceAgain := newTestPresenceConn(topicRoom(1), 1, 2, "alice")
+	guest := newTestPresenceConn(topicRoom(1), 1, -1, "")
+
+	p.join(index)
+	p.join(alice)
+	p.join(aliceAgain)
+	p.join(guest)
+
+	msg := lastPresenceMessage(t, alice)
+	if msg.Type != "presence" || len(msg.Members) != 1 || msg.Members[0] != "alice" || msg.Guests != 1 {
+		t.Errorf("members should be counted once and guests apart: %v", msg)
+	}
+
+	msg = lastPresenceMessage(t, index)
+	if msg.Type != "forums" || msg.Forums["1"] != 1 {
+		t.Errorf("index should get member counts per forum: %v", msg)
+	}
+
+	if p.roomMembers(topicRoom(1)) != 1 || p.forumMembers()[1] != 1 {
+		t.Error("wrong number of members viewing")
+	}
+
+	p.leave(alice)
+	p.leave(aliceAgain)
+	if _, open := <-alice.send; open {
+		t.Error("leaving should close the connection")
+	}
+
+	msg = lastPresenceMessage(t, guest)
+	if len(msg.Members) != 0 || msg.Guests != 1 {
+		t.Errorf("leaving should be broadcast: %v", msg)
+	}
+
+	p.leave(guest)
+	if _, ok := p.rooms[topicRoom(1)]; ok {
+		t.Error("empty rooms should be removed")
+	}
+}
+
+func TestPresenceTyping(t *testing.T) {
+	p := newPresence()
+
+	alice := newTestPresenceConn(topicRoom(1), 1, 2, "alice")
+	bob := newTestPresenceConn(topicRoom(1), 1, 3, "bob")
+	guest := newTestPresenceConn(topicRoom(1), 1, -1, "")
+	p.join(alice)
+	p.join(bob)
+	p.join(guest)
+	lastPresenceMessage(t, alice)
+	lastPresenceMessage(t, bob)
+
+	p.typing(alice)
+	if msg := lastPresenceMessage(t, bob); msg.Type != "typing" || msg.Username != "alice" {
+		t.Errorf("typing should be broadcast: %v", msg)
+	}
+	if msg := lastPresenceMessage(t, alice); msg.Type != "" {
+		t.Errorf("typing should not be sent back: %v", msg)
+	}
+
+	p.typing(guest)
+	if msg := lastPresenceMessage(t, bob); msg.Type != "" {
+		t.Errorf("guests should not be shown typing: %v", msg)
+	}
+}
+
+func TestPresenceDropsSlowConnections(t *testing.T) {
+	p := newPresence()
+
+	slow := newTestPresenceConn(topicRoom(1), 1, 2, "alice")
+	p.join(slow)
+
+	for i := 0; i < presenceBuffer+1; i++ {
+		p.join(newTestPresenceConn(topicRoom(1), 1, -1, ""))
+	}
+
+	if p.rooms[topicRoom(1)][slow] {
+		t.Error("connections with a full buffer should be removed")
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mt2d2/forum/model"
+)
+
+// replyMarker starts every mail that can be answered; anything a mail client
+// quotes from below it is dropped from the reply.
+const replyMarker = "## Reply above this line to post in the topic ##"
+
+// replyLifetime is how long the reply address of a mail can be answered.
+const replyLifetime = 30 * 24 * time.Hour
+
+// maxInboundMail limits the size of raw messages accepted by the gateway.
+const maxInboundMail = 1 << 20
+
+var (
+	// "On Mon, Nov 3, 2014 at 6:36 AM, test <test@example.com> wrote:"
+	replyAttribution = regexp.MustCompile(`^On\s.*\bwrote:\s*$`)
+	replySeparators  = []string{"-----Original Message-----", "________________________________"}
+)
+
+// signReply signs a reply address. The signature is cut to 128 bits to keep
+// the local part within the 64 characters RFC 5321 allows.
+func signReply(userId int, topicId int, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(*replySecret))
+	mac.Write([]byte(strconv.Itoa(userId) + "-" + strconv.Itoa(topicId) + "-" + strconv.FormatInt(expires, 36)))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// replyAddress returns the address a user answers until expires to post in a
+// topic, such as forum+1-4-<expiry>-<signature>@example.com for -mail-from
+// forum@example.com. It is empty when replying by mail is disabled.
+func replyAddress(userId int, topicId int, expires time.Time) string {
+	if *replySecret == "" {
+		return ""
+	}
+
+	from, err := mail.ParseAddress(*mailFrom)
+	if err != nil {
+		return ""
+	}
+	at := strings.LastIndex(from.Address, "@")
+	if at == -1 {
+		return ""
+	}
+
+	expiry := expires.Unix()
+	return fmt.Sprintf("%s+%d-%d-%s-%s%s", from.Address[:at], userId, topicId, strconv.FormatInt(expiry, 36), signReply(userId, topicId, expiry), from.Address[at:])
+}
+
+// parseReplyAddress returns the user and topic of an address made by
+// replyAddress, checking its signature and expiry.
+func parseReplyAddress(address string) (userId int, topicId int, ok bool) {
+	at := strings.LastIndex(address, "@")
+	plus := strings.Index(address, "+")
+	if *replySecret == "" || at == -1 || plus == -1 || plus > at {
+		return 0, 0, false
+	}
+
+	parts := strings.Split(address[plus+1:at], "-")
+	if len(parts) != 4 {
+		return 0, 0, false
+	}
+
+	userId, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	topicId, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	expiry, err := strconv.ParseInt(strings.ToLower(parts[2]), 36, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	if !hmac.Equal([]byte(strings.ToLower(parts[3])), []byte(signReply(userId, topicId, expiry))) {
+		return 0, 0, false
+	}
+	if time.Now().Unix() > expiry {
+		return 0, 0, false
+	}
+
+	return userId, topicId, true
+}
+
+type mimeHeader interface {
+	Get(key string) string
+}
+
+// mailText returns the first text/plain part of a message body, decoding
+// base64 and quoted-printable parts.
+func mailText(header mimeHeader, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		parts := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := parts.NextPart()
+			if err == io.EOF {
+				return "", nil
+			}
+			if err != nil {
+				return "", err
+			}
+
+			text, err := mailText(part.Header, part)
+			if err != nil || text != "" {
+				return text, err
+			}
+		}
+	}
+
+	if mediaType != "text/plain" {
+		return "", nil
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+
+	text, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// extractReply drops what mail clients add to a reply: the quoted original
+// from its attribution line on, and the signature. Quotes before the
+// attribution are part of the reply.
+func extractReply(text string) string {
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+
+	reply := make([]string, 0, len(lines))
+scan:
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case line == "-- " || trimmed == "--":
+			break scan
+		case strings.Contains(line, replyMarker):
+			break scan
+		case replyAttribution.MatchString(trimmed):
+			break scan
+		case strings.HasPrefix(trimmed, "On ") && i+1 < len(lines) && replyAttribution.MatchString(trimmed+" "+strings.TrimSpace(lines[i+1])):
+			// attributions wrapped over two lines
+			break scan
+		}
+
+		for _, separator := range replySeparators {
+			if strings.HasPrefix(trimmed, separator) {
+				break scan
+			}
+		}
+
+		reply = append(reply, line)
+	}
+
+	// mobile clients sign off on their own
+	for len(reply) > 0 {
+		last := strings.TrimSpace(reply[len(reply)-1])
+		if last != "" && !strings.HasPrefix(last, "Sent from my ") {
+			break
+		}
+		reply = reply[:len(reply)-1]
+	}
+
+	return strings.TrimSpace(strings.Join(reply, "\n"))
+}
+
+// mailRecipient finds the reply address among the recipients of a message.
+func mailRecipient(header mail.Header) (userId int, topicId int, err error) {
+	for _, key := range []string{"To", "Cc", "Delivered-To", "X-Original-To"} {
+		addresses, err := header.AddressList(key)
+		if err != nil {
+			continue
+		}
+
+		for _, address := range addresses {
+			if userId, topicId, ok := parseReplyAddress(address.Address); ok {
+				return userId, topicId, nil
+			}
+		}
+	}
+
+	return 0, 0, errors.New("no valid reply address")
+}
+
+// mailFromUser reports whether a message is from the address of a user. Only
+// the user a reply address was made for may answer to it.
+func mailFromUser(header mail.Header, user model.User) bool {
+	addresses, err := header.AddressList("From")
+	if err != nil || len(addresses) != 1 || user.Email == "" {
+		return false
+	}
+	return strings.EqualFold(addresses[0].Address, user.Email)
+}
+
+// handleInboundMail accepts a raw RFC 5322 message, as piped in by a mail
+// server, and posts its text to the topic encoded in the reply address.
+func (app *app) handleInboundMail(w http.ResponseWriter, req *http.Request) {
+	if *replySecret == "" {
+		app.handleNotFound(w, req)
+		return
+	}
+
+	msg, err := mail.ReadMessage(http.MaxBytesReader(w, req.Body, maxInboundMail))
+	if err != nil {
+		app.handleError(w, req, model.NewValidationError(errors.New("The mail could not be read.")))
+		return
+	}
+
+	userId, topicId, err := mailRecipient(msg.Header)
+	if err != nil {
+		app.handleError(w, req, &model.ForbiddenError{Err: errors.New("The mail is not a reply to the forum.")})
+		return
+	}
+
+	user, err := model.FindOneUserById(app.db, userId)
+	if err != nil || !mailFromUser(msg.Header, user) {
+		app.handleError(w, req, &model.ForbiddenError{Err: errors.New("The mail was not sent from the address of the user.")})
+		return
+	}
+
+	text, err := mailText(msg.Header, msg.Body)
+	if err != nil {
+		app.handleError(w, req, model.NewValidationError(errors.New("The mail has no text.")))
+		return
+	}
+
+	post := model.NewPost()
+	post.TopicId = topicId
+	post.UserId = userId
+	post.Text = extractReply(text)
+
+	ok, errs := model.ValidatePost(app.db, post)
+	if !ok {
+		app.handleError(w, req, model.NewValidationError(errs...))
+		return
+	}
+
+	err = model.SavePost(app.db, post)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+	app.publishPost(post)
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "%s/post/%d\n", app.baseURL(), post.Id)
+}
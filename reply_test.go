@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/mt2d2/forum/model"
+)
+
+func withReplySecret(secret string) func() {
+	old := *replySecret
+	*replySecret = secret
+	return func() { *replySecret = old }
+}
+
+func TestReplyAddress(t *testing.T) {
+	defer withReplySecret("")()
+	expires := time.Now().Add(replyLifetime)
+	if replyAddress(1, 4, expires) != "" {
+		t.Error("replying by mail should be disabled without a secret")
+	}
+
+	*replySecret = "s3cret"
+	address := replyAddress(1, 4, expires)
+	if !strings.HasPrefix(address, "forum+1-4-") || !strings.HasSuffix(address, "@localhost") {
+		t.Errorf("wrong reply address %s", address)
+	}
+
+	userId, topicId, ok := parseReplyAddress(address)
+	if !ok || userId != 1 || topicId != 4 {
+		t.Error("reply address should parse")
+	}
+
+	if _, _, ok := parseReplyAddress(strings.Replace(address, "+1-4-", "+2-4-", 1)); ok {
+		t.Error("tampered reply address should not parse")
+	}
+
+	if _, _, ok := parseReplyAddress(replyAddress(1, 4, time.Now().Add(-time.Minute))); ok {
+		t.Error("expired reply address should not parse")
+	}
+
+	*replySecret = "other"
+	if _, _, ok := parseReplyAddress(address); ok {
+		t.Error("reply address signed with another secret should not parse")
+	}
+}
+
+func TestExtractReply(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"Sounds good.\r\n\r\nOn Mon, Nov 3, 2014 at 6:36 AM, Forum <forum@localhost> wrote:\r\n> the original\r\n", "Sounds good."},
+		{"Wrapped.\n\nOn Mon, Nov 3, 2014 at 6:36 AM, Forum\n<forum@localhost> wrote:\n> the original", "Wrapped."},
+		{"As the FAQ says\n\n> quoted on purpose\n\nI agree.\n\nOn Mon, Nov 3, 2014 at 6:36 AM, Forum <forum@localhost> wrote:\n> the original", "As the FAQ says\n\n> quoted on purpose\n\nI agree."},
+		{"Signed\n\n-- \nalice\nexample.com", "Signed"},
+		{"Outlook\n\n-----Original Message-----\nFrom: forum", "Outlook"},
+		{"Marker\n\n" + replyMarker + "\n\ntest wrote:\nhello", "Marker"},
+		{"Mobile\n\nSent from my phone\n", "Mobile"},
+		{"-- \nonly a signature", ""},
+	}
+
+	for _, test := range tests {
+		if out := extractReply(test.in); out != test.out {
+			t.Errorf("extractReply(%q) = %q, want %q", test.in, out, test.out)
+		}
+	}
+}
+
+func TestHandleInboundMail(t *testing.T) {
+	defer withReplySecret("s3cret")()
+
+	db, err := model.GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	markdown, err := newMarkdownRenderer("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &app{templates: testTemplates(t, markdown), db: db, sessions: sessions.NewCookieStore([]byte("test")), hub: newHub(), markdown: markdown}
+
+	address := replyAddress(2, 4, time.Now().Add(replyLifetime))
+	raw := "From: tester <test@test.com>\r\n" +
+		"To: " + address + "\r\n" +
+		"Subject: Re: 1 new posts\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/alternative; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Replying by mail =E2=9C=93\r\n" +
+		"\r\n" +
+		"On Tue, Nov 4, 2014 at 6:08 AM, Forum <forum@localhost> wrote:\r\n" +
+		"> blah blah blah blah\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/html; charset=utf-8\r\n" +
+		"\r\n" +
+		"<p>Replying by mail</p>\r\n" +
+		"--b--\r\n"
+
+	w := httptest.NewRecorder()
+	app.handleInboundMail(w, httptest.NewRequest("POST", "/mail/inbound", strings.NewReader(raw)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("reply should be posted: %d %s", w.Code, w.Body.String())
+	}
+
+	posts, err := model.FindPosts(db, "4", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := posts[len(posts)-1]
+	if last.Text != "Replying by mail ✓" || last.UserId != 2 {
+		t.Errorf("wrong post %q by %d", last.Text, last.UserId)
+	}
+
+	forged := strings.Replace(raw, address, "forum+1-4-0-00000000000000000000000000000000@localhost", 1)
+	w = httptest.NewRecorder()
+	app.handleInboundMail(w, httptest.NewRequest("POST", "/mail/inbound", strings.NewReader(forged)))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("forged reply address should be refused: %d", w.Code)
+	}
+
+	spoofed := strings.Replace(raw, "tester <test@test.com>", "mallory <mallory@example.com>", 1)
+	w = httptest.NewRecorder()
+	app.handleInboundMail(w, httptest.NewRequest("POST", "/mail/inbound", strings.NewReader(spoofed)))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("reply from another address should be refused: %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	app.handleInboundMail(w, httptest.NewRequest("POST", "/mail/inbound", strings.NewReader("not a mail")))
+	if w.Code != http.StatusBadRequest || strings.Contains(w.Body.String(), "malformed") {
+		t.Errorf("unreadable mail should be refused without its parse error: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -1,4 +1,19 @@
-CREATE TABLE forums(id INTEGER PRIMARY KEY, title varchar(255), description varchar(255));
-CREATE TABLE topics(id INTEGER PRIMARY KEY, title varchar(255), description varchar(255), forum_id integer, FOREIGN KEY(forum_id) REFERENCES forum(id));
+CREATE TABLE forums(id INTEGER PRIMARY KEY, title varchar(255), description varchar(255), topic_count INTEGER NOT NULL DEFAULT 0, post_count INTEGER NOT NULL DEFAULT 0, last_post_id INTEGER, last_post_at TIMESTAMP, FOREIGN KEY(last_post_id) REFERENCES posts(id));
+CREATE TABLE topics(id INTEGER PRIMARY KEY, title varchar(255), description varchar(255), forum_id integer, post_count INTEGER NOT NULL DEFAULT 0, last_post_id INTEGER, last_post_at TIMESTAMP, FOREIGN KEY(forum_id) REFERENCES forum(id), FOREIGN KEY(last_post_id) REFERENCES posts(id));
 CREATE TABLE users(id INTEGER PRIMARY KEY, username varchar(255), email varchar(255), password_hash blob);
-CREATE TABLE posts(id INTEGER PRIMARY KEY, text TEXT, published TIMESTAMP, topic_id INTEGER, user_id INTEGER, FOREIGN KEY(topic_id) REFERENCES topic(id), FOREIGN KEY(user_id) REFERENCES user(id));
+CREATE TABLE posts(id INTEGER PRIMARY KEY, text TEXT, published TIMESTAMP, topic_id INTEGER, user_id INTEGER, reply_to INTEGER, FOREIGN KEY(topic_id) REFERENCES topic(id), FOREIGN KEY(user_id) REFERENCES user(id), FOREIGN KEY(reply_to) REFERENCES posts(id));
+CREATE TABLE notifications(id INTEGER PRIMARY KEY, user_id INTEGER, kind varchar(255), post_id INTEGER, actor_id INTEGER, created TIMESTAMP, read BOOLEAN DEFAULT 0, topic_id INTEGER, FOREIGN KEY(user_id) REFERENCES user(id), FOREIGN KEY(post_id) REFERENCES posts(id), FOREIGN KEY(actor_id) REFERENCES user(id), FOREIGN KEY(topic_id) REFERENCES topics(id));
+CREATE TABLE subscriptions(id INTEGER PRIMARY KEY, user_id INTEGER, topic_id INTEGER, forum_id INTEGER, frequency varchar(255), last_sent TIMESTAMP, last_post_id INTEGER, FOREIGN KEY(user_id) REFERENCES user(id), FOREIGN KEY(topic_id) REFERENCES topic(id), FOREIGN KEY(forum_id) REFERENCES forum(id));
+CREATE TABLE conversations(id INTEGER PRIMARY KEY, subject varchar(255), creator_id INTEGER, created TIMESTAMP, FOREIGN KEY(creator_id) REFERENCES user(id));
+CREATE TABLE conversation_participants(conversation_id INTEGER, user_id INTEGER, last_read_message_id INTEGER DEFAULT 0, PRIMARY KEY(conversation_id, user_id), FOREIGN KEY(conversation_id) REFERENCES conversations(id), FOREIGN KEY(user_id) REFERENCES user(id));
+CREATE TABLE messages(id INTEGER PRIMARY KEY, conversation_id INTEGER, user_id INTEGER, text TEXT, published TIMESTAMP, FOREIGN KEY(conversation_id) REFERENCES conversations(id), FOREIGN KEY(user_id) REFERENCES user(id));
+CREATE TABLE blocks(user_id INTEGER, blocked_id INTEGER, PRIMARY KEY(user_id, blocked_id), FOREIGN KEY(user_id) REFERENCES user(id), FOREIGN KEY(blocked_id) REFERENCES user(id));
+CREATE TABLE webhooks(id INTEGER PRIMARY KEY, url varchar(255), secret varchar(255), events varchar(255), created TIMESTAMP);
+CREATE TABLE webhook_deliveries(id INTEGER PRIMARY KEY, webhook_id INTEGER, event varchar(255), payload TEXT, status varchar(255), attempts INTEGER DEFAULT 0, next_attempt TIMESTAMP, response_code INTEGER DEFAULT 0, error TEXT DEFAULT '', created TIMESTAMP, FOREIGN KEY(webhook_id) REFERENCES webhooks(id));
+CREATE TABLE attachments(id INTEGER PRIMARY KEY, post_id INTEGER, user_id INTEGER, filename varchar(255), content_type varchar(255), size INTEGER, storage_key varchar(255), created TIMESTAMP, FOREIGN KEY(post_id) REFERENCES posts(id), FOREIGN KEY(user_id) REFERENCES user(id));
+CREATE TABLE rendered_posts(post_id INTEGER PRIMARY KEY, version varchar(255), html TEXT, FOREIGN KEY(post_id) REFERENCES posts(id));
+CREATE TABLE link_previews(url varchar(255) PRIMARY KEY, title varchar(255), description TEXT, image varchar(255), status varchar(255), fetched TIMESTAMP);
+CREATE INDEX topics_forum_id ON topics(forum_id);
+CREATE INDEX posts_topic_published ON posts(topic_id, datetime(published), id);
+CREATE TABLE user_preferences(user_id INTEGER PRIMARY KEY, posts_per_page INTEGER NOT NULL DEFAULT 0, topics_per_page INTEGER NOT NULL DEFAULT 0, variant varchar(255) NOT NULL DEFAULT '', language varchar(255) NOT NULL DEFAULT '', FOREIGN KEY(user_id) REFERENCES users(id));
+CREATE TABLE settings(name varchar(255) PRIMARY KEY, value TEXT NOT NULL);
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mt2d2/forum/model"
+)
+
+func (app *app) handleSettings(w http.ResponseWriter, req *http.Request) {
+	themes, err := app.themes.names()
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	app.addBreadCrumb(req, "/admin/settings", app.T(req, "Settings"))
+
+	app.renderTemplate(w, req, "settings", &settingsView{Settings: app.site.get(), Themes: themes})
+}
+
+func (app *app) handleSaveSettings(w http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+
+	settings := model.NewSettings()
+	settings.Title = req.PostFormValue("Title")
+	settings.Logo = req.PostFormValue("Logo")
+	settings.Footer = req.PostFormValue("Footer")
+	settings.Theme = req.PostFormValue("Theme")
+
+	ok, errs := model.ValidateSettings(settings)
+	if !app.themes.exists(settings.Theme) {
+		ok = false
+		errs = append(errs, model.Errorf("Theme %s is not installed.", settings.Theme))
+	}
+	if !ok {
+		app.addErrorFlashes(w, req, errs)
+		http.Redirect(w, req, "/admin/settings", http.StatusFound)
+		return
+	}
+
+	err := model.SaveSettings(app.db, settings)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	saved, err := model.FindSettings(app.db)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+	app.site.set(*saved)
+
+	app.addSuccessFlash(w, req, "Settings saved.")
+	http.Redirect(w, req, "/admin/settings", http.StatusFound)
+}
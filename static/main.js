@@ -6,7 +6,7 @@ $(function() {
   }
 
   // inspired by http://stackoverflow.com/questions/22636819/confirm-delete-using-bootstrap-3-modal-box
-  $('button[name="removePost"]').on('click', function(e) {
+  $(document).on('click', 'button[name="removePost"]', function(e) {
     var $form = $(this).closest('form');
     e.preventDefault();
     $('#confirm-delete').modal({ keyboard: false })
@@ -15,6 +15,182 @@ $(function() {
       });
   });
 
+  // live topic updates, EventSource reconnects with Last-Event-ID by itself
+  var posts = $('.posts[data-events]');
+  if (posts.length && window.EventSource) {
+    var source = new EventSource(posts.data('events'));
+
+    source.addEventListener('post', function(e) {
+      var post = $(e.data);
+      if ($('#' + post.attr('id')).length) {
+        return;
+      }
+
+      if (posts.data('append')) {
+        post.hide().appendTo(posts).fadeIn('fast');
+      } else {
+        $('.newPosts').removeClass('hidden')
+          .find('a').attr('href', '/post/' + post.attr('id').replace('post-', ''));
+      }
+    });
+
+    source.addEventListener('delete', function(e) {
+      fadeOut($('#post-' + e.data));
+    });
+
+    source.addEventListener('reload', function() {
+      source.close();
+      window.location.reload();
+    });
+  }
+
+  // infinite scroll, loading the rest of the topic as the reader nears the end
+  var scrolling = $('.posts[data-more]');
+  if (scrolling.length) {
+    var loading = false;
+
+    var loadMore = function() {
+      var more = scrolling.attr('data-more');
+      if (loading || !more) {
+        return;
+      }
+      if ($(window).scrollTop() + $(window).height() < scrolling.offset().top + scrolling.height() - 600) {
+        return;
+      }
+
+      loading = true;
+      $.getJSON(more, function(page) {
+        $.each(page.rows || [], function(i, row) {
+          var post = $(row);
+          if (!$('#' + post.attr('id')).length) {
+            post.appendTo(scrolling);
+          }
+        });
+
+        if (page.next) {
+          scrolling.attr('data-more', more.replace(/after=[^&]*/, 'after=' + page.next));
+        } else {
+          // the end of the topic, new posts can be appended live from now on
+          scrolling.removeAttr('data-more').data('append', true);
+          $('.pageCount').addClass('hidden');
+        }
+      }).always(function() {
+        loading = false;
+      });
+    };
+
+    $(window).on('scroll resize', loadMore);
+    loadMore();
+  }
+
+  // presence, reconnecting a few seconds after the socket drops
+  var presence = $('[data-presence]');
+  if (presence.length && window.WebSocket) {
+    var typingTimer;
+    var socket;
+
+    var connect = function() {
+      var scheme = window.location.protocol === 'https:' ? 'wss://' : 'ws://';
+      socket = new WebSocket(scheme + window.location.host + presence.data('presence'));
+
+      socket.onmessage = function(e) {
+        var msg = JSON.parse(e.data);
+        if (msg.type === 'presence') {
+          presence.find('.viewers').text(msg.members ? msg.members.length : 0)
+            .attr('title', (msg.members || []).join(', '));
+        } else if (msg.type === 'forums') {
+          presence.find('.viewers[data-forum]').each(function() {
+            $(this).text(msg.forums[$(this).data('forum')] || 0);
+          });
+        } else if (msg.type === 'typing') {
+          var typing = presence.find('.typing');
+          typing.text(typing.data('message').replace('%s', msg.username));
+          window.clearTimeout(typingTimer);
+          typingTimer = window.setTimeout(function() {
+            presence.find('.typing').text('');
+          }, 6000);
+        }
+      };
+
+      socket.onclose = function() {
+        window.setTimeout(connect, 5000);
+      };
+    };
+    connect();
+
+    var lastTyped = 0;
+    presence.find('[data-typing]').on('input', function() {
+      var now = Date.now();
+      if (now - lastTyped > 3000 && socket.readyState === WebSocket.OPEN) {
+        lastTyped = now;
+        socket.send(JSON.stringify({ type: 'typing' }));
+      }
+    });
+  }
+
+  // editor toolbar, wrapping the selection or prefixing the selected lines
+  $('.editor').each(function() {
+    var editor = $(this);
+    var textarea = editor.find('[data-editor]');
+    var preview = editor.find('.editorPreview');
+    var previewTimer;
+
+    var replaceSelection = function(replace) {
+      var el = textarea[0];
+      var start = el.selectionStart, end = el.selectionEnd;
+      var text = el.value;
+      var result = replace(text.substring(start, end), text.substring(0, start));
+      el.value = text.substring(0, start) + result.text + text.substring(end);
+      el.focus();
+      el.setSelectionRange(start + result.start, start + result.end);
+      textarea.trigger('input');
+    };
+
+    editor.on('click', '[data-wrap]', function() {
+      var wrap = $(this).data('wrap');
+      replaceSelection(function(selected) {
+        return { text: wrap + selected + wrap, start: wrap.length, end: wrap.length + selected.length };
+      });
+    });
+
+    editor.on('click', '[data-prefix]', function() {
+      var prefix = $(this).data('prefix');
+      replaceSelection(function(selected, before) {
+        var lineStart = before.length === 0 || before.charAt(before.length - 1) === '\n';
+        var text = (lineStart ? prefix : '\n' + prefix) + selected.split('\n').join('\n' + prefix);
+        return { text: text, start: text.length - selected.length, end: text.length };
+      });
+    });
+
+    editor.on('click', '[data-link]', function() {
+      var open = $(this).data('link');
+      replaceSelection(function(selected) {
+        var label = selected || 'text';
+        var text = open + label + '](http://)';
+        return { text: text, start: text.length - 8, end: text.length - 1 };
+      });
+    });
+
+    var render = function() {
+      var form = editor.closest('form');
+      $.post(textarea.data('editor'), {
+        Text: textarea.val(),
+        ForumId: form.find('[name="ForumId"]').val(),
+        TopicId: form.find('[name="TopicId"]').val()
+      }, function(html) {
+        preview.html(html);
+      });
+    };
+
+    textarea.on('input', function() {
+      window.clearTimeout(previewTimer);
+      previewTimer = window.setTimeout(render, 300);
+    });
+    if (textarea.val()) {
+      render();
+    }
+  });
+
   var alertSuccess = $(".alert-success");
   window.setTimeout(function() {
     fadeOut(alertSuccess)
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// storage keeps the files of attachments. Keys are generated by newStorageKey.
+type storage interface {
+	Save(key string, r io.Reader) error
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+var storageKeyPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func newStorageKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// diskStorage keeps files in a directory, spread over subdirectories named
+// after the first two characters of their key.
+type diskStorage struct {
+	dir string
+}
+
+func newStorage() storage {
+	return &diskStorage{*attachmentDir}
+}
+
+func (s *diskStorage) path(key string) (string, error) {
+	if !storageKeyPattern.MatchString(key) {
+		return "", errors.New("invalid storage key " + key)
+	}
+	return filepath.Join(s.dir, key[:2], key), nil
+}
+
+func (s *diskStorage) Save(key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	return f.Close()
+}
+
+func (s *diskStorage) Open(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *diskStorage) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDiskStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forum-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &diskStorage{dir}
+	key, err := newStorageKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Save(key, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(key, strings.NewReader("again")); err == nil {
+		t.Error("saving should not overwrite an existing file")
+	}
+
+	f, err := s.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(content) != "hello" {
+		t.Error("wrong content")
+	}
+
+	if _, err := s.Open("../../etc/passwd"); err == nil {
+		t.Error("keys must not escape the storage directory")
+	}
+
+	err = s.Delete(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open(key); err == nil {
+		t.Error("deleted file should be gone")
+	}
+	if err := s.Delete(key); err != nil {
+		t.Error("deleting a missing file should not fail")
+	}
+}
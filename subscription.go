@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mt2d2/forum/model"
+)
+
+func (app *app) handleSubscribeTopic(w http.ResponseWriter, req *http.Request) {
+	app.handleSubscription(w, req, "/topic/", model.SubscribeTopic, "Subscribed to topic.")
+}
+
+func (app *app) handleUnsubscribeTopic(w http.ResponseWriter, req *http.Request) {
+	app.handleSubscription(w, req, "/topic/", func(db *sql.DB, userId, topicId int, _ string) error {
+		return model.UnsubscribeTopic(db, userId, topicId)
+	}, "Unsubscribed from topic.")
+}
+
+func (app *app) handleSubscribeForum(w http.ResponseWriter, req *http.Request) {
+	app.handleSubscription(w, req, "/forum/", model.SubscribeForum, "Subscribed to forum.")
+}
+
+func (app *app) handleUnsubscribeForum(w http.ResponseWriter, req *http.Request) {
+	app.handleSubscription(w, req, "/forum/", func(db *sql.DB, userId, forumId int, _ string) error {
+		return model.UnsubscribeForum(db, userId, forumId)
+	}, "Unsubscribed from forum.")
+}
+
+func (app *app) handleSubscription(w http.ResponseWriter, req *http.Request, pathToRedirect string,
+	update func(db *sql.DB, userId, id int, frequency string) error, success string) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	session, _ := app.sessions.Get(req, "forumSession")
+	userID, _ := session.Values["user_id"].(int)
+
+	err = update(app.db, userID, id, model.FrequencyImmediate)
+	if err != nil {
+		app.addErrorFlash(w, req, err)
+	} else {
+		app.addSuccessFlash(w, req, success)
+	}
+
+	http.Redirect(w, req, pathToRedirect+strconv.Itoa(id), http.StatusFound)
+}
+
+func (app *app) handleSubscriptions(w http.ResponseWriter, req *http.Request) {
+	session, _ := app.sessions.Get(req, "forumSession")
+	userID, _ := session.Values["user_id"].(int)
+
+	subscriptions, err := model.FindSubscriptions(app.db, userID)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	app.addBreadCrumb(req, "/subscriptions", app.T(req, "Subscriptions"))
+
+	app.renderTemplate(w, req, "subscriptions", &subscriptionsView{
+		Subscriptions: subscriptions,
+		Frequencies:   []string{model.FrequencyImmediate, model.FrequencyDaily, model.FrequencyWeekly},
+	})
+}
+
+func (app *app) handleSaveSubscriptions(w http.ResponseWriter, req *http.Request) {
+	session, _ := app.sessions.Get(req, "forumSession")
+	userID, _ := session.Values["user_id"].(int)
+
+	req.ParseForm()
+
+	subscriptions, err := model.FindSubscriptions(app.db, userID)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	for _, subscription := range subscriptions {
+		id := strconv.Itoa(subscription.Id)
+		frequency := req.PostFormValue("Frequency-" + id)
+		if frequency != "" && frequency != subscription.Frequency {
+			err = model.SetSubscriptionFrequency(app.db, userID, subscription.Id, frequency)
+			if err != nil {
+				app.addErrorFlash(w, req, err)
+			}
+		}
+	}
+
+	for _, value := range req.PostForm["Remove"] {
+		if id, err := strconv.Atoi(value); err == nil {
+			model.DeleteSubscription(app.db, userID, id)
+		}
+	}
+
+	app.addSuccessFlash(w, req, "Subscriptions saved.")
+	http.Redirect(w, req, "/subscriptions", http.StatusFound)
+}
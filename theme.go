@@ -0,0 +1,166 @@
+package main
+
+import (
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mt2d2/forum/model"
+)
+
+// A theme is a directory in the themes directory. Templates in its templates
+// directory replace the forum's own of the same name, and its static
+// directory is served at /themes/<theme>/static/, with a style.css in it
+// included after the forum's stylesheet.
+type themes struct {
+	dir      string
+	markdown *markdownRenderer
+	// read reads the forum's own templates
+	read func(name string) (string, error)
+
+	mu        sync.Mutex
+	templates map[string]*template.Template
+}
+
+func newThemes(dir string, markdown *markdownRenderer, read func(name string) (string, error)) *themes {
+	return &themes{dir: dir, markdown: markdown, read: read, templates: make(map[string]*template.Template)}
+}
+
+// names lists the installed themes.
+func (themes *themes) names() ([]string, error) {
+	files, err := ioutil.ReadDir(themes.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// exists tells if a theme is installed, the empty theme being the forum's own
+// look.
+func (themes *themes) exists(theme string) bool {
+	if theme == "" {
+		return true
+	}
+	if strings.ContainsAny(theme, `/\`) || strings.HasPrefix(theme, ".") {
+		return false
+	}
+
+	info, err := os.Stat(filepath.Join(themes.dir, theme))
+	return err == nil && info.IsDir()
+}
+
+func (themes *themes) templatePath(theme, name string) string {
+	return filepath.Join(themes.dir, theme, "templates", name)
+}
+
+// reader reads the templates of a theme, falling back to read for those the
+// theme does not replace.
+func (themes *themes) reader(theme string, read func(name string) (string, error)) func(name string) (string, error) {
+	if theme == "" {
+		return read
+	}
+
+	return func(name string) (string, error) {
+		source, err := ioutil.ReadFile(themes.templatePath(theme, name))
+		if os.IsNotExist(err) {
+			return read(name)
+		}
+		return string(source), err
+	}
+}
+
+// load returns the templates of a theme, parsed on first use.
+func (themes *themes) load(theme string) (*template.Template, error) {
+	themes.mu.Lock()
+	defer themes.mu.Unlock()
+
+	if templates, ok := themes.templates[theme]; ok {
+		return templates, nil
+	}
+
+	templates, err := parseTemplates(themes.markdown, themes.reader(theme, themes.read))
+	if err != nil {
+		return nil, err
+	}
+	themes.templates[theme] = templates
+	return templates, nil
+}
+
+// stylesheet returns the url of a theme's stylesheet, or "" if it has none.
+func (themes *themes) stylesheet(theme string) string {
+	if theme == "" {
+		return ""
+	}
+	if _, err := os.Stat(filepath.Join(themes.dir, theme, "static", "style.css")); err != nil {
+		return ""
+	}
+	return "/themes/" + theme + "/static/style.css"
+}
+
+// assets serves the static directories of the themes and nothing else from
+// the themes directory.
+func (themes *themes) assets() http.Handler {
+	return http.FileServer(themeAssets(themes.dir))
+}
+
+type themeAssets string
+
+func (dir themeAssets) Open(name string) (http.File, error) {
+	parts := strings.SplitN(strings.TrimPrefix(path.Clean("/"+name), "/"), "/", 3)
+	if len(parts) != 3 || parts[1] != "static" {
+		return nil, os.ErrNotExist
+	}
+
+	file, err := http.Dir(dir).Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// no directory listings
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return nil, os.ErrNotExist
+	}
+	return file, nil
+}
+
+// siteSettings keeps the settings of the site in memory, as every page shows
+// them.
+type siteSettings struct {
+	mu       sync.RWMutex
+	settings model.Settings
+}
+
+func (site *siteSettings) get() model.Settings {
+	if site == nil {
+		return *model.NewSettings()
+	}
+
+	site.mu.RLock()
+	defer site.mu.RUnlock()
+	return site.settings
+}
+
+func (site *siteSettings) set(settings model.Settings) {
+	site.mu.Lock()
+	defer site.mu.Unlock()
+	site.settings = settings
+}
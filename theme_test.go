@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mt2d2/forum/model"
+)
+
+// writeTheme creates a theme with a pager.html override and a stylesheet in a
+// temporary themes directory.
+func writeTheme(t *testing.T, theme string) string {
+	dir, err := ioutil.TempDir("", "themes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sub := range []string{"templates", "static"} {
+		err = os.MkdirAll(filepath.Join(dir, theme, sub), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, theme, "templates", "pager.html"), []byte("themed pager"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, theme, "static", "style.css"), []byte("body {}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestThemes(t *testing.T) {
+	dir := writeTheme(t, "plain")
+	defer os.RemoveAll(dir)
+
+	markdown, err := newMarkdownRenderer("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	themes := newThemes(dir, markdown, newTemplateReloader("templates", nil, markdown).read)
+
+	names, err := themes.names()
+	if err != nil || !reflect.DeepEqual(names, []string{"plain"}) {
+		t.Errorf("names returned %v, %v", names, err)
+	}
+
+	for theme, exists := range map[string]bool{"": true, "plain": true, "missing": false, "../plain": false, ".": false} {
+		if themes.exists(theme) != exists {
+			t.Errorf("exists(%q) should be %v", theme, exists)
+		}
+	}
+
+	if stylesheet := themes.stylesheet("plain"); stylesheet != "/themes/plain/static/style.css" {
+		t.Errorf("wrong stylesheet %q", stylesheet)
+	}
+	if stylesheet := themes.stylesheet(""); stylesheet != "" {
+		t.Errorf("the forum's own look should have no extra stylesheet, got %q", stylesheet)
+	}
+
+	templates, err := themes.load("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page bytes.Buffer
+	err = templates.ExecuteTemplate(&page, "pager.html", newPager("/forum/1", 1, 2))
+	if err != nil || page.String() != "themed pager" {
+		t.Errorf("overridden template rendered %q, %v", page.String(), err)
+	}
+	if templates.Lookup("forum.html") == nil {
+		t.Error("templates the theme does not override should be the forum's")
+	}
+	if again, _ := themes.load("plain"); again != templates {
+		t.Error("the theme's templates were parsed again")
+	}
+}
+
+func TestThemeAssets(t *testing.T) {
+	dir := writeTheme(t, "plain")
+	defer os.RemoveAll(dir)
+
+	assets := newThemes(dir, nil, nil).assets()
+	for path, status := range map[string]int{
+		"/plain/static/style.css":               http.StatusOK,
+		"/plain/static/":                        http.StatusNotFound,
+		"/plain/templates/pager.html":           http.StatusNotFound,
+		"/plain/static/../templates/pager.html": http.StatusNotFound,
+		"/plain/":                               http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		assets.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != status {
+			t.Errorf("%s returned %d, want %d", path, w.Code, status)
+		}
+	}
+}
+
+func TestLoadTemplatesOfTheme(t *testing.T) {
+	dir := writeTheme(t, "plain")
+	defer os.RemoveAll(dir)
+
+	markdown, err := newMarkdownRenderer("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := newTemplateReloader("templates", nil, markdown).read
+	themes := newThemes(dir, markdown, read)
+
+	for _, dev := range []bool{false, true} {
+		app := &app{themes: themes, site: &siteSettings{settings: *model.NewSettings()}}
+		if dev {
+			app.reloader = newTemplateReloader("templates", themes, markdown)
+		} else {
+			app.templates = testTemplates(t, markdown)
+		}
+
+		render := func() string {
+			templates, err := app.loadTemplates(english)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var page bytes.Buffer
+			templates.ExecuteTemplate(&page, "pager.html", newPager("/forum/1", 1, 2))
+			return page.String()
+		}
+
+		if render() == "themed pager" {
+			t.Errorf("dev %v: the forum's own look rendered the theme", dev)
+		}
+
+		settings := app.site.get()
+		settings.Theme = "plain"
+		app.site.set(settings)
+		if page := render(); page != "themed pager" {
+			t.Errorf("dev %v: the active theme rendered %q", dev, page)
+		}
+	}
+}
@@ -4,14 +4,71 @@ import (
 	"math"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/Schema"
 	"github.com/gorilla/mux"
 	"github.com/mt2d2/forum/model"
 )
 
-func numberOfTopicPages(topic model.Topic) int {
-	return int(math.Ceil(float64(topic.PostCount) / float64(limitPosts)))
+// maxThreadDepth caps how far replies are indented in the threaded view.
+const maxThreadDepth = 6
+
+func numberOfTopicPages(topic model.Topic, postsPerPage int) int {
+	return int(math.Ceil(float64(topic.PostCount) / float64(postsPerPage)))
+}
+
+type threadedPost struct {
+	model.Post
+	Depth int
+}
+
+func (post threadedPost) Indent() int {
+	depth := post.Depth
+	if depth > maxThreadDepth {
+		depth = maxThreadDepth
+	}
+	return depth * 30
+}
+
+// flatPosts keeps posts in published order, none of them indented.
+func flatPosts(posts []model.Post) []threadedPost {
+	flat := make([]threadedPost, 0, len(posts))
+	for _, post := range posts {
+		flat = append(flat, threadedPost{post, 0})
+	}
+	return flat
+}
+
+// threadPosts orders posts depth first by reply, keeping siblings in
+// published order. Replies to posts that are not in the list become roots.
+func threadPosts(posts []model.Post) []threadedPost {
+	ids := make(map[int]bool, len(posts))
+	for _, post := range posts {
+		ids[post.Id] = true
+	}
+
+	roots := make([]model.Post, 0)
+	children := make(map[int][]model.Post)
+	for _, post := range posts {
+		if post.ReplyTo != -1 && ids[post.ReplyTo] {
+			children[post.ReplyTo] = append(children[post.ReplyTo], post)
+		} else {
+			roots = append(roots, post)
+		}
+	}
+
+	threaded := make([]threadedPost, 0, len(posts))
+	var walk func(posts []model.Post, depth int)
+	walk = func(posts []model.Post, depth int) {
+		for _, post := range posts {
+			threaded = append(threaded, threadedPost{post, depth})
+			walk(children[post.Id], depth+1)
+		}
+	}
+	walk(roots, 0)
+
+	return threaded
 }
 
 func (app *app) handleTopic(w http.ResponseWriter, req *http.Request) {
@@ -26,34 +83,132 @@ func (app *app) handleTopic(w http.ResponseWriter, req *http.Request) {
 
 	topic, err := model.FindOneTopic(app.db, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		app.handleError(w, req, err)
 		return
 	}
 
-	numberOfPages := numberOfTopicPages(*topic)
-	pageIndicies := make([]int, numberOfPages)
-	for i := 0; i < numberOfPages; i++ {
-		pageIndicies[i] = i + 1
-	}
+	postsPerPage, _ := app.pageSizes(req)
+	numberOfPages := numberOfTopicPages(*topic, postsPerPage)
 	currentPage := int(pageOffset + 1)
+	if currentPage > 1 && currentPage > numberOfPages {
+		app.handleError(w, req, &model.NotFoundError{What: "page " + strconv.Itoa(currentPage) + " of topic " + id})
+		return
+	}
+
+	posts, err := model.FindPosts(app.db, id, postsPerPage, pageOffset*postsPerPage)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	attachments, err := model.FindAttachments(app.db, postIds(posts))
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	html, err := app.renderPosts(posts)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	app.addBreadCrumb(req, "/forum/"+strconv.Itoa(topic.Forum.Id), topic.Forum.Title)
+	app.addBreadCrumb(req, "/topic/"+strconv.Itoa(topic.Id), topic.Title)
+	if currentPage > 1 {
+		app.addBreadCrumb(req, "/topic/"+strconv.Itoa(topic.Id)+"/page/"+strconv.Itoa(currentPage), app.T(req, "page %d", currentPage))
+	}
+
+	results := &topicView{
+		layout:      layout{Feed: "/topic/" + strconv.Itoa(topic.Id) + "/feed"},
+		Topic:       topic,
+		Posts:       flatPosts(posts),
+		Attachments: attachments,
+		HTML:        html,
+		Pager:       newPager("/topic/"+strconv.Itoa(topic.Id), currentPage, numberOfPages),
+		LastPage:    currentPage >= numberOfPages,
+		Viewers:     app.presence.roomMembers(topicRoom(topic.Id)),
+	}
+	if currentPage < numberOfPages && len(posts) > 0 {
+		// the rest of the topic is loaded while scrolling
+		results.More = "/api/topic/" + strconv.Itoa(topic.Id) + "/posts?rows=1&after=" + posts[len(posts)-1].Cursor().String()
+	}
+
+	session, _ := app.sessions.Get(req, "forumSession")
+	if userID, ok := session.Values["user_id"].(int); ok {
+		results.Subscribed = model.IsSubscribedToTopic(app.db, userID, topic.Id)
+	}
 
-	posts, err := model.FindPosts(app.db, id, limitPosts, pageOffset*limitPosts)
+	app.renderTemplate(w, req, "topic", results)
+}
+
+// handleThreadedTopic shows a topic as threads, at most -threaded-posts posts
+// at a time. Replies to posts of an earlier page start threads of their own.
+func (app *app) handleThreadedTopic(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+	pageOffset := 0
+	if page, ok := vars["page"]; ok {
+		if val, err := strconv.Atoi(page); err == nil {
+			pageOffset = val - 1
+		}
+	}
+
+	topic, err := model.FindOneTopic(app.db, id)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	numberOfPages := numberOfTopicPages(*topic, *threadedPosts)
+	currentPage := pageOffset + 1
+	if currentPage > 1 && currentPage > numberOfPages {
+		app.handleError(w, req, &model.NotFoundError{What: "threaded page " + strconv.Itoa(currentPage) + " of topic " + id})
+		return
+	}
+
+	posts, err := model.FindPosts(app.db, id, *threadedPosts, pageOffset**threadedPosts)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	attachments, err := model.FindAttachments(app.db, postIds(posts))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		app.handleError(w, req, err)
 		return
 	}
 
-	app.addBreadCrumb("/forum/"+strconv.Itoa(topic.Forum.Id), topic.Forum.Title)
-	app.addBreadCrumb("/topic/"+strconv.Itoa(topic.Id), topic.Title)
+	html, err := app.renderPosts(posts)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	app.addBreadCrumb(req, "/forum/"+strconv.Itoa(topic.Forum.Id), topic.Forum.Title)
+	app.addBreadCrumb(req, "/topic/"+strconv.Itoa(topic.Id), topic.Title)
+	app.addBreadCrumb(req, "/topic/"+strconv.Itoa(topic.Id)+"/threaded", app.T(req, "threaded"))
 	if currentPage > 1 {
-		app.addBreadCrumb("/topic/"+strconv.Itoa(topic.Id)+"/page/"+strconv.Itoa(currentPage), "page "+strconv.Itoa(currentPage))
+		app.addBreadCrumb(req, "/topic/"+strconv.Itoa(topic.Id)+"/threaded/page/"+strconv.Itoa(currentPage), app.T(req, "page %d", currentPage))
 	}
 
-	results := make(map[string]interface{})
-	results["topic"] = topic
-	results["posts"] = posts
-	results["pageIndicies"] = pageIndicies
-	results["currentPage"] = currentPage
+	results := &topicView{
+		layout:      layout{Feed: "/topic/" + strconv.Itoa(topic.Id) + "/feed"},
+		Topic:       topic,
+		Posts:       threadPosts(posts),
+		Attachments: attachments,
+		HTML:        html,
+		Threaded:    true,
+		Viewers:     app.presence.roomMembers(topicRoom(topic.Id)),
+	}
+	if currentPage < numberOfPages {
+		results.ShowMore = "/topic/" + strconv.Itoa(topic.Id) + "/threaded/page/" + strconv.Itoa(currentPage+1)
+	}
+
+	session, _ := app.sessions.Get(req, "forumSession")
+	if userID, ok := session.Values["user_id"].(int); ok {
+		results.Subscribed = model.IsSubscribedToTopic(app.db, userID, topic.Id)
+	}
 
 	app.renderTemplate(w, req, "topic", results)
 }
@@ -64,40 +219,65 @@ func (app *app) handleAddTopic(w http.ResponseWriter, req *http.Request) {
 
 	forum, err := model.FindOneForum(app.db, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		app.handleError(w, req, err)
 		return
 	}
-	app.addBreadCrumb("/forum/"+strconv.Itoa(forum.Id), forum.Title)
-	app.addBreadCrumb("/forum/"+strconv.Itoa(forum.Id)+"/add", "Add Topic")
+	app.addBreadCrumb(req, "/forum/"+strconv.Itoa(forum.Id), forum.Title)
+	app.addBreadCrumb(req, "/forum/"+strconv.Itoa(forum.Id)+"/add", app.T(req, "Add Topic"))
 
-	results := make(map[string]interface{})
-	results["ForumId"] = id
-	app.renderTemplate(w, req, "addTopic", results)
+	app.renderTemplate(w, req, "addTopic", &addTopicView{ForumId: id})
 }
 
 func (app *app) handleSaveTopic(w http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
 
+	// Text is the optional first post, saved with the topic
+	text := req.PostFormValue("Text")
+	delete(req.PostForm, "Text")
+
 	topic := model.NewTopic()
 	decoder := schema.NewDecoder()
 	err := decoder.Decode(topic, req.PostForm)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		app.handleError(w, req, model.NewValidationError(err))
 		return
 	}
 
 	ok, errors := model.ValidateTopic(app.db, topic)
+
+	var post *model.Post
+	if strings.TrimSpace(text) != "" {
+		post = model.NewPost()
+		post.Text = text
+		session, _ := app.sessions.Get(req, "forumSession")
+		if userID, ok := session.Values["user_id"].(int); ok {
+			post.UserId = userID
+		}
+
+		if postOk, postErrors := model.ValidateFirstPost(app.db, post); !postOk {
+			ok = false
+			errors = append(errors, postErrors...)
+		}
+	}
+
 	if !ok {
 		app.addErrorFlashes(w, req, errors)
 		http.Redirect(w, req, "/forum/"+req.PostFormValue("ForumId")+"/add", http.StatusFound)
 		return
 	}
 
-	err = model.SaveTopic(app.db, topic)
+	err = model.SaveTopicWithPost(app.db, topic, post)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		app.handleError(w, req, err)
+		return
+	}
+	app.queueWebhook(model.EventTopicCreated, app.topicWebhookData(topic))
+
+	if post == nil {
+		http.Redirect(w, req, "/forum/"+req.PostFormValue("ForumId"), http.StatusFound)
 		return
 	}
+	app.publishPost(post)
 
-	http.Redirect(w, req, "/forum/"+req.PostFormValue("ForumId"), http.StatusFound)
+	http.Redirect(w, req, "/topic/"+strconv.Itoa(topic.Id), http.StatusFound)
 }
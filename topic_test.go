@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/mt2d2/forum/model"
+)
+
+func TestThreadedTopicPages(t *testing.T) {
+	db, err := model.GetMockupDB()
+	defer db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limit := *threadedPosts
+	defer func() { *threadedPosts = limit }()
+	*threadedPosts = 3
+
+	markdown, err := newMarkdownRenderer("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &app{templates: testTemplates(t, markdown), db: db, sessions: sessions.NewCookieStore([]byte("test")), presence: newPresence(), markdown: markdown, previews: newLinkPreviewer("", 0)}
+	router := mux.NewRouter()
+	router.HandleFunc("/topic/{id:[0-9]+}/threaded", app.handleThreadedTopic)
+	router.HandleFunc("/topic/{id:[0-9]+}/threaded/page/{page:[0-9]+}", app.handleThreadedTopic)
+	router.NotFoundHandler = http.HandlerFunc(app.handleNotFound)
+
+	get := func(url string) (int, string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w.Code, w.Body.String()
+	}
+
+	code, body := get("/topic/1/threaded")
+	if code != http.StatusOK {
+		t.Fatalf("first threaded page returned %d", code)
+	}
+	if rows := strings.Count(body, `class="row postRow"`); rows != 3 {
+		t.Errorf("first threaded page shows %d posts, want 3", rows)
+	}
+	if !strings.Contains(body, `href="/topic/1/threaded/page/2"`) {
+		t.Errorf("first threaded page does not link to the next:\n%s", body)
+	}
+
+	topic, err := model.FindOneTopic(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := numberOfTopicPages(*topic, 3)
+	code, body = get("/topic/1/threaded/page/" + strconv.Itoa(last))
+	if code != http.StatusOK || strings.Contains(body, "/threaded/page/"+strconv.Itoa(last+1)) {
+		t.Errorf("last threaded page returned %d, links past the end: %v", code, strings.Contains(body, "Show more"))
+	}
+
+	if code, _ = get("/topic/1/threaded/page/" + strconv.Itoa(last+1)); code != http.StatusNotFound {
+		t.Errorf("threaded page past the end returned %d", code)
+	}
+}
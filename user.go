@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/gorilla/mux"
@@ -10,10 +11,9 @@ import (
 )
 
 func (app *app) handleRegister(w http.ResponseWriter, req *http.Request) {
-	app.addBreadCrumb("/user/add", "Register")
+	app.addBreadCrumb(req, "/user/add", app.T(req, "Register"))
 
-	results := make(map[string]interface{})
-	app.renderTemplate(w, req, "register", results)
+	app.renderTemplate(w, req, "register", &registerView{})
 }
 
 func (app *app) saveRegister(w http.ResponseWriter, req *http.Request) {
@@ -45,15 +45,14 @@ func (app *app) saveRegister(w http.ResponseWriter, req *http.Request) {
 		http.Redirect(w, req, "/user/add", http.StatusFound)
 		return
 	}
+	app.queueWebhook(model.EventUserRegistered, app.userWebhookData(user))
 
 	http.Redirect(w, req, "/", http.StatusFound)
 }
 
 func (app *app) handleLogin(w http.ResponseWriter, req *http.Request) {
-	app.addBreadCrumb("/user/login", "Login")
-	results := make(map[string]interface{})
-	results["Referer"] = req.Referer()
-	app.renderTemplate(w, req, "login", results)
+	app.addBreadCrumb(req, "/user/login", app.T(req, "Login"))
+	app.renderTemplate(w, req, "login", &loginView{Referer: req.Referer()})
 }
 
 func (app *app) saveLogin(w http.ResponseWriter, req *http.Request) {
@@ -113,13 +112,82 @@ func (app *app) handleLogout(w http.ResponseWriter, req *http.Request) {
 	http.Redirect(w, req, toRedirect, http.StatusFound)
 }
 
+func (app *app) handleProfile(w http.ResponseWriter, req *http.Request) {
+	username := mux.Vars(req)["username"]
+
+	profile, err := model.FindOneUserByUsername(app.db, username)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	postsPerPage, _ := app.pageSizes(req)
+	posts, err := model.FindPostsByUser(app.db, profile.Id, postsPerPage, 0)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	html, err := app.renderPosts(posts)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	app.addBreadCrumb(req, "/user/profile/"+url.PathEscape(profile.Username), profile.Username)
+
+	results := &profileView{Profile: profile, Posts: posts, HTML: html}
+
+	session, _ := app.sessions.Get(req, "forumSession")
+	if userID, ok := session.Values["user_id"].(int); ok {
+		results.Blocked = model.HasBlocked(app.db, userID, profile.Id)
+	}
+	app.renderTemplate(w, req, "profile", results)
+}
+
+func isAdmin(username string) bool {
+	for _, admin := range strings.Split(*admins, ",") {
+		if admin = strings.TrimSpace(admin); admin != "" && admin == username {
+			return true
+		}
+	}
+	return false
+}
+
+// handleAdminRequired only lets users named in the -admins flag through,
+// others get a 403.
+func (app *app) handleAdminRequired(nextHandler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return app.handleLoginRequired(func(w http.ResponseWriter, req *http.Request) {
+		session, _ := app.sessions.Get(req, "forumSession")
+		userID, _ := session.Values["user_id"].(int)
+
+		user, err := model.FindOneUserById(app.db, userID)
+		if err != nil {
+			app.handleError(w, req, err)
+			return
+		}
+		if !isAdmin(user.Username) {
+			app.handleError(w, req, &model.ForbiddenError{Err: errors.New("Must be an administrator!")})
+			return
+		}
+
+		nextHandler(w, req)
+	}, "")
+}
+
+// handleLoginRequired sends users that are not logged in to pathToRedirect
+// followed by the id of the request, or to the login page if pathToRedirect
+// is empty.
 func (app *app) handleLoginRequired(nextHandler func(http.ResponseWriter, *http.Request), pathToRedirect string) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		session, _ := app.sessions.Get(req, "forumSession")
 		if _, ok := session.Values["user_id"]; !ok {
-			newPath := pathToRedirect
-			if id, ok := mux.Vars(req)["id"]; ok {
-				newPath += "/" + id
+			newPath := "/user/login"
+			if pathToRedirect != "" {
+				newPath = pathToRedirect
+				if id, ok := mux.Vars(req)["id"]; ok {
+					newPath += "/" + id
+				}
 			}
 
 			app.addErrorFlash(w, req, errors.New("Must be logged in!"))
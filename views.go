@@ -0,0 +1,180 @@
+package main
+
+import (
+	"html/template"
+
+	"github.com/mt2d2/forum/model"
+)
+
+// layout is the data header.html and footer.html render around every page.
+// Pages embed it and renderTemplate fills it in for the reader, except for
+// Feed, which the page sets.
+type layout struct {
+	Site                model.Settings
+	Stylesheet          string
+	Variant             string
+	Language            string
+	BreadCrumbs         []breadCrumb
+	ErrorFlashes        []interface{}
+	SuccessFlashes      []interface{}
+	User                *model.User
+	Admin               bool
+	Notifications       []model.Notification
+	UnreadNotifications int
+	UnreadMessages      int
+	Feed                string
+}
+
+func (l *layout) base() *layout {
+	return l
+}
+
+// view is the data of a page template, one type per template.
+type view interface {
+	base() *layout
+}
+
+type errorView struct {
+	layout
+	Status    int
+	Title     string
+	Message   string
+	RequestId string
+}
+
+type indexView struct {
+	layout
+	Forums  []model.Forum
+	Viewers map[int]int
+}
+
+type forumView struct {
+	layout
+	Forum      *model.Forum
+	Topics     []model.Topic
+	Pager      []pagerLink
+	Viewers    int
+	Subscribed bool
+}
+
+type topicView struct {
+	layout
+	Topic       *model.Topic
+	Posts       []threadedPost
+	Attachments map[int][]model.Attachment
+	HTML        map[int]template.HTML
+	Threaded    bool
+	Pager       []pagerLink
+	LastPage    bool
+	More        string
+	ShowMore    string
+	Viewers     int
+	Subscribed  bool
+}
+
+// postRowView is the data of the post.html partial, a post of a topic as the
+// reader of the page sees it.
+type postRowView struct {
+	Post        threadedPost
+	User        *model.User
+	Admin       bool
+	Topic       *model.Topic
+	Threaded    bool
+	Attachments map[int][]model.Attachment
+	HTML        map[int]template.HTML
+}
+
+func postRow(post threadedPost, page *topicView) postRowView {
+	return postRowView{post, page.User, page.Admin, page.Topic, page.Threaded, page.Attachments, page.HTML}
+}
+
+type addTopicView struct {
+	layout
+	ForumId string
+	Text    string
+}
+
+type addPostView struct {
+	layout
+	TopicId           string
+	ReplyTo           int
+	Text              string
+	MaxAttachments    int
+	MaxAttachmentSize string
+	AttachmentTypes   string
+}
+
+type registerView struct {
+	layout
+}
+
+type loginView struct {
+	layout
+	Referer string
+}
+
+type profileView struct {
+	layout
+	Profile model.User
+	Posts   []model.Post
+	HTML    map[int]template.HTML
+	Blocked bool
+}
+
+type preferencesView struct {
+	layout
+	Preferences   *model.Preferences
+	PostsPerPage  int
+	TopicsPerPage int
+	MinPageSize   int
+	MaxPageSize   int
+	Variants      []string
+	Locales       []*locale
+}
+
+type messagesView struct {
+	layout
+	Conversations []model.Conversation
+	Outbox        bool
+}
+
+type addConversationView struct {
+	layout
+	To string
+}
+
+type conversationView struct {
+	layout
+	Conversation *model.Conversation
+	Messages     []model.Message
+}
+
+type notificationsView struct {
+	layout
+	AllNotifications []model.Notification
+}
+
+type subscriptionsView struct {
+	layout
+	Subscriptions []model.Subscription
+	Frequencies   []string
+}
+
+type webhooksView struct {
+	layout
+	Webhooks []model.Webhook
+	Events   []string
+}
+
+type webhookView struct {
+	layout
+	Webhook    model.Webhook
+	Events     string
+	Deliveries []model.WebhookDelivery
+}
+
+type settingsView struct {
+	layout
+	Settings model.Settings
+	Themes   []string
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"html/template"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/mt2d2/forum/model"
+)
+
+// testTemplates parses the templates in the templates directory.
+func testTemplates(t *testing.T, markdown *markdownRenderer) *template.Template {
+	templates, err := parseTemplates(markdown, newTemplateReloader("templates", nil, markdown).read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return templates
+}
+
+// sampleViews has data for every template, filled in so that as many of the
+// template's branches as possible are taken.
+func sampleViews(user *model.User) map[string]interface{} {
+	published := time.Date(2016, 1, 2, 15, 4, 0, 0, time.UTC)
+	author := &model.User{Id: 2, Username: "author"}
+	forum := &model.Forum{Id: 1, Title: "Forum", Description: "about things", TopicCount: 1, PostCount: 2, LastPostId: 2, LastPostAt: published}
+	topic := &model.Topic{Id: 1, Title: "Topic", Description: "a topic", ForumId: 1, PostCount: 2, LastPostId: 2, LastPostAt: published, Forum: forum}
+	first := model.Post{Id: 1, Text: "first", Published: published, TopicId: 1, UserId: author.Id, ReplyTo: -1, User: author}
+	reply := model.Post{Id: 2, Text: "reply", Published: published, TopicId: 1, UserId: 1, ReplyTo: 1, User: &model.User{Id: 1, Username: "reader"}, Parent: &first}
+	posts := []model.Post{first, reply}
+	html := map[int]template.HTML{1: "<p>first</p>", 2: "<p>reply</p>"}
+	attachments := map[int][]model.Attachment{
+		1: {{Id: 1, PostId: 1, Filename: "a.png", ContentType: "image/png", Size: 1024}},
+		2: {{Id: 2, PostId: 2, Filename: "b.pdf", ContentType: "application/pdf", Size: 2048}},
+	}
+	notification := model.Notification{Id: 1, Kind: model.NotificationReply, Created: published, Actor: author, Topic: topic}
+	message := model.Message{Id: 1, ConversationId: 1, Text: "hello", Published: published, User: author}
+	conversation := &model.Conversation{Id: 1, Subject: "Hello", Participants: []model.User{*author}, LastMessage: &message, UnreadCount: 1}
+	webhook := model.Webhook{Id: 1, URL: "https://example.com/hook", Events: model.WebhookEvents}
+	pager := newPager("/topic/1", 2, 5)
+
+	site := model.Settings{Title: "Forum", Logo: "/static/logo.png", Footer: "Be nice.", Theme: "slate"}
+	base := layout{
+		Site:                site,
+		Stylesheet:          "/themes/slate/static/style.css",
+		Variant:             model.VariantDark,
+		Language:            "en",
+		BreadCrumbs:         []breadCrumb{{"/", "Index"}, {"/forum/1", "Forum"}},
+		ErrorFlashes:        []interface{}{"Something failed."},
+		SuccessFlashes:      []interface{}{"Something worked."},
+		User:                user,
+		Admin:               user != nil,
+		Notifications:       []model.Notification{notification},
+		UnreadNotifications: 1,
+		UnreadMessages:      1,
+		Feed:                "/feed",
+	}
+	topicPage := &topicView{base, topic, threadPosts(posts), attachments, html, true, pager, true, "/api/topic/1/posts", "/topic/1/threaded/page/2", 1, true}
+
+	return map[string]interface{}{
+		"header.html":          &registerView{base},
+		"footer.html":          &registerView{base},
+		"error.html":           &errorView{base, 500, "Internal Server Error", "Something went wrong.", "0123456789abcdef"},
+		"index.html":           &indexView{base, []model.Forum{*forum}, map[int]int{1: 2}},
+		"forum.html":           &forumView{base, forum, []model.Topic{*topic}, pager, 2, true},
+		"topic.html":           topicPage,
+		"post.html":            postRow(threadedPost{reply, 1}, topicPage),
+		"pager.html":           pager,
+		"editor.html":          &addTopicView{base, "1", "text"},
+		"addTopic.html":        &addTopicView{base, "1", ""},
+		"addPost.html":         &addPostView{base, "1", 1, "> quoted", 5, "5 MB", "image/png"},
+		"register.html":        &registerView{base},
+		"login.html":           &loginView{base, "/topic/1"},
+		"profile.html":         &profileView{base, *author, posts, html, true},
+		"preferences.html":     &preferencesView{base, &model.Preferences{UserId: 1, PostsPerPage: 20}, 10, 10, model.MinPageSize, model.MaxPageSize, model.Variants, []*locale{english}},
+		"messages.html":        &messagesView{base, []model.Conversation{*conversation}, false},
+		"addConversation.html": &addConversationView{base, "author"},
+		"conversation.html":    &conversationView{base, conversation, []model.Message{message}},
+		"notification.html":    notification,
+		"notifications.html":   &notificationsView{base, []model.Notification{notification}},
+		"subscriptions.html": &subscriptionsView{base, []model.Subscription{
+			{Id: 1, TopicId: 1, Frequency: model.FrequencyDaily, Topic: topic},
+			{Id: 2, ForumId: 1, Frequency: model.FrequencyImmediate, Forum: forum},
+		}, []string{model.FrequencyImmediate, model.FrequencyDaily, model.FrequencyWeekly}},
+		"webhooks.html": &webhooksView{base, []model.Webhook{webhook}, model.WebhookEvents},
+		"webhook.html": &webhookView{base, webhook, "topic.created", []model.WebhookDelivery{
+			{Id: 1, WebhookId: 1, Event: model.EventPostCreated, Status: "pending", Attempts: 1, NextAttempt: published, ResponseCode: 500, Error: "failed", Created: published},
+		}},
+		"settings.html": &settingsView{base, site, []string{"slate"}},
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	markdown, err := newMarkdownRenderer("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := newTemplateReloader("templates", nil, markdown).read
+	themes := newThemes("themes", markdown, read)
+	names, err := themes.names()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, theme := range append([]string{""}, names...) {
+		templates, err := parseTemplates(markdown, themes.reader(theme, read))
+		if err != nil {
+			t.Fatalf("theme %q: %v", theme, err)
+		}
+
+		for _, user := range []*model.User{{Id: 1, Username: "reader"}, nil} {
+			samples := sampleViews(user)
+			for _, tmpl := range templates.Templates() {
+				if tmpl.Name() == "" {
+					continue
+				}
+
+				data, ok := samples[tmpl.Name()]
+				if !ok {
+					t.Errorf("no sample data for %s", tmpl.Name())
+					continue
+				}
+				err := templates.ExecuteTemplate(ioutil.Discard, tmpl.Name(), data)
+				if err != nil {
+					t.Errorf("executing %s of theme %q as %v: %v", tmpl.Name(), theme, user, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mt2d2/forum/model"
+)
+
+const (
+	// webhookMaxAttempts is the number of tries before a delivery is given up.
+	webhookMaxAttempts = 8
+	// webhookBackoff is the wait after the first failed attempt, doubling
+	// with every attempt after that.
+	webhookBackoff = time.Minute
+	// webhookBatch is the number of deliveries sent per tick.
+	webhookBatch = 50
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+type webhookPayload struct {
+	Event   string      `json:"event"`
+	Created time.Time   `json:"created"`
+	Data    interface{} `json:"data"`
+}
+
+// signWebhook returns the hex encoded HMAC-SHA256 of a payload, sent in the
+// X-Forum-Signature header so receivers can check it came from us.
+func signWebhook(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// queueWebhook queues an event for delivery to every subscribed webhook.
+// Failures are only logged, the request that caused the event goes on.
+func (app *app) queueWebhook(event string, data interface{}) {
+	payload, err := json.Marshal(webhookPayload{event, time.Now().UTC(), data})
+	if err != nil {
+		log.Println("webhook:", err)
+		return
+	}
+
+	err = model.QueueWebhookDeliveries(app.db, event, string(payload))
+	if err != nil {
+		log.Println("webhook:", err)
+	}
+}
+
+func (app *app) postWebhookData(post *model.Post) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       post.Id,
+		"topic_id": post.TopicId,
+		"user_id":  post.UserId,
+		"text":     post.Text,
+		"url":      app.baseURL() + "/post/" + strconv.Itoa(post.Id),
+	}
+}
+
+func (app *app) topicWebhookData(topic *model.Topic) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          topic.Id,
+		"forum_id":    topic.ForumId,
+		"title":       topic.Title,
+		"description": topic.Description,
+		"url":         app.baseURL() + "/topic/" + strconv.Itoa(topic.Id),
+	}
+}
+
+func (app *app) userWebhookData(user *model.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       user.Id,
+		"username": user.Username,
+		"url":      app.baseURL() + "/user/profile/" + url.PathEscape(user.Username),
+	}
+}
+
+// runWebhooks periodically sends the queued webhook deliveries that are due.
+func (app *app) runWebhooks(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if err := app.sendWebhooks(now.UTC()); err != nil {
+			log.Println("webhook:", err)
+		}
+	}
+}
+
+func (app *app) sendWebhooks(now time.Time) error {
+	deliveries, err := model.FindDueWebhookDeliveries(app.db, now, webhookBatch)
+	if err != nil {
+		return err
+	}
+
+	for i := range deliveries {
+		err := app.sendWebhook(&deliveries[i], now)
+		if err != nil {
+			log.Println("webhook:", err)
+		}
+	}
+
+	return nil
+}
+
+// sendWebhook attempts a delivery once and records the outcome. Failed
+// deliveries are retried with exponential backoff until webhookMaxAttempts.
+func (app *app) sendWebhook(delivery *model.WebhookDelivery, now time.Time) error {
+	delivery.Attempts++
+	delivery.ResponseCode = 0
+	delivery.Error = ""
+
+	err := postWebhook(delivery)
+	if err == nil {
+		delivery.Status = model.DeliveryDelivered
+	} else {
+		delivery.Error = err.Error()
+		if delivery.Attempts >= webhookMaxAttempts {
+			delivery.Status = model.DeliveryFailed
+		} else {
+			delivery.NextAttempt = now.Add(webhookBackoff << uint(delivery.Attempts-1))
+		}
+	}
+
+	return model.SaveWebhookAttempt(app.db, delivery)
+}
+
+func postWebhook(delivery *model.WebhookDelivery) error {
+	payload := []byte(delivery.Payload)
+
+	req, err := http.NewRequest("POST", delivery.Webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "forum-webhooks")
+	req.Header.Set("X-Forum-Event", delivery.Event)
+	req.Header.Set("X-Forum-Delivery", strconv.Itoa(delivery.Id))
+	req.Header.Set("X-Forum-Signature", signWebhook(delivery.Webhook.Secret, payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	delivery.ResponseCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (app *app) handleWebhooks(w http.ResponseWriter, req *http.Request) {
+	webhooks, err := model.FindWebhooks(app.db)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	app.addBreadCrumb(req, "/admin/webhooks", app.T(req, "Webhooks"))
+
+	app.renderTemplate(w, req, "webhooks", &webhooksView{Webhooks: webhooks, Events: model.WebhookEvents})
+}
+
+func (app *app) handleSaveWebhook(w http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+
+	webhook := model.NewWebhook()
+	webhook.URL = req.PostFormValue("URL")
+	webhook.Secret = req.PostFormValue("Secret")
+	webhook.Events = req.PostForm["Events"]
+
+	ok, errors := model.ValidateWebhook(webhook)
+	if !ok {
+		app.addErrorFlashes(w, req, errors)
+		http.Redirect(w, req, "/admin/webhooks", http.StatusFound)
+		return
+	}
+
+	err := model.SaveWebhook(app.db, webhook)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	app.addSuccessFlash(w, req, "Added webhook.")
+	http.Redirect(w, req, "/admin/webhooks", http.StatusFound)
+}
+
+func (app *app) handleDeleteWebhook(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	err = model.DeleteWebhook(app.db, id)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	app.addSuccessFlash(w, req, "Deleted webhook.")
+	http.Redirect(w, req, "/admin/webhooks", http.StatusFound)
+}
+
+// handleWebhook shows the delivery log of a webhook.
+func (app *app) handleWebhook(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	webhook, err := model.FindOneWebhook(app.db, id)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	deliveries, err := model.FindWebhookDeliveries(app.db, webhook.Id, limitWebhookDeliveries)
+	if err != nil {
+		app.handleError(w, req, err)
+		return
+	}
+
+	app.addBreadCrumb(req, "/admin/webhooks", app.T(req, "Webhooks"))
+	app.addBreadCrumb(req, "/admin/webhooks/"+id, webhook.URL)
+
+	app.renderTemplate(w, req, "webhook", &webhookView{
+		Webhook:    webhook,
+		Events:     strings.Join(webhook.Events, ", "),
+		Deliveries: deliveries,
+	})
+}
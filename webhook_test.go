@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mt2d2/forum/model"
+)
+
+func newWebhookTestApp(t *testing.T, handler http.HandlerFunc) (*app, *httptest.Server) {
+	db, err := model.GetMockupDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(handler)
+
+	webhook := model.NewWebhook()
+	webhook.URL = server.URL
+	webhook.Secret = "s3cret"
+	webhook.Events = []string{model.EventPostCreated}
+	err = model.SaveWebhook(db, webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &app{db: db}, server
+}
+
+func TestSendWebhook(t *testing.T) {
+	var (
+		signature string
+		payload   webhookPayload
+	)
+
+	app, server := newWebhookTestApp(t, func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if req.Header.Get("X-Forum-Signature") == signWebhook("s3cret", body) {
+			signature = req.Header.Get("X-Forum-Signature")
+		}
+		json.Unmarshal(body, &payload)
+	})
+	defer server.Close()
+	defer app.db.Close()
+
+	post := model.NewPost()
+	post.Id = 42
+	app.queueWebhook(model.EventPostCreated, app.postWebhookData(post))
+
+	now := time.Now().UTC()
+	err := app.sendWebhooks(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if signature == "" {
+		t.Error("payload should be signed with the webhook secret")
+	}
+	if payload.Event != model.EventPostCreated || payload.Data.(map[string]interface{})["id"] != float64(42) {
+		t.Errorf("wrong payload: %v", payload)
+	}
+
+	deliveries, err := model.FindWebhookDeliveries(app.db, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != model.DeliveryDelivered || deliveries[0].ResponseCode != 200 {
+		t.Error("delivery should be logged as delivered")
+	}
+}
+
+func TestSendWebhookRetries(t *testing.T) {
+	app, server := newWebhookTestApp(t, func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	})
+	defer server.Close()
+	defer app.db.Close()
+
+	app.queueWebhook(model.EventPostCreated, nil)
+
+	now := time.Now().UTC()
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		err := app.sendWebhooks(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deliveries, err := model.FindWebhookDeliveries(app.db, 2, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		delivery := deliveries[0]
+		if delivery.Attempts != attempt || delivery.ResponseCode != http.StatusServiceUnavailable {
+			t.Fatalf("attempt %d should be logged: %v", attempt, delivery)
+		}
+
+		if attempt < webhookMaxAttempts {
+			backoff := webhookBackoff << uint(attempt-1)
+			if delivery.Status != model.DeliveryPending || delivery.NextAttempt.Sub(now) != backoff {
+				t.Fatalf("attempt %d should be retried after %s: %v", attempt, backoff, delivery)
+			}
+
+			// nothing is due before the backoff has passed
+			due, _ := model.FindDueWebhookDeliveries(app.db, now, webhookBatch)
+			if len(due) != 0 {
+				t.Fatal("retry should not be due yet")
+			}
+			now = delivery.NextAttempt
+		} else if delivery.Status != model.DeliveryFailed {
+			t.Error("delivery should fail after the last attempt")
+		}
+	}
+}